@@ -0,0 +1,163 @@
+/*
+This file is part of Tessernote.
+
+Tessernote is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+Tessernote is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with Tessernote.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package tessernote
+
+import (
+	"appengine"
+	"errors"
+	"github.com/oschmid/tessernote/hashtag"
+)
+
+var ErrMissingAlias = errors.New("tessernote: missing alias")
+
+// Alias maps a hashtag to another Tag. e.g. #js and #JS can both be aliases of #javascript.
+type Alias struct {
+	Name string // spelling that is an alias
+	Tag  string // name of the canonical Tag
+}
+
+// AddAlias makes hashtags called name refer to the Tag called tag. Notes that already use name are moved to tag.
+func (notebook *Notebook) AddAlias(name, tag string, c appengine.Context) (Alias, error) {
+	if !IsTagName(name) || !IsTagName(tag) {
+		return Alias{}, ErrInvalidTag
+	}
+	tag = notebook.resolveAlias(tag)
+	if hashtag.Fold(name) == hashtag.Fold(tag) {
+		return Alias{}, ErrInvalidTag
+	}
+	alias := Alias{Name: name, Tag: tag}
+	err := notebook.runInTransaction(c, func(tc appengine.Context) error {
+		allTags, err := notebook.Tags(tc)
+		if err != nil {
+			return err
+		}
+		notebook.removeAlias(name)
+		for i := range notebook.Aliases {
+			if hashtag.Fold(notebook.Aliases[i].Tag) == hashtag.Fold(name) {
+				notebook.Aliases[i].Tag = tag // no chains of aliases
+			}
+		}
+		notebook.Aliases = append(notebook.Aliases, alias)
+
+		// move notes from the aliased tag
+		i := indexOfTag(allTags, name)
+		if i >= 0 {
+			notebook.Order.Cleanup(allTags[i : i+1])
+			err = notebook.rewriteNotesOf(allTags[i], keepBody, tc)
+			if err != nil {
+				return err
+			}
+		}
+		return notebook.save(tc)
+	})
+	return alias, err
+}
+
+// RemoveAlias stops hashtags called name from referring to another Tag. Notes that use name get their own Tag again.
+func (notebook *Notebook) RemoveAlias(name string, c appengine.Context) error {
+	err := notebook.runInTransaction(c, func(tc appengine.Context) error {
+		allTags, err := notebook.Tags(tc)
+		if err != nil {
+			return err
+		}
+		alias, ok := notebook.removeAlias(name)
+		if !ok {
+			return ErrMissingAlias
+		}
+
+		// move notes back from the canonical tag
+		i := indexOfTag(allTags, alias.Tag)
+		if i >= 0 {
+			notebook.Order.Cleanup(allTags[i : i+1])
+			err = notebook.rewriteNotesOf(allTags[i], keepBody, tc)
+			if err != nil {
+				return err
+			}
+		}
+		return notebook.save(tc)
+	})
+	return err
+}
+
+// keepBody leaves a Note's body unchanged so rewriteNotesOf only updates its Tags.
+func keepBody(body string) string {
+	return body
+}
+
+// removeAlias removes the alias called name from this Notebook and returns it.
+func (notebook *Notebook) removeAlias(name string) (Alias, bool) {
+	name = hashtag.Fold(name)
+	for i, alias := range notebook.Aliases {
+		if hashtag.Fold(alias.Name) == name {
+			notebook.Aliases = append(notebook.Aliases[:i], notebook.Aliases[i+1:]...)
+			return alias, true
+		}
+	}
+	return Alias{}, false
+}
+
+// resolveAlias returns the name of the Tag that name is an alias of, or name if it isn't an alias.
+func (notebook *Notebook) resolveAlias(name string) string {
+	folded := hashtag.Fold(name)
+	for _, alias := range notebook.Aliases {
+		if hashtag.Fold(alias.Name) == folded {
+			return alias.Tag
+		}
+	}
+	return name
+}
+
+// aliasesOf returns the names of the aliases of the Tag called name.
+func (notebook *Notebook) aliasesOf(name string) []string {
+	var names []string
+	folded := hashtag.Fold(name)
+	for _, alias := range notebook.Aliases {
+		if hashtag.Fold(alias.Tag) == folded {
+			names = append(names, alias.Name)
+		}
+	}
+	return names
+}
+
+// renameAliases makes aliases of the Tag called name refer to the Tag called newName.
+func (notebook *Notebook) renameAliases(name, newName string) {
+	folded := hashtag.Fold(name)
+	for i := range notebook.Aliases {
+		if hashtag.Fold(notebook.Aliases[i].Tag) == folded {
+			notebook.Aliases[i].Tag = newName
+		}
+	}
+}
+
+// parseTagNames parses a string for hashtags and resolves aliases to the names of their Tags.
+func (notebook *Notebook) parseTagNames(text string) []string {
+	names := ParseTagNames(text)
+	for i, name := range names {
+		names[i] = notebook.resolveAlias(name)
+	}
+	return names
+}
+
+// spellingOf returns the spelling of tag a Note with hashtags called names is counted for: the first of names that
+// is a spelling of tag, or tag's name if the Note only refers to it through aliases.
+func spellingOf(names []string, tag Tag) string {
+	if i := indexOfTagName(names, tag.Name); i >= 0 {
+		return names[i]
+	}
+	return tag.Name
+}
@@ -0,0 +1,148 @@
+/*
+This file is part of Tessernote.
+
+Tessernote is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+Tessernote is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with Tessernote.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package tessernote
+
+import (
+	"github.com/oschmid/appenginetesting"
+	"testing"
+)
+
+func TestResolveAlias(t *testing.T) {
+	notebook := &Notebook{Aliases: []Alias{
+		{"js", "javascript"},
+		{"JScript", "javascript"},
+		{"golang", "go"},
+		{"a", "b"}, // a cycle can't be added with AddAlias, resolving it still takes one step
+		{"b", "a"},
+	}}
+	tests := []struct {
+		name, tag string
+	}{
+		{"js", "javascript"},
+		{"JS", "javascript"},
+		{"jscript", "javascript"},
+		{"javascript", "javascript"},
+		{"Golang", "go"},
+		{"go", "go"},
+		{"a", "b"},
+		{"b", "a"},
+		{"other", "other"},
+	}
+	for _, test := range tests {
+		if tag := notebook.resolveAlias(test.name); tag != test.tag {
+			t.Errorf("%s: expected=%s actual=%s", test.name, test.tag, tag)
+		}
+	}
+	if aliases := notebook.aliasesOf("JavaScript"); len(aliases) != 2 || aliases[0] != "js" || aliases[1] != "JScript" {
+		t.Errorf("expected=[js JScript] actual=%v", aliases)
+	}
+}
+
+func TestSpellingOf(t *testing.T) {
+	tag := Tag{Name: "javascript"}
+	tests := []struct {
+		names    []string
+		spelling string
+	}{
+		{[]string{"JavaScript"}, "JavaScript"},
+		{[]string{"web", "javascript", "JavaScript"}, "javascript"},
+		{[]string{"web"}, "javascript"}, // only through an alias
+		{nil, "javascript"},
+	}
+	for _, test := range tests {
+		if spelling := spellingOf(test.names, tag); spelling != test.spelling {
+			t.Errorf("%v: expected=%s actual=%s", test.names, test.spelling, spelling)
+		}
+	}
+}
+
+func TestAddAlias(t *testing.T) {
+	c, err := appenginetesting.NewContext(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	notebook, ids := newTestNotebook(t, c, "alias", "a #js", "b #javascript", "c #JS #web")
+
+	// js is a real tag until it's made an alias, its notes move to the canonical tag
+	checkTagNotes(t, c, notebook, "js", 2)
+	_, err = notebook.AddAlias("js", "javascript", c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkBodies(t, c, notebook, ids, "a #js", "b #javascript", "c #JS #web")
+	tags, err := notebook.TagsFrom([]string{"JS"}, c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tags[0].Name != "javascript" || len(tags[0].NoteKeys) != 3 {
+		t.Errorf("expected javascript with 3 notes, actual=%#v", tags[0])
+	}
+	tags, err = notebook.Tags(c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if indexOfTag(tags, "js") >= 0 {
+		t.Errorf("expected js to be merged into javascript, actual=%v", tags)
+	}
+
+	// new notes with the alias go to the canonical tag
+	_, err = notebook.Put(Note{Body: "d #Js"}, c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkTagNotes(t, c, notebook, "javascript", 4)
+
+	// aliases can't form cycles or point to themselves
+	for _, test := range []struct{ name, tag string }{{"javascript", "js"}, {"javascript", "JS"}, {"web", "Web"}} {
+		_, err = notebook.AddAlias(test.name, test.tag, c)
+		if err != ErrInvalidTag {
+			t.Errorf("AddAlias(%s, %s): expected=%v actual=%v", test.name, test.tag, ErrInvalidTag, err)
+		}
+	}
+
+	// an alias of an alias refers to the canonical tag, and aliasing the canonical tag moves its aliases
+	alias, err := notebook.AddAlias("ecmascript", "js", c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if alias.Tag != "javascript" {
+		t.Errorf("expected=javascript actual=%s", alias.Tag)
+	}
+	_, err = notebook.AddAlias("javascript", "web", c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"js", "ecmascript", "javascript"} {
+		if tag := notebook.resolveAlias(name); tag != "web" {
+			t.Errorf("%s: expected=web actual=%s", name, tag)
+		}
+	}
+	checkTagNotes(t, c, notebook, "web", 4)
+
+	// removing an alias gives its notes their own tag again
+	err = notebook.RemoveAlias("js", c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkTagNotes(t, c, notebook, "js", 3)
+	err = notebook.RemoveAlias("js", c)
+	if err != ErrMissingAlias {
+		t.Errorf("expected=%v actual=%v", ErrMissingAlias, err)
+	}
+}
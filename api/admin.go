@@ -0,0 +1,112 @@
+/*
+This file is part of Tessernote.
+
+Tessernote is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+Tessernote is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with Tessernote.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package api
+
+import (
+	"appengine"
+	"appengine/datastore"
+	"appengine/user"
+	"encoding/json"
+	"github.com/oschmid/tessernote"
+	"net/http"
+)
+
+const (
+	AdminURL = "/admin/"
+	FsckURL  = AdminURL + "fsck" // GET reports problems, POST repairs them
+)
+
+// FsckReport is the JSON output of a consistency check.
+type FsckReport struct {
+	Repaired bool
+	Problems []tessernote.Problem
+}
+
+// serveAdmin handles requests to Tessernote's admin API. Administrators of the application may check the Notebook
+// of any user, everyone else (including programs with an API token) only their own.
+func serveAdmin(w http.ResponseWriter, r *http.Request) {
+	c := appengine.NewContext(r)
+	if r.URL.Path == tessernote.UpdateModelsURL && r.Method == "POST" {
+		serveUpdateModels(w, r, c)
+		return
+	}
+	if r.URL.Path != FsckURL || (r.Method != "GET" && r.Method != "POST") {
+		http.NotFound(w, r)
+		return
+	}
+	var notebook *tessernote.Notebook
+	if id := r.FormValue("user"); id != "" {
+		if user.Current(c) == nil {
+			http.Error(w, "", http.StatusUnauthorized)
+			return
+		}
+		if !user.IsAdmin(c) {
+			http.Error(w, "", http.StatusForbidden)
+			return
+		}
+		var err error
+		notebook, err = tessernote.NotebookOf(id, c)
+		if err == datastore.ErrNoSuchEntity {
+			http.Error(w, "no such notebook", http.StatusNotFound)
+			return
+		} else if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+	} else if notebook = authorizedNotebook(w, r, c, tessernote.APIToken); notebook == nil {
+		return
+	}
+	Fsck(w, r, c, notebook)
+}
+
+// Fsck checks notebook and writes the problems it found in JSON format to w. POST requests also repair them.
+func Fsck(w http.ResponseWriter, r *http.Request, c appengine.Context, notebook *tessernote.Notebook) {
+	var err error
+	report := FsckReport{Repaired: r.Method == "POST"}
+	report.Problems, err = notebook.Check(report.Repaired, c)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if report.Problems == nil {
+		report.Problems = make([]tessernote.Problem, 0)
+	}
+	reply, err := json.Marshal(report)
+	if err != nil {
+		c.Errorf("marshaling fsck report (%d): %s", len(report.Problems), err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(reply)
+}
+
+// serveUpdateModels handles the tasks that apply changes to Notes to the models of the Notebook in the notebook
+// parameter, see tessernote.UpdateModels. Failed tasks are retried by the task queue.
+func serveUpdateModels(w http.ResponseWriter, r *http.Request, c appengine.Context) {
+	if r.Header.Get("X-AppEngine-QueueName") == "" { // removed from requests that don't come from the task queue
+		http.Error(w, "", http.StatusForbidden)
+		return
+	}
+	err := tessernote.UpdateModels(r.FormValue("notebook"), c)
+	if err == datastore.ErrNoSuchEntity {
+		return // deleted since
+	} else if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
@@ -0,0 +1,116 @@
+/*
+This file is part of Tessernote.
+
+Tessernote is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+Tessernote is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with Tessernote.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package api
+
+import (
+	"appengine"
+	"appengine/user"
+	"encoding/json"
+	"github.com/oschmid/tessernote"
+	"net/http"
+	"regexp"
+)
+
+const (
+	AliasesURL = "/aliases/"
+)
+
+var validAliasesURL = regexp.MustCompile("^" + AliasesURL + tagPattern + "?$")
+
+// serveAliases handles requests to Tessernote's RESTful tag alias API
+func serveAliases(w http.ResponseWriter, r *http.Request) {
+	c := appengine.NewContext(r)
+	u := user.Current(c)
+	if u == nil {
+		http.Error(w, "", http.StatusUnauthorized)
+		return
+	}
+	notebook, err := tessernote.CurrentNotebook(c)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if r.URL.Path == AliasesURL {
+		switch r.Method {
+		case "GET":
+			GetAllAliases(w, c, notebook)
+		default:
+			http.NotFound(w, r)
+		}
+	} else {
+		switch r.Method {
+		case "PUT":
+			PutAlias(w, r, c, notebook)
+		case "DELETE":
+			DeleteAlias(w, r, c, notebook)
+		default:
+			http.NotFound(w, r)
+		}
+	}
+}
+
+// GetAllAliases writes a JSON formatted list of the aliases in the authorized User's Notebook to w.
+func GetAllAliases(w http.ResponseWriter, c appengine.Context, notebook *tessernote.Notebook) {
+	reply, err := json.Marshal(notebook.Aliases)
+	if err != nil {
+		c.Errorf("marshaling aliases (%d): %s", len(notebook.Aliases), err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Write(reply)
+}
+
+// PutAlias makes the hashtag named in the URL an alias of the Tag given in the JSON formatted Alias input. Notes
+// using the alias are moved to that Tag. The Alias is written in JSON format to w.
+func PutAlias(w http.ResponseWriter, r *http.Request, c appengine.Context, notebook *tessernote.Notebook) {
+	name := r.URL.Path[len(AliasesURL):]
+	body, err := readRequestBody(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	var alias tessernote.Alias
+	err = json.Unmarshal(body, &alias)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	alias, err = notebook.AddAlias(name, alias.Tag, c)
+	if err != nil {
+		writeTagError(w, err)
+		return
+	}
+	reply, err := json.Marshal(alias)
+	if err != nil {
+		c.Errorf("marshaling alias (%#v): %s", alias, err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Write(reply)
+}
+
+// DeleteAlias removes the alias named in the URL. Uses w to write true if the alias was removed.
+func DeleteAlias(w http.ResponseWriter, r *http.Request, c appengine.Context, notebook *tessernote.Notebook) {
+	name := r.URL.Path[len(AliasesURL):]
+	err := notebook.RemoveAlias(name, c)
+	if err != nil {
+		writeTagError(w, err)
+		return
+	}
+	w.Write([]byte("true"))
+}
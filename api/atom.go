@@ -0,0 +1,131 @@
+/*
+This file is part of Tessernote.
+
+Tessernote is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+Tessernote is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with Tessernote.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package api
+
+import (
+	"encoding/xml"
+	"github.com/oschmid/tessernote"
+	"github.com/oschmid/tessernote/convert"
+	"io"
+	"strings"
+	"time"
+)
+
+// entryIDPrefix starts the IDs of feed entries and feeds. Entry IDs end with the Note's ID (its encoded datastore
+// Key) so they stay the same when a Note is edited.
+const entryIDPrefix = "tag:tessernote.appspot.com,2013:"
+
+// atomFeed is an Atom (RFC 4287) feed.
+type atomFeed struct {
+	XMLName xml.Name    `xml:"http://www.w3.org/2005/Atom feed"`
+	Title   string      `xml:"title"`
+	ID      string      `xml:"id"`
+	Updated string      `xml:"updated"`
+	Author  atomAuthor  `xml:"author"`
+	Links   []atomLink  `xml:"link"`
+	Entries []atomEntry `xml:"entry"`
+}
+
+type atomAuthor struct {
+	Name string `xml:"name"`
+}
+
+type atomLink struct {
+	Rel  string `xml:"rel,attr,omitempty"`
+	Type string `xml:"type,attr,omitempty"`
+	Href string `xml:"href,attr"`
+}
+
+type atomEntry struct {
+	Title      string         `xml:"title"`
+	ID         string         `xml:"id"`
+	Published  string         `xml:"published"`
+	Updated    string         `xml:"updated"`
+	Links      []atomLink     `xml:"link"`
+	Categories []atomCategory `xml:"category"`
+	Content    atomContent    `xml:"content"`
+}
+
+type atomCategory struct {
+	Term string `xml:"term,attr"`
+}
+
+type atomContent struct {
+	Type string `xml:"type,attr"`
+	Body string `xml:",chardata"`
+}
+
+// newAtomEntry returns an Atom entry for note with its body as HTML content.
+func newAtomEntry(note tessernote.Note, href string, html string) atomEntry {
+	entry := atomEntry{
+		Title:     noteTitle(note),
+		ID:        noteEntryID(note),
+		Published: atomTime(note.Created),
+		Updated:   atomTime(note.LastModified),
+		Content:   atomContent{Type: "html", Body: html},
+	}
+	if href != "" {
+		entry.Links = []atomLink{{Rel: "alternate", Type: "text/html", Href: href}}
+	}
+	for _, name := range convert.TagNames(note.Body) {
+		entry.Categories = append(entry.Categories, atomCategory{name})
+	}
+	return entry
+}
+
+// writeAtom writes feed to w as XML.
+func writeAtom(w io.Writer, feed atomFeed) error {
+	_, err := io.WriteString(w, xml.Header)
+	if err != nil {
+		return err
+	}
+	encoder := xml.NewEncoder(w)
+	encoder.Indent("", "  ")
+	return encoder.Encode(feed)
+}
+
+// noteEntryID returns the ID of a Note's entries in feeds.
+func noteEntryID(note tessernote.Note) string {
+	return entryIDPrefix + "note/" + note.ID
+}
+
+// noteTitle returns the first line of a Note without Markdown heading marks, shortened to 80 characters.
+func noteTitle(note tessernote.Note) string {
+	title := strings.TrimSpace(note.Body)
+	if i := strings.Index(title, "\n"); i >= 0 {
+		title = title[:i]
+	}
+	if strings.HasPrefix(title, "#") {
+		if trimmed := strings.TrimLeft(title, "#"); strings.HasPrefix(trimmed, " ") {
+			title = trimmed
+		}
+	}
+	title = strings.TrimSpace(title)
+	if runes := []rune(title); len(runes) > 80 {
+		title = string(runes[:79]) + "…"
+	}
+	if title == "" {
+		title = "Untitled"
+	}
+	return title
+}
+
+// atomTime formats t as an RFC 3339 date.
+func atomTime(t time.Time) string {
+	return t.UTC().Format(time.RFC3339)
+}
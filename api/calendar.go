@@ -0,0 +1,192 @@
+/*
+This file is part of Tessernote.
+
+Tessernote is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+Tessernote is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with Tessernote.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package api
+
+import (
+	"appengine"
+	"bytes"
+	"github.com/oschmid/tessernote"
+	"github.com/oschmid/tessernote/convert"
+	"github.com/oschmid/tessernote/hashtag"
+	"net/http"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// The calendar (RFC 5545) has an all-day event for every dated hashtag (a key-value hashtag with a date as value,
+// e.g. #due:2013-05-01) of a Note, and a todo for every Note with #todo, due on its first date instead of having
+// an event for it. Todos with #done or #status:done are completed. /calendar.ics?tags=work,go only has the Notes matching the tags (see
+// parseSelectedTags). Calendar apps can't log in so the calendar is authorized with the User's feed token.
+//
+// UIDs are made from Note IDs (and the key of the dated hashtag for events) so editing a Note, or changing its
+// date, updates its event instead of adding a new one.
+const (
+	CalendarURL   = "/calendar.ics"
+	todoTag       = "todo"
+	uidDomain     = "@tessernote.appspot.com"
+	icalTime      = "20060102T150405Z"
+	icalDate      = "20060102"
+	icalLineLimit = 75
+)
+
+var doneTags = []string{"done", "status:done"}
+
+// noteDate is a dated hashtag.
+type noteDate struct {
+	Key  string // folded
+	Date time.Time
+}
+
+// serveCalendar handles requests for the iCalendar of the authorized User's dated Notes and todos.
+func serveCalendar(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		http.NotFound(w, r)
+		return
+	}
+	c := appengine.NewContext(r)
+	notebook := authorizedNotebook(w, r, c, tessernote.FeedToken)
+	if notebook == nil {
+		return
+	}
+	var notes []tessernote.Note
+	var err error
+	if tags := r.FormValue("tags"); tags != "" {
+		var groups [][]tessernote.Tag
+		groups, err = notebook.TagsMatching(splitSelectors(tags), c)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+		notes, err = tessernote.MatchingNotes(groups, c)
+	} else {
+		notes, err = notebook.Notes(c)
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "text/calendar; charset=utf-8")
+	w.Write(calendar(notes))
+}
+
+// calendar returns the events and todos of notes as an iCalendar.
+func calendar(notes []tessernote.Note) []byte {
+	var b bytes.Buffer
+	icalLine(&b, "BEGIN:VCALENDAR")
+	icalLine(&b, "VERSION:2.0")
+	icalLine(&b, "PRODID:-//Tessernote//Tessernote//EN")
+	icalLine(&b, "CALSCALE:GREGORIAN")
+	icalLine(&b, "X-WR-CALNAME:Tessernote")
+	for _, note := range notes {
+		tags := convert.TagNames(note.Body)
+		dates := noteDates(tags)
+		events := dates
+		if hasTag(tags, todoTag) {
+			icalLine(&b, "BEGIN:VTODO")
+			icalNote(&b, note, note.ID+uidDomain, tags)
+			if len(dates) > 0 {
+				icalLine(&b, "DUE;VALUE=DATE:"+dates[0].Date.Format(icalDate))
+				events = dates[1:]
+			}
+			if hasTag(tags, doneTags...) {
+				icalLine(&b, "STATUS:COMPLETED")
+				icalLine(&b, "COMPLETED:"+note.LastModified.UTC().Format(icalTime))
+			} else {
+				icalLine(&b, "STATUS:NEEDS-ACTION")
+			}
+			icalLine(&b, "END:VTODO")
+		}
+		for _, date := range events {
+			icalLine(&b, "BEGIN:VEVENT")
+			icalNote(&b, note, note.ID+"/"+date.Key+uidDomain, tags)
+			icalLine(&b, "DTSTART;VALUE=DATE:"+date.Date.Format(icalDate))
+			icalLine(&b, "DTEND;VALUE=DATE:"+date.Date.AddDate(0, 0, 1).Format(icalDate))
+			icalLine(&b, "END:VEVENT")
+		}
+	}
+	icalLine(&b, "END:VCALENDAR")
+	return b.Bytes()
+}
+
+// icalNote writes the properties events and todos share.
+func icalNote(b *bytes.Buffer, note tessernote.Note, uid string, tags []string) {
+	icalLine(b, "UID:"+icalText(uid))
+	icalLine(b, "DTSTAMP:"+note.LastModified.UTC().Format(icalTime))
+	icalLine(b, "CREATED:"+note.Created.UTC().Format(icalTime))
+	icalLine(b, "LAST-MODIFIED:"+note.LastModified.UTC().Format(icalTime))
+	icalLine(b, "SUMMARY:"+icalText(noteTitle(note)))
+	icalLine(b, "DESCRIPTION:"+icalText(note.Body))
+	if len(tags) > 0 {
+		categories := make([]string, len(tags))
+		for i, tag := range tags {
+			categories[i] = icalText(tag)
+		}
+		icalLine(b, "CATEGORIES:"+strings.Join(categories, ","))
+	}
+}
+
+// noteDates returns the dated hashtags in tags, one per key.
+func noteDates(tags []string) []noteDate {
+	var dates []noteDate
+	seen := make(map[string]bool)
+	for _, tag := range tags {
+		key, value := hashtag.SplitValue(tag)
+		date, err := time.Parse(tessernote.DateFormat, value)
+		key = hashtag.Fold(key)
+		if err != nil || seen[key] {
+			continue
+		}
+		seen[key] = true
+		dates = append(dates, noteDate{key, date})
+	}
+	return dates
+}
+
+// hasTag returns true if tags has one of names, ignoring case.
+func hasTag(tags []string, names ...string) bool {
+	for _, tag := range tags {
+		for _, name := range names {
+			if hashtag.Fold(tag) == name {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// icalText escapes a text value.
+func icalText(s string) string {
+	s = strings.Replace(s, "\r\n", "\n", -1)
+	return strings.NewReplacer(`\`, `\\`, ";", `\;`, ",", `\,`, "\n", `\n`).Replace(s)
+}
+
+// icalLine writes a content line, folded after 75 bytes without splitting UTF-8 characters.
+func icalLine(b *bytes.Buffer, line string) {
+	limit := icalLineLimit
+	for len(line) > limit {
+		i := limit
+		for i > 0 && !utf8.RuneStart(line[i]) {
+			i--
+		}
+		b.WriteString(line[:i] + "\r\n ")
+		line = line[i:]
+		limit = icalLineLimit - 1 // continuation lines start with a space
+	}
+	b.WriteString(line + "\r\n")
+}
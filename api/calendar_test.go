@@ -0,0 +1,143 @@
+/*
+This file is part of Tessernote.
+
+Tessernote is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+Tessernote is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with Tessernote.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package api
+
+import (
+	"bytes"
+	"github.com/oschmid/tessernote"
+	"strings"
+	"testing"
+	"time"
+	"unicode/utf8"
+)
+
+// icalComponents returns the properties of the events and todos in a calendar, unfolding its lines.
+func icalComponents(t *testing.T, cal []byte) []map[string]string {
+	text := string(cal)
+	if !strings.HasSuffix(text, "\r\n") {
+		t.Fatalf("calendar doesn't end with CRLF: %q", text)
+	}
+	var components []map[string]string
+	var current map[string]string
+	for _, line := range strings.Split(strings.Replace(strings.TrimSuffix(text, "\r\n"), "\r\n ", "", -1), "\r\n") {
+		switch {
+		case line == "BEGIN:VEVENT" || line == "BEGIN:VTODO":
+			current = map[string]string{"": line[len("BEGIN:"):]}
+		case line == "END:VEVENT" || line == "END:VTODO":
+			components = append(components, current)
+			current = nil
+		case current != nil:
+			i := strings.Index(line, ":")
+			if i < 0 {
+				t.Fatalf("invalid content line %q", line)
+			}
+			current[line[:i]] = line[i+1:]
+		}
+	}
+	return components
+}
+
+func TestIcalLine(t *testing.T) {
+	for _, test := range []struct {
+		name string
+		line string
+		want []string // physical lines
+	}{
+		{"short", "SUMMARY:hi", []string{"SUMMARY:hi"}},
+		{"limit", strings.Repeat("a", 75), []string{strings.Repeat("a", 75)}},
+		{"one over", strings.Repeat("a", 76), []string{strings.Repeat("a", 75), " a"}},
+		{"continuations", strings.Repeat("a", 75+74+1), []string{strings.Repeat("a", 75), " " + strings.Repeat("a", 74), " a"}},
+		{"rune at limit", strings.Repeat("a", 74) + "é", []string{strings.Repeat("a", 74), " é"}},
+		{"runes", strings.Repeat("日", 30), []string{strings.Repeat("日", 25), " " + strings.Repeat("日", 5)}},
+	} {
+		var b bytes.Buffer
+		icalLine(&b, test.line)
+		got := strings.Split(strings.TrimSuffix(b.String(), "\r\n"), "\r\n")
+		if strings.Join(got, "|") != strings.Join(test.want, "|") {
+			t.Errorf("%s: icalLine(%q) = %q, want %q", test.name, test.line, got, test.want)
+		}
+		for _, line := range got {
+			if len(line) > icalLineLimit || !utf8.ValidString(line) {
+				t.Errorf("%s: invalid physical line %q", test.name, line)
+			}
+		}
+		if unfolded := strings.Replace(strings.TrimSuffix(b.String(), "\r\n"), "\r\n ", "", -1); unfolded != test.line {
+			t.Errorf("%s: unfolded %q, want %q", test.name, unfolded, test.line)
+		}
+	}
+}
+
+func TestCalendarUIDs(t *testing.T) {
+	created := time.Date(2013, 5, 1, 12, 0, 0, 0, time.UTC)
+	note := tessernote.Note{ID: "abc", Body: "trip #start:2013-05-01 #end:2013-05-03 #End:2013-05-04", Created: created, LastModified: created}
+	edited := note
+	edited.Body = "Trip to the coast #start:2013-05-02 #end:2013-05-03"
+	edited.LastModified = created.Add(time.Hour)
+	todo := tessernote.Note{ID: "def", Body: "call #todo #due:2013-05-01", Created: created, LastModified: created}
+	datedTodo := todo
+	datedTodo.Body = "call #todo #due:2013-05-01 #meeting:2013-05-03 #Due:2013-05-02"
+
+	for _, test := range []struct {
+		name  string
+		notes []tessernote.Note
+		want  []string
+	}{
+		{"event per date key", []tessernote.Note{note}, []string{"abc/start" + uidDomain, "abc/end" + uidDomain}},
+		{"edited", []tessernote.Note{edited}, []string{"abc/start" + uidDomain, "abc/end" + uidDomain}},
+		{"todo", []tessernote.Note{todo}, []string{"def" + uidDomain}},
+		{"todo with other dates", []tessernote.Note{datedTodo}, []string{"def" + uidDomain, "def/meeting" + uidDomain}},
+		{"undated", []tessernote.Note{{ID: "ghi", Body: "no dates #go"}}, nil},
+	} {
+		var got []string
+		for _, component := range icalComponents(t, calendar(test.notes)) {
+			got = append(got, component["UID"])
+		}
+		if strings.Join(got, " ") != strings.Join(test.want, " ") {
+			t.Errorf("%s: UIDs %q, want %q", test.name, got, test.want)
+		}
+	}
+}
+
+func TestCalendarTodoStatus(t *testing.T) {
+	modified := time.Date(2013, 5, 2, 8, 30, 0, 0, time.UTC)
+	for _, test := range []struct {
+		body      string
+		status    string
+		completed string
+		due       string
+	}{
+		{"call #todo", "NEEDS-ACTION", "", ""},
+		{"call #todo #due:2013-05-01", "NEEDS-ACTION", "", "20130501"},
+		{"call #todo #done", "COMPLETED", "20130502T083000Z", ""},
+		{"call #TODO #Done", "COMPLETED", "20130502T083000Z", ""},
+		{"call #todo #status:done #due:2013-05-01", "COMPLETED", "20130502T083000Z", "20130501"},
+		{"call #todo #status:open", "NEEDS-ACTION", "", ""},
+	} {
+		note := tessernote.Note{ID: "abc", Body: test.body, Created: modified, LastModified: modified}
+		components := icalComponents(t, calendar([]tessernote.Note{note}))
+		if len(components) != 1 || components[0][""] != "VTODO" {
+			t.Errorf("%q: components %v, want one todo", test.body, components)
+			continue
+		}
+		todo := components[0]
+		if todo["STATUS"] != test.status || todo["COMPLETED"] != test.completed || todo["DUE;VALUE=DATE"] != test.due {
+			t.Errorf("%q: STATUS %q COMPLETED %q DUE %q, want %q %q %q", test.body, todo["STATUS"], todo["COMPLETED"],
+				todo["DUE;VALUE=DATE"], test.status, test.completed, test.due)
+		}
+	}
+}
@@ -19,7 +19,6 @@ package api
 
 import (
 	"appengine"
-	"appengine/user"
 	"bytes"
 	"encoding/json"
 	"github.com/oschmid/tessernote"
@@ -29,28 +28,44 @@ import (
 )
 
 const (
-	NotesURL = "/notes/"
+	NotesURL          = "/notes/"
+	SuggestedTagsPath = "/suggested-tags"
+	SimilarPath       = "/similar"
 )
 
 var (
-	base64Char   = "[0-9a-zA-Z-_]"
-	validDataURL = regexp.MustCompile("^" + NotesURL + base64Char + "*$")
+	base64Char            = "[0-9a-zA-Z-_]"
+	validDataURL          = regexp.MustCompile("^" + NotesURL + base64Char + "*$")
+	validSuggestedTagsURL = regexp.MustCompile("^" + NotesURL + base64Char + "+" + SuggestedTagsPath + "$")
+	validSimilarURL       = regexp.MustCompile("^" + NotesURL + base64Char + "+" + SimilarPath + "$")
 )
 
 // serveData handles requests to Tessernote's RESTful data API
 func serveData(w http.ResponseWriter, r *http.Request) {
-	c := appengine.NewContext(r)
-	u := user.Current(c)
-	if u == nil {
-		http.Error(w, "", http.StatusUnauthorized)
-		return
-	}
-	notebook, err := tessernote.CurrentNotebook(c)
-	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+	routeData(w, r, appengine.NewContext(r))
+}
+
+// routeData authorizes a data API request and passes it to its handler.
+func routeData(w http.ResponseWriter, r *http.Request, c appengine.Context) {
+	notebook := authorizedNotebook(w, r, c, tessernote.APIToken)
+	if notebook == nil {
 		return
 	}
-	if r.URL.Path == NotesURL {
+	if validSuggestedTagsURL.MatchString(r.URL.Path) {
+		switch r.Method {
+		case "GET":
+			GetSuggestedTags(w, r, c, notebook)
+		default:
+			http.NotFound(w, r)
+		}
+	} else if validSimilarURL.MatchString(r.URL.Path) {
+		switch r.Method {
+		case "GET":
+			GetSimilarNotes(w, r, c, notebook)
+		default:
+			http.NotFound(w, r)
+		}
+	} else if r.URL.Path == NotesURL {
 		switch r.Method {
 		case "GET":
 			GetAllNotes(w, c, notebook)
@@ -136,7 +151,8 @@ func readNotes(w http.ResponseWriter, r *http.Request) (notes []tessernote.Note,
 }
 
 // CreateNote creates a new Note in the authorized User's Notebook. It takes as input a JSON formatted Note 
-// and writes the new Note (with its automatically assigned unique ID) in JSON format to w.
+// and writes the new Note (with its automatically assigned unique ID) in JSON format to w. The IDs of Notes
+// that the new Note likely duplicates are listed in its Duplicates.
 func CreateNote(w http.ResponseWriter, r *http.Request, c appengine.Context, notebook *tessernote.Notebook) {
 	note, err := readNote(w, r)
 	if err != nil {
@@ -147,6 +163,13 @@ func CreateNote(w http.ResponseWriter, r *http.Request, c appengine.Context, not
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
+	duplicates, err := notebook.Duplicates(note, c)
+	if err != nil {
+		c.Errorf("finding duplicates of note (%s): %s", note.ID, err) // note was still created
+	}
+	for _, duplicate := range duplicates {
+		note.Duplicates = append(note.Duplicates, duplicate.ID)
+	}
 	reply, err := json.Marshal(note)
 	if err != nil {
 		c.Errorf("marshaling note (%#v): %s", note, err)
@@ -205,7 +228,7 @@ func GetNote(w http.ResponseWriter, r *http.Request, c appengine.Context, notebo
 	id := r.URL.Path[len(NotesURL):]
 	note, err := notebook.Note(id, c)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
+		noteError(w, err)
 		return
 	}
 	reply, err := json.Marshal(note)
@@ -261,3 +284,57 @@ func DeleteNote(w http.ResponseWriter, r *http.Request, c appengine.Context, not
 	}
 	w.Write(reply)
 }
+
+// GetSuggestedTags writes a JSON formatted list of Tags that are likely to fit the Note with the ID in the URL to w.
+func GetSuggestedTags(w http.ResponseWriter, r *http.Request, c appengine.Context, notebook *tessernote.Notebook) {
+	id := r.URL.Path[len(NotesURL) : len(r.URL.Path)-len(SuggestedTagsPath)]
+	note, err := notebook.Note(id, c)
+	if err != nil {
+		noteError(w, err)
+		return
+	}
+	suggestions, err := notebook.SuggestTags(note, c)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	reply, err := json.Marshal(suggestions)
+	if err != nil {
+		c.Errorf("marshaling suggested tags (%d): %s", len(suggestions), err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Write(reply)
+}
+
+// GetSimilarNotes writes a JSON formatted list of the Notes most similar to the Note with the ID in the URL to w.
+func GetSimilarNotes(w http.ResponseWriter, r *http.Request, c appengine.Context, notebook *tessernote.Notebook) {
+	id := r.URL.Path[len(NotesURL) : len(r.URL.Path)-len(SimilarPath)]
+	note, err := notebook.Note(id, c)
+	if err != nil {
+		noteError(w, err)
+		return
+	}
+	notes, err := notebook.SimilarNotes(note, c)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	reply, err := json.Marshal(notes)
+	if err != nil {
+		c.Errorf("marshaling similar notes (%d): %s", len(notes), err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Write(reply)
+}
+
+// noteError writes the error of reading the Note with the ID in the URL to w, 404 Not Found if it isn't in the
+// Notebook.
+func noteError(w http.ResponseWriter, err error) {
+	if err == tessernote.ErrMissingNote {
+		http.Error(w, err.Error(), http.StatusNotFound)
+	} else {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
@@ -0,0 +1,666 @@
+/*
+This file is part of Tessernote.
+
+Tessernote is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+Tessernote is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with Tessernote.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package api
+
+import (
+	"appengine"
+	"appengine/datastore"
+	"appengine/memcache"
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"github.com/oschmid/tessernote"
+	"github.com/oschmid/tessernote/convert"
+	"github.com/oschmid/tessernote/hashtag"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// The WebDAV tree has a directory for every Tag and one for untagged Notes, named "(untagged)" so it can't be
+// mistaken for a Tag. Every Note is a Markdown file in the
+// directory of each of its Tags, named after its first line and its ID, e.g. /dav/work/meeting-notes.<ID>.md.
+// Writing a file puts its Note, so hashtags are parsed again and the Note may move to other directories. New
+// files get the hashtag of the directory they're created in. Clients keep using the names they create or move
+// files to, so names without an ID are remembered for their Note in that directory (see davName) and listed
+// instead of the Note's own name.
+//
+// Locks don't keep other clients out. A lock remembers the version (LastModified time) of its Note, and writes
+// with a lock token fail with 412 Precondition Failed if the Note changed since, the same as writes with an
+// outdated If-Match ETag. The version is checked in the transaction of the write, see davPreconditions.
+const (
+	DAVURL         = "/dav/"
+	untaggedDir    = "(untagged)" // not a valid hashtag name
+	davLockTimeout = time.Hour
+	davContentType = "text/markdown; charset=utf-8"
+)
+
+var (
+	davLockToken = regexp.MustCompile(`<(opaquelocktoken:tessernote-[0-9a-f]+)>`)
+	nonSlugChars = regexp.MustCompile(`[^\pL\pN]+`)
+)
+
+// davLock is the memcache value of a lock token.
+type davLock struct {
+	ID      string // of the locked Note, "" if it didn't exist yet
+	Version int64  // LastModified of the Note when it was locked or last written with the lock
+}
+
+// davName is a file name a client chose for a Note, stored as a child of the Notebook with the directory and name
+// as key (see davNameKey).
+type davName struct {
+	NoteID string
+}
+
+// davResource is a directory or file in the WebDAV tree.
+type davResource struct {
+	Href string
+	Name string
+	Note *tessernote.Note // nil for directories
+}
+
+// serveDAV handles WebDAV requests. WebDAV clients can't log in, they use the User's DAV token (see /tokens/dav)
+// as password.
+func serveDAV(w http.ResponseWriter, r *http.Request) {
+	routeDAV(w, r, appengine.NewContext(r))
+}
+
+// routeDAV authorizes a WebDAV request and passes it to the handler of its method.
+func routeDAV(w http.ResponseWriter, r *http.Request, c appengine.Context) {
+	notebook := authorizedNotebook(w, r, c, tessernote.DAVToken)
+	if notebook == nil {
+		return
+	}
+	path := strings.Trim(r.URL.Path[len(DAVURL):], "/")
+	parts := strings.SplitN(path, "/", 2)
+	dir, file := parts[0], ""
+	if len(parts) == 2 {
+		file = parts[1]
+	}
+	if strings.Contains(file, "/") {
+		http.NotFound(w, r)
+		return
+	}
+	switch r.Method {
+	case "OPTIONS":
+		w.Header().Set("DAV", "1, 2")
+		w.Header().Set("MS-Author-Via", "DAV")
+		w.Header().Set("Allow", "OPTIONS, PROPFIND, PROPPATCH, GET, HEAD, PUT, DELETE, MOVE, LOCK, UNLOCK")
+	case "PROPFIND":
+		davPropfind(w, r, c, notebook, dir, file)
+	case "PROPPATCH":
+		davProppatch(w, r)
+	case "GET", "HEAD":
+		davGet(w, r, c, notebook, dir, file)
+	case "PUT":
+		davPut(w, r, c, notebook, dir, file)
+	case "DELETE":
+		davDelete(w, r, c, notebook, dir, file)
+	case "MOVE":
+		davMove(w, r, c, notebook, dir, file)
+	case "LOCK":
+		davLockResource(w, r, c, notebook, dir, file)
+	case "UNLOCK":
+		if token := davLockToken.FindStringSubmatch(r.Header.Get("Lock-Token")); token != nil {
+			memcache.Delete(c, davLockKey(token[1]))
+		}
+		w.WriteHeader(http.StatusNoContent)
+	case "MKCOL", "COPY":
+		http.Error(w, "tags are created by writing hashtags", http.StatusForbidden)
+	default:
+		http.Error(w, "", http.StatusMethodNotAllowed)
+	}
+}
+
+// davTag returns the Tag of a directory, nil for the untagged directory. ok is false if there's no such
+// directory.
+func davTag(notebook *tessernote.Notebook, dir string, c appengine.Context) (tag *tessernote.Tag, ok bool) {
+	if dir == untaggedDir {
+		return nil, true
+	}
+	tags, err := notebook.TagsFrom([]string{dir}, c)
+	if err != nil || len(tags) == 0 {
+		return nil, false
+	}
+	return &tags[0], true
+}
+
+// davNotes returns the Notes in a directory.
+func davNotes(notebook *tessernote.Notebook, tag *tessernote.Tag, c appengine.Context) ([]tessernote.Note, error) {
+	if tag == nil {
+		return notebook.UntaggedNotes(c)
+	}
+	return tag.Notes(c)
+}
+
+// davNote returns the Note of a file in a directory, or false if the file doesn't refer to one.
+func davNote(notebook *tessernote.Notebook, dir, file string, c appengine.Context) (tessernote.Note, bool) {
+	id, ok := davNoteID(file)
+	if ok {
+		note, err := notebook.Note(id, c)
+		if err == nil {
+			note.ID = id
+			return note, true
+		}
+	}
+	var name davName
+	err := datastore.Get(c, davNameKey(notebook, dir, file, c), &name)
+	if err != nil {
+		return tessernote.Note{}, false
+	}
+	note, err := notebook.Note(name.NoteID, c)
+	if err != nil {
+		return note, false // deleted since
+	}
+	note.ID = name.NoteID
+	return note, true
+}
+
+// davNoteID returns the ID in a file name made by davFileName, or false if it doesn't look like one.
+func davNoteID(file string) (string, bool) {
+	name := strings.TrimSuffix(file, ".md")
+	i := strings.LastIndex(name, ".")
+	if name == file || i < 0 || i == len(name)-1 {
+		return "", false
+	}
+	return name[i+1:], true
+}
+
+// davNameKey returns the datastore.Key of the davName of a file in a directory.
+func davNameKey(notebook *tessernote.Notebook, dir, file string, c appengine.Context) *datastore.Key {
+	return datastore.NewKey(c, "DAVName", hashtag.Fold(dir)+"/"+file, 0, notebook.Key(c))
+}
+
+// davRemember remembers the name of a file a client wrote note to, unless it's the name davFileName gives it.
+func davRemember(notebook *tessernote.Notebook, dir, file string, note tessernote.Note, c appengine.Context) {
+	if id, ok := davNoteID(file); ok && id == note.ID {
+		return
+	}
+	_, err := datastore.Put(c, davNameKey(notebook, dir, file, c), &davName{note.ID})
+	if err != nil {
+		c.Errorf("remembering %s/%s: %s", dir, file, err)
+	}
+}
+
+// davForget forgets the name of a file, see davRemember.
+func davForget(notebook *tessernote.Notebook, dir, file string, c appengine.Context) {
+	err := datastore.Delete(c, davNameKey(notebook, dir, file, c))
+	if err != nil && err != datastore.ErrNoSuchEntity {
+		c.Warningf("forgetting %s/%s: %s", dir, file, err)
+	}
+}
+
+// davFileNames returns the names of notes in a directory by ID, the ones clients chose or else davFileName.
+func davFileNames(notebook *tessernote.Notebook, dir string, notes []tessernote.Note, c appengine.Context) (map[string]string, error) {
+	names := make(map[string]string, len(notes))
+	for _, note := range notes {
+		names[note.ID] = davFileName(note)
+	}
+	var chosen []davName
+	keys, err := datastore.NewQuery("DAVName").Ancestor(notebook.Key(c)).GetAll(c, &chosen)
+	if err != nil {
+		c.Errorf("getting file names: %s", err)
+		return nil, err
+	}
+	prefix := hashtag.Fold(dir) + "/"
+	for i, key := range keys {
+		_, ok := names[chosen[i].NoteID]
+		if ok && strings.HasPrefix(key.StringID(), prefix) {
+			names[chosen[i].NoteID] = key.StringID()[len(prefix):]
+		}
+	}
+	return names, nil
+}
+
+// davFileName returns the name of a Note's file: a slug of its first line and its ID.
+func davFileName(note tessernote.Note) string {
+	line := strings.TrimSpace(note.Body)
+	if i := strings.Index(line, "\n"); i >= 0 {
+		line = line[:i]
+	}
+	slug := strings.Trim(nonSlugChars.ReplaceAllString(strings.ToLower(line), "-"), "-")
+	if len([]rune(slug)) > 40 {
+		slug = strings.TrimRight(string([]rune(slug)[:40]), "-")
+	}
+	if slug == "" {
+		slug = "note"
+	}
+	return slug + "." + note.ID + ".md"
+}
+
+// davHref returns the URL path of a directory or file.
+func davHref(dir, file string) string {
+	if dir == "" {
+		return DAVURL
+	}
+	if file == "" {
+		return DAVURL + url.PathEscape(dir) + "/"
+	}
+	return DAVURL + url.PathEscape(dir) + "/" + url.PathEscape(file)
+}
+
+// davETag returns the ETag of a Note's file, based on its version to the microsecond like the datastore stores it.
+func davETag(note tessernote.Note) string {
+	return `"` + strconv.FormatInt(note.LastModified.Truncate(time.Microsecond).UnixNano(), 10) + `"`
+}
+
+// davPropfind lists the properties of a directory or file, and of the contents of a directory unless the Depth
+// header is 0.
+func davPropfind(w http.ResponseWriter, r *http.Request, c appengine.Context, notebook *tessernote.Notebook, dir, file string) {
+	depth := r.Header.Get("Depth")
+	var resources []davResource
+	switch {
+	case dir == "":
+		resources = append(resources, davResource{Href: DAVURL, Name: "Tessernote"})
+		if depth == "0" {
+			break
+		}
+		tags, err := notebook.Tags(c)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		resources = append(resources, davResource{Href: davHref(untaggedDir, ""), Name: untaggedDir})
+		for _, tag := range tags {
+			resources = append(resources, davResource{Href: davHref(tag.Name, ""), Name: tag.Name})
+		}
+	case file == "":
+		tag, ok := davTag(notebook, dir, c)
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		resources = append(resources, davResource{Href: davHref(dir, ""), Name: dir})
+		if depth == "0" {
+			break
+		}
+		notes, err := davNotes(notebook, tag, c)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		names, err := davFileNames(notebook, dir, notes, c)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		for i := range notes {
+			name := names[notes[i].ID]
+			resources = append(resources, davResource{Href: davHref(dir, name), Name: name, Note: &notes[i]})
+		}
+	default:
+		note, ok := davNote(notebook, dir, file, c)
+		if _, dirOK := davTag(notebook, dir, c); !ok || !dirOK {
+			http.NotFound(w, r)
+			return
+		}
+		resources = append(resources, davResource{Href: davHref(dir, file), Name: file, Note: &note})
+	}
+	var b bytes.Buffer
+	b.WriteString(`<?xml version="1.0" encoding="utf-8"?>` + "\n" + `<D:multistatus xmlns:D="DAV:">`)
+	for _, resource := range resources {
+		b.WriteString("<D:response><D:href>" + davEscape(resource.Href) + "</D:href><D:propstat><D:prop>")
+		b.WriteString("<D:displayname>" + davEscape(resource.Name) + "</D:displayname>")
+		if resource.Note == nil {
+			b.WriteString("<D:resourcetype><D:collection/></D:resourcetype>")
+		} else {
+			note := resource.Note
+			b.WriteString("<D:resourcetype/>")
+			fmt.Fprintf(&b, "<D:getcontentlength>%d</D:getcontentlength>", len(note.Body))
+			b.WriteString("<D:getcontenttype>" + davContentType + "</D:getcontenttype>")
+			b.WriteString("<D:getetag>" + davEscape(davETag(*note)) + "</D:getetag>")
+			b.WriteString("<D:getlastmodified>" + note.LastModified.UTC().Format(http.TimeFormat) + "</D:getlastmodified>")
+			b.WriteString("<D:creationdate>" + note.Created.UTC().Format(time.RFC3339) + "</D:creationdate>")
+		}
+		b.WriteString("<D:supportedlock><D:lockentry><D:lockscope><D:exclusive/></D:lockscope>" +
+			"<D:locktype><D:write/></D:locktype></D:lockentry></D:supportedlock>")
+		b.WriteString("</D:prop><D:status>HTTP/1.1 200 OK</D:status></D:propstat></D:response>")
+	}
+	b.WriteString("</D:multistatus>\n")
+	w.Header().Set("Content-Type", "application/xml; charset=utf-8")
+	w.WriteHeader(207) // Multi-Status
+	w.Write(b.Bytes())
+}
+
+// davProppatch pretends to set properties. Some clients set e.g. modification times after writing a file and
+// fail if they can't, but Notes only have the properties derived from them.
+func davProppatch(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/xml; charset=utf-8")
+	w.WriteHeader(207) // Multi-Status
+	fmt.Fprintf(w, `<?xml version="1.0" encoding="utf-8"?>`+"\n"+`<D:multistatus xmlns:D="DAV:"><D:response>`+
+		`<D:href>%s</D:href><D:propstat><D:prop/><D:status>HTTP/1.1 200 OK</D:status></D:propstat></D:response>`+
+		`</D:multistatus>`+"\n", davEscape(r.URL.Path))
+}
+
+// davGet writes a Note's body, or the names of the files in a directory.
+func davGet(w http.ResponseWriter, r *http.Request, c appengine.Context, notebook *tessernote.Notebook, dir, file string) {
+	if file == "" {
+		var names []string
+		if dir == "" {
+			tags, err := notebook.Tags(c)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+			names = append(names, untaggedDir+"/")
+			for _, tag := range tags {
+				names = append(names, tag.Name+"/")
+			}
+		} else {
+			tag, ok := davTag(notebook, dir, c)
+			if !ok {
+				http.NotFound(w, r)
+				return
+			}
+			notes, err := davNotes(notebook, tag, c)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+			fileNames, err := davFileNames(notebook, dir, notes, c)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+			for _, note := range notes {
+				names = append(names, fileNames[note.ID])
+			}
+		}
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		w.Write([]byte(strings.Join(names, "\n") + "\n"))
+		return
+	}
+	note, ok := davNote(notebook, dir, file, c)
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+	w.Header().Set("Content-Type", davContentType)
+	w.Header().Set("Content-Length", strconv.Itoa(len(note.Body)))
+	w.Header().Set("ETag", davETag(note))
+	w.Header().Set("Last-Modified", note.LastModified.UTC().Format(http.TimeFormat))
+	if r.Method == "GET" {
+		w.Write([]byte(note.Body))
+	}
+}
+
+// davPut writes a file. Existing Notes are replaced, new Notes get the hashtag of the directory unless they
+// already have it and keep the file's name. Empty new files aren't created, clients often write those before the
+// actual content.
+func davPut(w http.ResponseWriter, r *http.Request, c appengine.Context, notebook *tessernote.Notebook, dir, file string) {
+	tag, ok := davTag(notebook, dir, c)
+	if !ok || file == "" {
+		http.Error(w, "", http.StatusConflict)
+		return
+	}
+	body, err := readRequestBody(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	note, exists := davNote(notebook, dir, file, c)
+	locks, version, ok := davPreconditions(r, c, note, exists)
+	if !ok {
+		http.Error(w, "", http.StatusPreconditionFailed)
+		return
+	}
+	if exists {
+		note.Body = string(body)
+		note, err = notebook.PutIfUnmodified(note, version, c)
+	} else {
+		if !strings.HasSuffix(file, ".md") {
+			http.Error(w, "only .md files can be created", http.StatusForbidden)
+			return
+		}
+		if len(bytes.TrimSpace(body)) == 0 {
+			w.WriteHeader(http.StatusCreated)
+			return
+		}
+		note = tessernote.Note{Body: string(body)}
+		if tag != nil {
+			note.Body = convert.AppendTags(note.Body, []string{tag.Name})
+		}
+		note, err = notebook.Put(note, c)
+	}
+	if err == tessernote.ErrNoteChanged {
+		http.Error(w, "", http.StatusPreconditionFailed)
+		return
+	} else if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	davUpdateLocks(c, locks, note)
+	w.Header().Set("ETag", davETag(note))
+	if exists {
+		w.WriteHeader(http.StatusNoContent)
+	} else {
+		davRemember(notebook, dir, file, note, c)
+		w.WriteHeader(http.StatusCreated)
+	}
+}
+
+// davDelete deletes a Note. Directories can't be deleted, delete or rename their Tag instead.
+func davDelete(w http.ResponseWriter, r *http.Request, c appengine.Context, notebook *tessernote.Notebook, dir, file string) {
+	if file == "" {
+		http.Error(w, "delete tags with the tag API", http.StatusForbidden)
+		return
+	}
+	note, ok := davNote(notebook, dir, file, c)
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+	_, version, ok := davPreconditions(r, c, note, true)
+	if !ok {
+		http.Error(w, "", http.StatusPreconditionFailed)
+		return
+	}
+	_, err := notebook.DeleteIfUnmodified(note.ID, version, c)
+	if err == tessernote.ErrNoteChanged {
+		http.Error(w, "", http.StatusPreconditionFailed)
+		return
+	} else if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	davForget(notebook, dir, file, c)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// davMove moves a file to another directory by replacing the hashtag of its directory with the hashtag of the
+// destination. Moving a file into the untagged directory removes the hash mark of its directory's hashtag, moving
+// a file out of it adds a hashtag. The destination's name is remembered like the names of new files.
+func davMove(w http.ResponseWriter, r *http.Request, c appengine.Context, notebook *tessernote.Notebook, dir, file string) {
+	note, ok := davNote(notebook, dir, file, c)
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+	destination, err := url.Parse(r.Header.Get("Destination"))
+	if err != nil || !strings.HasPrefix(destination.Path, DAVURL) {
+		http.Error(w, "", http.StatusBadGateway)
+		return
+	}
+	parts := strings.SplitN(strings.Trim(destination.Path[len(DAVURL):], "/"), "/", 2)
+	if len(parts) != 2 || strings.Contains(parts[1], "/") {
+		http.Error(w, "", http.StatusConflict)
+		return
+	}
+	from, fromOK := davTag(notebook, dir, c)
+	to, toOK := davTag(notebook, parts[0], c)
+	if !fromOK || !toOK {
+		http.Error(w, "", http.StatusConflict)
+		return
+	}
+	locks, version, ok := davPreconditions(r, c, note, true)
+	if !ok {
+		http.Error(w, "", http.StatusPreconditionFailed)
+		return
+	}
+	switch {
+	case from == nil && to != nil:
+		note.Body = convert.AppendTags(note.Body, []string{to.Name})
+	case from != nil && to == nil:
+		note.Body = hashtag.Strip(note.Body, from.Name)
+	case from != nil && hashtag.Fold(from.Name) != hashtag.Fold(to.Name):
+		note.Body = hashtag.Rename(note.Body, from.Name, to.Name)
+	default:
+		davForget(notebook, dir, file, c) // renamed in the same directory
+		davRemember(notebook, parts[0], parts[1], note, c)
+		w.WriteHeader(http.StatusCreated)
+		return
+	}
+	note, err = notebook.PutIfUnmodified(note, version, c)
+	if err == tessernote.ErrNoteChanged {
+		http.Error(w, "", http.StatusPreconditionFailed)
+		return
+	} else if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	davUpdateLocks(c, locks, note)
+	davForget(notebook, dir, file, c)
+	davRemember(notebook, parts[0], parts[1], note, c)
+	w.WriteHeader(http.StatusCreated)
+}
+
+// davLockResource locks a file (or refreshes a lock) by remembering the version of its Note. Refreshing a lock
+// that expired or belongs to another file fails with 412 Precondition Failed.
+func davLockResource(w http.ResponseWriter, r *http.Request, c appengine.Context, notebook *tessernote.Notebook, dir, file string) {
+	note, exists := davNote(notebook, dir, file, c)
+	token := ""
+	lock := davLock{}
+	if exists {
+		lock = davLock{note.ID, note.LastModified.UnixNano()}
+	}
+	if match := davLockToken.FindStringSubmatch(r.Header.Get("If")); match != nil {
+		// refresh, keeping the version the Note was locked at
+		token = match[1]
+		item, err := memcache.Get(c, davLockKey(token))
+		if err != nil || json.Unmarshal(item.Value, &lock) != nil || (lock.ID != "" && (!exists || lock.ID != note.ID)) {
+			http.Error(w, "unknown lock token", http.StatusPreconditionFailed)
+			return
+		}
+	} else {
+		b := make([]byte, 16)
+		_, err := rand.Read(b)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		token = "opaquelocktoken:tessernote-" + hex.EncodeToString(b)
+	}
+	value, err := json.Marshal(lock)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	err = memcache.Set(c, &memcache.Item{Key: davLockKey(token), Value: value, Expiration: davLockTimeout})
+	if err != nil {
+		c.Errorf("locking %s: %s", r.URL.Path, err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Lock-Token", "<"+token+">")
+	w.Header().Set("Content-Type", "application/xml; charset=utf-8")
+	fmt.Fprintf(w, `<?xml version="1.0" encoding="utf-8"?>`+"\n"+`<D:prop xmlns:D="DAV:"><D:lockdiscovery><D:activelock>`+
+		`<D:locktype><D:write/></D:locktype><D:lockscope><D:exclusive/></D:lockscope><D:depth>0</D:depth>`+
+		`<D:timeout>Second-%d</D:timeout><D:locktoken><D:href>%s</D:href></D:locktoken>`+
+		`<D:lockroot><D:href>%s</D:href></D:lockroot></D:activelock></D:lockdiscovery></D:prop>`+"\n",
+		int(davLockTimeout.Seconds()), token, davEscape(r.URL.Path))
+}
+
+// davPreconditions returns the lock tokens of note in the If header of a write to it and the version the write
+// expects note to still have (see davExpectedVersion). The version is left for the transaction of the write to
+// check, so another write can't come in between. ok is false if the preconditions can't hold.
+func davPreconditions(r *http.Request, c appengine.Context, note tessernote.Note, exists bool) (tokens []string, version time.Time, ok bool) {
+	var locks []davLock
+	for _, match := range davLockToken.FindAllStringSubmatch(r.Header.Get("If"), -1) {
+		if !exists {
+			break
+		}
+		item, err := memcache.Get(c, davLockKey(match[1]))
+		if err != nil {
+			continue // expired
+		}
+		var lock davLock
+		if json.Unmarshal(item.Value, &lock) != nil || (lock.ID != "" && lock.ID != note.ID) {
+			continue
+		}
+		tokens = append(tokens, match[1])
+		locks = append(locks, lock)
+	}
+	version, ok = davExpectedVersion(r.Header.Get("If-Match"), locks, exists)
+	return tokens, version, ok
+}
+
+// davExpectedVersion returns the version (LastModified time) a write expects a Note to have, from its If-Match
+// ETag and the locks it holds. It's zero if the write doesn't depend on the version. ok is false if the ETag or
+// locks disagree, or the If-Match header expects a file that doesn't exist.
+func davExpectedVersion(ifMatch string, locks []davLock, exists bool) (version time.Time, ok bool) {
+	if ifMatch != "" && !exists {
+		return version, false
+	}
+	if ifMatch != "" && ifMatch != "*" {
+		nanos, err := strconv.ParseInt(strings.Trim(strings.TrimPrefix(ifMatch, "W/"), `"`), 10, 64)
+		if err != nil {
+			return version, false
+		}
+		version = time.Unix(0, nanos)
+	}
+	for _, lock := range locks {
+		if lock.ID == "" {
+			continue // locked before the Note was created
+		}
+		locked := time.Unix(0, lock.Version).Truncate(time.Microsecond)
+		if !version.IsZero() && !locked.Equal(version.Truncate(time.Microsecond)) {
+			return version, false
+		}
+		version = locked
+	}
+	return version, true
+}
+
+// davUpdateLocks updates lock tokens to the version of note written with them.
+func davUpdateLocks(c appengine.Context, tokens []string, note tessernote.Note) {
+	value, _ := json.Marshal(davLock{note.ID, note.LastModified.UnixNano()})
+	for _, token := range tokens {
+		err := memcache.Set(c, &memcache.Item{Key: davLockKey(token), Value: value, Expiration: davLockTimeout})
+		if err != nil {
+			c.Warningf("updating lock %s: %s", token, err)
+		}
+	}
+}
+
+// davLockKey returns the memcache key of a lock token.
+func davLockKey(token string) string {
+	return "davlock:" + token
+}
+
+// davEscape escapes s for XML.
+func davEscape(s string) string {
+	var b bytes.Buffer
+	xml.EscapeText(&b, []byte(s))
+	return b.String()
+}
@@ -0,0 +1,210 @@
+/*
+This file is part of Tessernote.
+
+Tessernote is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+Tessernote is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with Tessernote.  If not, see <http://www.gnu.org/licenses/>.
+*/
+package api
+
+import (
+	"appengine/datastore"
+	"github.com/oschmid/appenginetesting"
+	"github.com/oschmid/tessernote"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestDAVNoteID(t *testing.T) {
+	tests := []struct {
+		file, id string
+		ok       bool
+	}{
+		{"meeting-notes.abc123.md", "abc123", true},
+		{"note.abc123.md", "abc123", true},
+		{"a.b.c.md", "c", true}, // looked up, found by its name if there's no such Note
+		{"meeting.md", "", false},
+		{"meeting.abc123.txt", "", false},
+		{"meeting..md", "", false},
+		{"abc123", "", false},
+		{"", "", false},
+	}
+	for _, test := range tests {
+		id, ok := davNoteID(test.file)
+		if id != test.id || ok != test.ok {
+			t.Errorf("%q: expected=%q,%t actual=%q,%t", test.file, test.id, test.ok, id, ok)
+		}
+	}
+}
+
+func TestDAVFileName(t *testing.T) {
+	tests := []struct {
+		body, name string
+	}{
+		{"Meeting Notes\nsecond line #work", "meeting-notes.id.md"},
+		{"  #work: plans, ideas & more!  ", "work-plans-ideas-more.id.md"},
+		{"", "note.id.md"},
+		{"!!!", "note.id.md"},
+		{strings.Repeat("word ", 20), strings.TrimSuffix(strings.Repeat("word-", 8), "-") + ".id.md"},
+	}
+	for _, test := range tests {
+		name := davFileName(tessernote.Note{ID: "id", Body: test.body})
+		if name != test.name {
+			t.Errorf("%q: expected=%q actual=%q", test.body, test.name, name)
+		}
+		if id, ok := davNoteID(name); !ok || id != "id" {
+			t.Errorf("%q: expected to parse ID of %q, actual=%q,%t", test.body, name, id, ok)
+		}
+	}
+}
+
+func TestDAVExpectedVersion(t *testing.T) {
+	modified := time.Date(2024, 5, 1, 12, 0, 0, 123456789, time.UTC)
+	etag := davETag(tessernote.Note{LastModified: modified})
+	later := modified.Add(time.Second)
+	tests := []struct {
+		ifMatch string
+		locks   []davLock
+		exists  bool
+		version time.Time
+		ok      bool
+	}{
+		{"", nil, false, time.Time{}, true},
+		{"", nil, true, time.Time{}, true},
+		{"*", nil, true, time.Time{}, true},
+		{"*", nil, false, time.Time{}, false},
+		{etag, nil, false, time.Time{}, false},
+		{etag, nil, true, modified, true},
+		{"W/" + etag, nil, true, modified, true},
+		{`"not a version"`, nil, true, time.Time{}, false},
+		{"", []davLock{{"id", modified.UnixNano()}}, true, modified, true},
+		{"", []davLock{{"", 0}}, true, time.Time{}, true}, // locked before the note was created
+		{etag, []davLock{{"id", modified.UnixNano()}}, true, modified, true},
+		{etag, []davLock{{"id", later.UnixNano()}}, true, time.Time{}, false},
+		{"", []davLock{{"id", modified.UnixNano()}, {"id", later.UnixNano()}}, true, time.Time{}, false},
+	}
+	for _, test := range tests {
+		version, ok := davExpectedVersion(test.ifMatch, test.locks, test.exists)
+		if ok != test.ok || (ok && !version.Equal(test.version.Truncate(time.Microsecond))) {
+			t.Errorf("%q %v %t: expected=%s,%t actual=%s,%t", test.ifMatch, test.locks, test.exists, test.version,
+				test.ok, version, ok)
+		}
+	}
+}
+
+func TestDAVKeepsClientFileNames(t *testing.T) {
+	c, err := appenginetesting.NewContext(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	notebook := &tessernote.Notebook{ID: "dav"}
+	_, err = datastore.Put(c, notebook.Key(c), notebook)
+	if err != nil {
+		t.Fatal(err)
+	}
+	token, err := notebook.Token(tessernote.DAVToken, c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		routeDAV(w, r, c)
+	}))
+	defer server.Close()
+	request := func(method, path, body string, header ...string) (*http.Response, string) {
+		r, err := http.NewRequest(method, server.URL+path, strings.NewReader(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		r.SetBasicAuth("", token)
+		for i := 0; i+1 < len(header); i += 2 {
+			r.Header.Set(header[i], header[i+1])
+		}
+		response, err := http.DefaultClient.Do(r)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer response.Body.Close()
+		b, err := ioutil.ReadAll(response.Body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return response, string(b)
+	}
+
+	// a new untagged note, written again like editors save files
+	for i, body := range []string{"first draft", "second draft"} {
+		response, _ := request("PUT", DAVURL+untaggedDir+"/draft.md", body)
+		expected := []int{http.StatusCreated, http.StatusNoContent}[i]
+		if response.StatusCode != expected {
+			t.Fatalf("PUT %q: expected=%d actual=%d", body, expected, response.StatusCode)
+		}
+	}
+	notebook, err = tessernote.NotebookOf(notebook.ID, c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(notebook.NoteKeys) != 1 {
+		t.Fatalf("expected 1 note, actual=%d", len(notebook.NoteKeys))
+	}
+	_, body := request("GET", DAVURL+untaggedDir+"/draft.md", "")
+	if body != "second draft" {
+		t.Errorf("expected=%q actual=%q", "second draft", body)
+	}
+	_, body = request("PROPFIND", DAVURL+untaggedDir+"/", "", "Depth", "1")
+	if !strings.Contains(body, "<D:displayname>draft.md</D:displayname>") {
+		t.Errorf("expected draft.md to be listed, actual=%s", body)
+	}
+
+	// moved into a tag's directory under another name
+	response, _ := request("PUT", DAVURL+untaggedDir+"/plan.md", "plan #work")
+	if response.StatusCode != http.StatusCreated {
+		t.Fatalf("PUT: expected=%d actual=%d", http.StatusCreated, response.StatusCode)
+	}
+	response, _ = request("MOVE", DAVURL+untaggedDir+"/draft.md", "", "Destination", server.URL+DAVURL+"work/final.md")
+	if response.StatusCode != http.StatusCreated {
+		t.Fatalf("MOVE: expected=%d actual=%d", http.StatusCreated, response.StatusCode)
+	}
+	_, body = request("GET", DAVURL+"work/final.md", "")
+	if body != "second draft\n\n#work" {
+		t.Errorf("expected=%q actual=%q", "second draft\n\n#work", body)
+	}
+	response, _ = request("GET", DAVURL+untaggedDir+"/draft.md", "")
+	if response.StatusCode != http.StatusNotFound {
+		t.Errorf("expected the old name to be gone, actual=%d", response.StatusCode)
+	}
+
+	// writes with the ETag of an older version fail
+	response, _ = request("GET", DAVURL+"work/final.md", "")
+	etag := response.Header.Get("ETag")
+	response, _ = request("PUT", DAVURL+"work/final.md", "final #work", "If-Match", etag)
+	if response.StatusCode != http.StatusNoContent {
+		t.Fatalf("PUT with current ETag: expected=%d actual=%d", http.StatusNoContent, response.StatusCode)
+	}
+	if response.Header.Get("ETag") == etag {
+		t.Errorf("expected a new ETag, actual=%s", etag)
+	}
+	for _, method := range []string{"PUT", "DELETE"} {
+		response, _ = request(method, DAVURL+"work/final.md", "lost update #work", "If-Match", etag)
+		if response.StatusCode != http.StatusPreconditionFailed {
+			t.Errorf("%s with old ETag: expected=%d actual=%d", method, http.StatusPreconditionFailed, response.StatusCode)
+		}
+	}
+	_, body = request("GET", DAVURL+"work/final.md", "")
+	if body != "final #work" {
+		t.Errorf("expected=%q actual=%q", "final #work", body)
+	}
+}
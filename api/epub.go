@@ -0,0 +1,286 @@
+/*
+This file is part of Tessernote.
+
+Tessernote is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+Tessernote is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with Tessernote.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package api
+
+import (
+	"appengine"
+	"archive/zip"
+	"bytes"
+	"fmt"
+	"github.com/oschmid/tessernote"
+	"github.com/oschmid/tessernote/convert"
+	"github.com/oschmid/tessernote/hashtag"
+	"html"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+)
+
+// An EPUB (3.0) export has a page for every selected Note, in the order given by the sort parameter (see
+// tessernote.SortNotes), and a table of contents with a section for every selected Tag, e.g.
+// /export.epub?tags=go,status:done&sort=lm. Without tags every Note is exported with a section for every Tag and
+// one for untagged Notes. Notes are in the reading order of the first section they're in.
+const (
+	EPUBURL       = "/export.epub"
+	epubDir       = "OEBPS/"
+	epubMediaType = "application/xhtml+xml"
+	epubCSS       = "body { font-family: serif; line-height: 1.4; }\n" +
+		"a.tag { text-decoration: none; }\n" +
+		"pre { white-space: pre-wrap; font-size: 0.9em; }\n" +
+		"blockquote { margin-left: 1em; font-style: italic; }\n"
+)
+
+// epubSection is a section of the table of contents.
+type epubSection struct {
+	ID    string // of its entry in the table of contents
+	Title string
+	Tag   string // folded name of its Tag, empty for untagged Notes
+	Notes []int  // indexes of its Notes
+}
+
+// serveEPUB handles requests to export the authorized User's Notes matching tags as an EPUB.
+func serveEPUB(w http.ResponseWriter, r *http.Request) {
+	c := appengine.NewContext(r)
+	if r.Method != "GET" {
+		http.NotFound(w, r)
+		return
+	}
+	notebook := authorizedNotebook(w, r, c, tessernote.APIToken)
+	if notebook == nil {
+		return
+	}
+	title := "Tessernote"
+	var notes []tessernote.Note
+	var tags []tessernote.Tag
+	var err error
+	if selectors := r.FormValue("tags"); selectors != "" {
+		groups, err := notebook.TagsMatching(splitSelectors(selectors), c)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+		notes, err = tessernote.MatchingNotes(groups, c)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		for _, group := range groups {
+			tags = append(tags, group...)
+		}
+		title = "#" + strings.Replace(selectors, tagSeparator, " #", -1)
+	} else {
+		notes, err = notebook.Notes(c)
+		if err == nil {
+			tags, err = notebook.Tags(c)
+		}
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+	}
+	order := r.FormValue("sort")
+	if tessernote.Orders.FindString(order) != order {
+		order = tessernote.AlphaAscending
+	}
+	tessernote.SortNotes(notes, order)
+	w.Header().Set("Content-Type", "application/epub+zip")
+	w.Header().Set("Content-Disposition", `attachment; filename="tessernote.epub"`)
+	err = writeEPUB(w, title, notes, tags)
+	if err != nil {
+		c.Errorf("writing epub (%s, %d): %s", title, len(notes), err)
+	}
+}
+
+// writeEPUB writes notes as an EPUB to w with a section of the table of contents for each of tags that has notes.
+func writeEPUB(w io.Writer, title string, notes []tessernote.Note, tags []tessernote.Tag) error {
+	// group notes by tag
+	index := make(map[string]int, len(notes)) // Note ID -> index in notes
+	for i, note := range notes {
+		index[note.ID] = i
+	}
+	var sections []epubSection
+	sectionOf := make(map[string]int) // folded Tag name -> index in sections
+	inSection := make([]bool, len(notes))
+	for _, tag := range tags {
+		folded := hashtag.Fold(tag.Name)
+		if _, ok := sectionOf[folded]; ok {
+			continue
+		}
+		section := epubSection{ID: fmt.Sprintf("tag%d", len(sections)+1), Title: "#" + tag.Name, Tag: folded}
+		for _, key := range tag.NoteKeys {
+			if i, ok := index[key.Encode()]; ok {
+				section.Notes = append(section.Notes, i)
+				inSection[i] = true
+			}
+		}
+		if len(section.Notes) == 0 {
+			continue
+		}
+		sort.Ints(section.Notes)
+		sectionOf[folded] = len(sections)
+		sections = append(sections, section)
+	}
+	untagged := epubSection{ID: "untagged", Title: "Untagged"}
+	for i := range notes {
+		if !inSection[i] {
+			untagged.Notes = append(untagged.Notes, i)
+		}
+	}
+	if len(untagged.Notes) > 0 {
+		sections = append(sections, untagged)
+	}
+
+	// reading order
+	var spine []int
+	page := make([]string, len(notes)) // file name of each Note
+	for _, section := range sections {
+		for _, i := range section.Notes {
+			if page[i] == "" {
+				page[i] = fmt.Sprintf("n%d.xhtml", len(spine)+1)
+				spine = append(spine, i)
+			}
+		}
+	}
+
+	modified := time.Now()
+	if len(notes) > 0 {
+		modified = notes[0].LastModified
+		for _, note := range notes {
+			if note.LastModified.After(modified) {
+				modified = note.LastModified
+			}
+		}
+	}
+
+	archive := zip.NewWriter(w)
+	// the mimetype must come first and be stored uncompressed
+	file, err := archive.CreateHeader(&zip.FileHeader{Name: "mimetype", Method: zip.Store})
+	if err == nil {
+		_, err = io.WriteString(file, "application/epub+zip")
+	}
+	if err != nil {
+		return err
+	}
+	container := `<?xml version="1.0" encoding="utf-8"?>` + "\n" +
+		`<container version="1.0" xmlns="urn:oasis:names:tc:opendocument:xmlns:container">` +
+		`<rootfiles><rootfile full-path="` + epubDir + `content.opf" media-type="application/oebps-package+xml"/>` +
+		"</rootfiles></container>\n"
+	err = writeZipFile(archive, "META-INF/container.xml", container)
+	if err == nil {
+		err = writeZipFile(archive, epubDir+"content.opf", epubPackage(title, modified, page, spine))
+	}
+	if err == nil {
+		err = writeZipFile(archive, epubDir+"nav.xhtml", epubNav(title, notes, sections, page))
+	}
+	if err == nil {
+		err = writeZipFile(archive, epubDir+"style.css", epubCSS)
+	}
+	if err != nil {
+		return err
+	}
+	tagURL := func(name string) string {
+		if i, ok := sectionOf[hashtag.Fold(name)]; ok {
+			return "nav.xhtml#" + sections[i].ID
+		}
+		return ""
+	}
+	for _, i := range spine {
+		body := convert.HTML(xmlSafe(notes[i].Body), tagURL)
+		err = writeZipFile(archive, epubDir+page[i], epubXHTML(noteTitle(notes[i]), "<article>\n"+body+"</article>"))
+		if err != nil {
+			return err
+		}
+	}
+	return archive.Close()
+}
+
+// epubPackage returns the package document listing the files of an EPUB.
+func epubPackage(title string, modified time.Time, page []string, spine []int) string {
+	var b bytes.Buffer
+	b.WriteString(`<?xml version="1.0" encoding="utf-8"?>` + "\n")
+	b.WriteString(`<package xmlns="http://www.idpf.org/2007/opf" version="3.0" unique-identifier="id">` + "\n")
+	b.WriteString(`<metadata xmlns:dc="http://purl.org/dc/elements/1.1/">` + "\n")
+	b.WriteString(`<dc:identifier id="id">` + xmlText(entryIDPrefix+"epub/"+hashtag.Fold(title)) + "</dc:identifier>\n")
+	b.WriteString("<dc:title>" + xmlText(title) + "</dc:title>\n")
+	b.WriteString("<dc:language>en</dc:language>\n")
+	b.WriteString(`<meta property="dcterms:modified">` + modified.UTC().Format("2006-01-02T15:04:05Z") + "</meta>\n")
+	b.WriteString("</metadata>\n<manifest>\n")
+	b.WriteString(`<item id="nav" href="nav.xhtml" media-type="` + epubMediaType + `" properties="nav"/>` + "\n")
+	b.WriteString(`<item id="css" href="style.css" media-type="text/css"/>` + "\n")
+	for _, i := range spine {
+		id := strings.TrimSuffix(page[i], ".xhtml")
+		b.WriteString(`<item id="` + id + `" href="` + page[i] + `" media-type="` + epubMediaType + `"/>` + "\n")
+	}
+	b.WriteString("</manifest>\n<spine>\n")
+	b.WriteString(`<itemref idref="nav"/>` + "\n")
+	for _, i := range spine {
+		b.WriteString(`<itemref idref="` + strings.TrimSuffix(page[i], ".xhtml") + `"/>` + "\n")
+	}
+	b.WriteString("</spine>\n</package>\n")
+	return b.String()
+}
+
+// epubNav returns the table of contents of an EPUB.
+func epubNav(title string, notes []tessernote.Note, sections []epubSection, page []string) string {
+	var b bytes.Buffer
+	b.WriteString(`<nav epub:type="toc" id="toc">` + "\n<h1>" + xmlText(title) + "</h1>\n<ol>\n")
+	for _, section := range sections {
+		b.WriteString(`<li id="` + section.ID + `"><a href="` + page[section.Notes[0]] + `">` + xmlText(section.Title) + "</a>\n<ol>\n")
+		for _, i := range section.Notes {
+			b.WriteString(`<li><a href="` + page[i] + `">` + xmlText(noteTitle(notes[i])) + "</a></li>\n")
+		}
+		b.WriteString("</ol>\n</li>\n")
+	}
+	b.WriteString("</ol>\n</nav>")
+	return epubXHTML(title, b.String())
+}
+
+// epubXHTML returns an XHTML content document with body.
+func epubXHTML(title, body string) string {
+	return `<?xml version="1.0" encoding="utf-8"?>` + "\n<!DOCTYPE html>\n" +
+		`<html xmlns="http://www.w3.org/1999/xhtml" xmlns:epub="http://www.idpf.org/2007/ops" lang="en" xml:lang="en">` + "\n" +
+		"<head>\n<meta charset=\"utf-8\"/>\n<title>" + xmlText(title) + "</title>\n" +
+		`<link rel="stylesheet" type="text/css" href="style.css"/>` + "\n</head>\n<body>\n" + body + "\n</body>\n</html>\n"
+}
+
+// writeZipFile adds a file to archive.
+func writeZipFile(archive *zip.Writer, name, content string) error {
+	file, err := archive.Create(name)
+	if err != nil {
+		return err
+	}
+	_, err = io.WriteString(file, content)
+	return err
+}
+
+// xmlText escapes s for XML, leaving out characters XML doesn't allow.
+func xmlText(s string) string {
+	return html.EscapeString(xmlSafe(s))
+}
+
+// xmlSafe returns s without the characters XML doesn't allow, e.g. control characters other than tab and newlines.
+func xmlSafe(s string) string {
+	return strings.Map(func(r rune) rune {
+		if r == '\t' || r == '\n' || r == '\r' || (r >= 0x20 && r <= 0xd7ff) || (r >= 0xe000 && r <= 0xfffd) || r >= 0x10000 {
+			return r
+		}
+		return -1
+	}, s)
+}
@@ -0,0 +1,116 @@
+/*
+This file is part of Tessernote.
+
+Tessernote is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+Tessernote is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with Tessernote.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package api
+
+import (
+	"archive/zip"
+	"bytes"
+	"github.com/oschmid/tessernote"
+	"io/ioutil"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+)
+
+var (
+	epubSpineItem = regexp.MustCompile(`<itemref idref="(n[0-9]+)"/>`)
+	epubNavNote   = regexp.MustCompile(`<li><a href="(n[0-9]+)\.xhtml">([^<]*)</a></li>`)
+	epubPageTitle = regexp.MustCompile(`<title>([^<]*)</title>`)
+)
+
+// readEPUB returns the files of an EPUB by name, failing the test unless the mimetype is the first entry, stored
+// uncompressed without extra fields so readers can find it at a fixed offset.
+func readEPUB(t *testing.T, epub []byte) map[string]string {
+	reader, err := zip.NewReader(bytes.NewReader(epub), int64(len(epub)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(reader.File) == 0 || reader.File[0].Name != "mimetype" || reader.File[0].Method != zip.Store {
+		t.Fatal("first entry isn't a stored mimetype")
+	}
+	if !bytes.HasPrefix(epub[30:], []byte("mimetypeapplication/epub+zip")) {
+		t.Fatalf("mimetype isn't at offset 30: %q", epub[:60])
+	}
+	files := make(map[string]string)
+	for _, file := range reader.File {
+		r, err := file.Open()
+		if err != nil {
+			t.Fatal(err)
+		}
+		data, err := ioutil.ReadAll(r)
+		r.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		files[file.Name] = string(data)
+	}
+	return files
+}
+
+func TestEPUBOrder(t *testing.T) {
+	day := time.Date(2013, 5, 1, 0, 0, 0, 0, time.UTC)
+	notes := []tessernote.Note{
+		{ID: "b", Body: "Banana", Created: day, LastModified: day.AddDate(0, 0, 1)},
+		{ID: "a", Body: "apple", Created: day.AddDate(0, 0, 1), LastModified: day.AddDate(0, 0, 3)},
+		{ID: "c", Body: "Cherry", Created: day.AddDate(0, 0, 2), LastModified: day.AddDate(0, 0, 2)},
+	}
+	for _, test := range []struct {
+		order string
+		want  []string // titles in reading order
+	}{
+		{tessernote.AlphaAscending, []string{"apple", "Banana", "Cherry"}},
+		{tessernote.AlphaDescending, []string{"Cherry", "Banana", "apple"}},
+		{tessernote.LastModified, []string{"apple", "Cherry", "Banana"}},
+		{tessernote.FirstCreated, []string{"Banana", "apple", "Cherry"}},
+	} {
+		sorted := append([]tessernote.Note(nil), notes...)
+		tessernote.SortNotes(sorted, test.order)
+		var b bytes.Buffer
+		err := writeEPUB(&b, "Tessernote", sorted, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		files := readEPUB(t, b.Bytes())
+
+		var pages, spine []string
+		for _, match := range epubSpineItem.FindAllStringSubmatch(files[epubDir+"content.opf"], -1) {
+			pages = append(pages, match[1])
+			page := files[epubDir+match[1]+".xhtml"]
+			title := epubPageTitle.FindStringSubmatch(page)
+			if title == nil {
+				t.Fatalf("%s: missing page %s", test.order, match[1])
+			}
+			spine = append(spine, title[1])
+		}
+		if strings.Join(spine, ",") != strings.Join(test.want, ",") {
+			t.Errorf("%s: spine %q, want %q", test.order, spine, test.want)
+		}
+
+		var nav []string
+		for i, match := range epubNavNote.FindAllStringSubmatch(files[epubDir+"nav.xhtml"], -1) {
+			if i < len(pages) && match[1] != pages[i] {
+				t.Errorf("%s: nav entry %d links to %s, not the spine's page", test.order, i, match[1])
+			}
+			nav = append(nav, match[2])
+		}
+		if strings.Join(nav, ",") != strings.Join(test.want, ",") {
+			t.Errorf("%s: nav %q, want %q", test.order, nav, test.want)
+		}
+	}
+}
@@ -0,0 +1,68 @@
+/*
+This file is part of Tessernote.
+
+Tessernote is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+Tessernote is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with Tessernote.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package api
+
+import (
+	"appengine"
+	"github.com/oschmid/tessernote"
+	"github.com/oschmid/tessernote/convert"
+	"net/http"
+)
+
+const ExportURL = "/export.zip"
+
+// serveExport handles requests to export the authorized User's Notebook.
+func serveExport(w http.ResponseWriter, r *http.Request) {
+	c := appengine.NewContext(r)
+	notebook := authorizedNotebook(w, r, c, tessernote.APIToken)
+	if notebook == nil {
+		return
+	}
+	if r.Method == "GET" {
+		ExportNotes(w, c, notebook)
+	} else {
+		http.NotFound(w, r)
+	}
+}
+
+// ExportNotes writes every Note in the authorized User's Notebook to w as a Markdown archive, see
+// convert.WriteMarkdownArchive. The archive can be imported again with format markdown.
+func ExportNotes(w http.ResponseWriter, c appengine.Context, notebook *tessernote.Notebook) {
+	notes, err := notebook.Notes(c)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	converted := make([]convert.Note, len(notes))
+	for i, note := range notes {
+		converted[i] = convert.Note{
+			ID:           note.ID,
+			Body:         note.Body,
+			Created:      note.Created,
+			LastModified: note.LastModified,
+			Pinned:       note.Pinned,
+			Archived:     note.Archived,
+		}
+	}
+	w.Header().Set("Content-Type", "application/zip")
+	w.Header().Set("Content-Disposition", `attachment; filename="tessernote.zip"`)
+	err = convert.WriteMarkdownArchive(w, converted)
+	if err != nil {
+		c.Errorf("writing markdown archive (%d): %s", len(converted), err)
+	}
+}
@@ -0,0 +1,176 @@
+/*
+This file is part of Tessernote.
+
+Tessernote is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+Tessernote is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with Tessernote.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package api
+
+import (
+	"appengine"
+	"encoding/xml"
+	"github.com/oschmid/tessernote"
+	"github.com/oschmid/tessernote/convert"
+	"github.com/oschmid/tessernote/hashtag"
+	"io"
+	"net/http"
+	"net/url"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Feeds list the latest Notes matching tags, e.g. /feeds/go,status:done.atom?token=<feed token>. The tags are
+// selectors like on pages (see parseSelectedTags). Feed readers can't log in so feeds are authorized with the
+// User's feed token (see /tokens/feed).
+const (
+	FeedsURL  = "/feeds/"
+	feedLimit = 50
+)
+
+var validFeedURL = regexp.MustCompile("^" + FeedsURL + "(" + tagsPattern + ")\\.(atom|rss)$")
+
+// rssFeed is an RSS 2.0 feed.
+type rssFeed struct {
+	XMLName xml.Name   `xml:"rss"`
+	Version string     `xml:"version,attr"`
+	Channel rssChannel `xml:"channel"`
+}
+
+type rssChannel struct {
+	Title         string    `xml:"title"`
+	Link          string    `xml:"link"`
+	Description   string    `xml:"description"`
+	LastBuildDate string    `xml:"lastBuildDate,omitempty"`
+	Items         []rssItem `xml:"item"`
+}
+
+type rssItem struct {
+	Title       string   `xml:"title"`
+	GUID        rssGUID  `xml:"guid"`
+	PubDate     string   `xml:"pubDate"`
+	Categories  []string `xml:"category"`
+	Description string   `xml:"description"`
+}
+
+type rssGUID struct {
+	IsPermaLink bool   `xml:"isPermaLink,attr"`
+	ID          string `xml:",chardata"`
+}
+
+// serveFeed handles requests for Atom and RSS feeds of the Notes matching tags, most recently modified first.
+func serveFeed(w http.ResponseWriter, r *http.Request) {
+	match := validFeedURL.FindStringSubmatch(r.URL.Path)
+	if match == nil || r.Method != "GET" {
+		http.NotFound(w, r)
+		return
+	}
+	c := appengine.NewContext(r)
+	notebook := authorizedNotebook(w, r, c, tessernote.FeedToken)
+	if notebook == nil {
+		return
+	}
+	tags, format := match[1], match[len(match)-1]
+	groups, err := notebook.TagsMatching(splitSelectors(tags), c)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	notes, err := tessernote.MatchingNotes(groups, c)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	sort.SliceStable(notes, func(i, j int) bool { return notes[i].LastModified.After(notes[j].LastModified) })
+	if len(notes) > feedLimit {
+		notes = notes[:feedLimit]
+	}
+
+	updated := time.Unix(0, 0)
+	if len(notes) > 0 {
+		updated = notes[0].LastModified
+	}
+	if notModified(w, r, updated) {
+		return
+	}
+
+	base := "https://" + r.Host
+	page := base + "/" + tags
+	tagURL := func(name string) string { return base + "/" + url.PathEscape(name) }
+	title := "#" + strings.Replace(tags, tagSeparator, " #", -1)
+	if format == "rss" {
+		w.Header().Set("Content-Type", "application/rss+xml; charset=utf-8")
+		err = writeRSS(w, title, page, updated, notes, tagURL)
+	} else {
+		w.Header().Set("Content-Type", "application/atom+xml; charset=utf-8")
+		feed := atomFeed{
+			Title:   title,
+			ID:      entryIDPrefix + "feed/" + hashtag.Fold(tags),
+			Updated: atomTime(updated),
+			Author:  atomAuthor{"Tessernote"},
+			Links: []atomLink{
+				{Rel: "self", Type: "application/atom+xml", Href: base + r.URL.Path},
+				{Rel: "alternate", Type: "text/html", Href: page},
+			},
+		}
+		for _, note := range notes {
+			feed.Entries = append(feed.Entries, newAtomEntry(note, "", convert.HTML(note.Body, tagURL)))
+		}
+		err = writeAtom(w, feed)
+	}
+	if err != nil {
+		c.Errorf("writing %s feed (%s): %s", format, tags, err)
+	}
+}
+
+// notModified answers 304 Not Modified and returns true if the If-Modified-Since header of r is at or after
+// updated, otherwise it sets the Last-Modified header. HTTP dates only have seconds.
+func notModified(w http.ResponseWriter, r *http.Request, updated time.Time) bool {
+	if since, err := http.ParseTime(r.Header.Get("If-Modified-Since")); err == nil && !updated.Truncate(time.Second).After(since) {
+		w.WriteHeader(http.StatusNotModified)
+		return true
+	}
+	w.Header().Set("Last-Modified", updated.UTC().Format(http.TimeFormat))
+	return false
+}
+
+// writeRSS writes an RSS feed of notes to w.
+func writeRSS(w io.Writer, title, link string, updated time.Time, notes []tessernote.Note, tagURL func(string) string) error {
+	feed := rssFeed{
+		Version: "2.0",
+		Channel: rssChannel{
+			Title:         title,
+			Link:          link,
+			Description:   "Tessernote notes tagged " + title,
+			LastBuildDate: updated.UTC().Format(time.RFC1123Z),
+		},
+	}
+	for _, note := range notes {
+		feed.Channel.Items = append(feed.Channel.Items, rssItem{
+			Title:       noteTitle(note),
+			GUID:        rssGUID{false, noteEntryID(note)},
+			PubDate:     note.LastModified.UTC().Format(time.RFC1123Z),
+			Categories:  convert.TagNames(note.Body),
+			Description: convert.HTML(note.Body, tagURL),
+		})
+	}
+	_, err := io.WriteString(w, xml.Header)
+	if err != nil {
+		return err
+	}
+	encoder := xml.NewEncoder(w)
+	encoder.Indent("", "  ")
+	return encoder.Encode(feed)
+}
@@ -0,0 +1,150 @@
+/*
+This file is part of Tessernote.
+
+Tessernote is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+Tessernote is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with Tessernote.  If not, see <http://www.gnu.org/licenses/>.
+*/
+package api
+
+import (
+	"bytes"
+	"encoding/xml"
+	"github.com/oschmid/tessernote"
+	"github.com/oschmid/tessernote/convert"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// feedNotes returns a Note with markup to escape and the same Note edited later.
+func feedNotes() (note, edited tessernote.Note) {
+	created := time.Date(2024, 3, 1, 9, 30, 0, 0, time.UTC)
+	note = tessernote.Note{
+		ID:           "agtkZXZ-dGVzc2Vybm90ZXIKCxIETm90ZRgBDA",
+		Body:         "# Tips & <tricks>\n\nUse `a < b` & <script>alert(1)</script> #go",
+		Created:      created,
+		LastModified: created.Add(time.Hour),
+	}
+	edited = note
+	edited.Body = "# Tips, edited\n\nnew text #go #web"
+	edited.LastModified = created.Add(48 * time.Hour)
+	return note, edited
+}
+
+func tagURL(name string) string {
+	return "https://example.com/" + name
+}
+
+func TestWriteRSS(t *testing.T) {
+	note, edited := feedNotes()
+	var ids []string
+	for _, n := range []tessernote.Note{note, edited} {
+		var b bytes.Buffer
+		err := writeRSS(&b, "#go", "https://example.com/go", n.LastModified, []tessernote.Note{n}, tagURL)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if strings.Contains(b.String(), "<script>") || strings.Contains(b.String(), "<tricks>") {
+			t.Errorf("unescaped markup in %s", b.String())
+		}
+		var feed rssFeed
+		err = xml.Unmarshal(b.Bytes(), &feed)
+		if err != nil {
+			t.Fatalf("invalid XML: %s\n%s", err, b.String())
+		}
+		if len(feed.Channel.Items) != 1 {
+			t.Fatalf("expected 1 item, actual=%d", len(feed.Channel.Items))
+		}
+		item := feed.Channel.Items[0]
+		if item.Title != noteTitle(n) || item.Description != convert.HTML(n.Body, tagURL) {
+			t.Errorf("expected=%q,%q actual=%q,%q", noteTitle(n), convert.HTML(n.Body, tagURL), item.Title,
+				item.Description)
+		}
+		if item.GUID.IsPermaLink || item.PubDate != n.LastModified.Format(time.RFC1123Z) {
+			t.Errorf("unexpected guid or date %#v", item)
+		}
+		if feed.Channel.LastBuildDate != n.LastModified.Format(time.RFC1123Z) {
+			t.Errorf("expected=%s actual=%s", n.LastModified.Format(time.RFC1123Z), feed.Channel.LastBuildDate)
+		}
+		ids = append(ids, item.GUID.ID)
+	}
+	if ids[0] != ids[1] || !strings.HasSuffix(ids[0], note.ID) {
+		t.Errorf("expected the same ID for both versions of the note, actual=%q", ids)
+	}
+}
+
+func TestWriteAtom(t *testing.T) {
+	note, edited := feedNotes()
+	other := edited
+	other.ID = "agtkZXZ-dGVzc2Vybm90ZXIKCxIETm90ZRgCDA"
+	var ids []string
+	for _, n := range []tessernote.Note{note, edited, other} {
+		var b bytes.Buffer
+		feed := atomFeed{Title: "#go & more", ID: entryIDPrefix + "feed/go", Updated: atomTime(n.LastModified)}
+		feed.Entries = append(feed.Entries, newAtomEntry(n, "", convert.HTML(n.Body, tagURL)))
+		err := writeAtom(&b, feed)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if strings.Contains(b.String(), "<script>") || strings.Contains(b.String(), "<tricks>") {
+			t.Errorf("unescaped markup in %s", b.String())
+		}
+		var decoded atomFeed
+		err = xml.Unmarshal(b.Bytes(), &decoded)
+		if err != nil {
+			t.Fatalf("invalid XML: %s\n%s", err, b.String())
+		}
+		if decoded.Title != feed.Title || len(decoded.Entries) != 1 {
+			t.Fatalf("expected=%#v actual=%#v", feed, decoded)
+		}
+		entry := decoded.Entries[0]
+		if entry.Title != noteTitle(n) || entry.Content.Type != "html" || entry.Content.Body != convert.HTML(n.Body, tagURL) {
+			t.Errorf("unexpected entry %#v", entry)
+		}
+		if entry.Published != "2024-03-01T09:30:00Z" || entry.Updated != atomTime(n.LastModified) {
+			t.Errorf("unexpected dates %s, %s", entry.Published, entry.Updated)
+		}
+		ids = append(ids, entry.ID)
+	}
+	if ids[0] != ids[1] || ids[1] == ids[2] {
+		t.Errorf("expected stable IDs per note, actual=%q", ids)
+	}
+}
+
+func TestNotModified(t *testing.T) {
+	updated := time.Date(2024, 3, 3, 9, 30, 0, 500000000, time.UTC)
+	for _, test := range []struct {
+		since string
+		code  int
+	}{
+		{"", http.StatusOK},
+		{"not a date", http.StatusOK},
+		{"Sun, 03 Mar 2024 09:29:59 GMT", http.StatusOK},
+		{"Sun, 03 Mar 2024 09:30:00 GMT", http.StatusNotModified}, // same second
+		{"Mon, 04 Mar 2024 00:00:00 GMT", http.StatusNotModified},
+	} {
+		r := httptest.NewRequest("GET", FeedsURL+"go.atom", nil)
+		if test.since != "" {
+			r.Header.Set("If-Modified-Since", test.since)
+		}
+		w := httptest.NewRecorder()
+		if notModified(w, r, updated) != (test.code == http.StatusNotModified) || w.Code != test.code {
+			t.Errorf("%q: expected=%d actual=%d", test.since, test.code, w.Code)
+		}
+		if test.code == http.StatusOK && w.Header().Get("Last-Modified") != "Sun, 03 Mar 2024 09:30:00 GMT" {
+			t.Errorf("%q: unexpected Last-Modified %q", test.since, w.Header().Get("Last-Modified"))
+		}
+	}
+}
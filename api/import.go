@@ -0,0 +1,82 @@
+/*
+This file is part of Tessernote.
+
+Tessernote is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+Tessernote is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with Tessernote.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package api
+
+import (
+	"appengine"
+	"encoding/json"
+	"github.com/oschmid/tessernote"
+	"net/http"
+)
+
+const ImportURL = "/import/"
+
+// serveImport handles requests to Tessernote's import API.
+func serveImport(w http.ResponseWriter, r *http.Request) {
+	c := appengine.NewContext(r)
+	notebook := authorizedNotebook(w, r, c, tessernote.APIToken)
+	if notebook == nil {
+		return
+	}
+	if r.URL.Path == ImportURL && r.Method == "POST" {
+		ImportNotes(w, r, c, notebook)
+	} else {
+		http.NotFound(w, r)
+	}
+}
+
+// ImportNotes adds the list of Notes given as JSON input to the authorized User's Notebook, keeping their Created
+// and LastModified times. Notes already in the Notebook are skipped unless the duplicates parameter is "keep".
+// If the dryrun parameter is "true" nothing is changed. What was (or would be) imported is written in JSON format
+// to w.
+func ImportNotes(w http.ResponseWriter, r *http.Request, c appengine.Context, notebook *tessernote.Notebook) {
+	body, err := readRequestBody(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	var notes []tessernote.Note
+	err = json.Unmarshal(body, &notes)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	for i := range notes {
+		notes[i].TagKeys, notes[i].NotebookKeys = nil, nil // calculated from Body
+	}
+	options := tessernote.ImportOptions{
+		DryRun:         r.FormValue("dryrun") == "true",
+		KeepDuplicates: r.FormValue("duplicates") == "keep",
+	}
+	report, err := notebook.Import(notes, options, c)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if report.Failures == nil {
+		report.Failures = make([]tessernote.ImportFailure, 0)
+	}
+	reply, err := json.Marshal(report)
+	if err != nil {
+		c.Errorf("marshaling import report (%d): %s", len(report.IDs), err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(reply)
+}
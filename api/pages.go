@@ -31,12 +31,15 @@ import (
 )
 
 var (
-	tagPattern   = "(" + hashtag.AlphaNumeric + "*" + hashtag.Alpha + hashtag.AlphaNumeric + "*)" // hashtag pattern without the hash mark
-	tagSeparator = ","
-	tagsPattern  = "(" + tagPattern + "+\\" + tagSeparator + ")*" + tagPattern + "+"
-	untaggedURL  = "/untagged/"
-	validPageURL = regexp.MustCompile("^(/|(" + untaggedURL + ")|(/" + tagsPattern + "))(" + sortOrderURL.String() + ")?$")
-	templates    = getTemplates()
+	tagPattern      = "(" + hashtag.NamePattern + "(" + hashtag.ValueSeparator + hashtag.ValuePattern + ")?)" // hashtag pattern without the hash mark
+	valuesPattern   = "(" + regexp.QuoteMeta(tessernote.AnyValue) + "|" + hashtag.ValuePattern + "|(" + hashtag.ValuePattern + ")?" + regexp.QuoteMeta(tessernote.RangeSeparator) + "(" + hashtag.ValuePattern + ")?)"
+	selectorPattern = "(" + hashtag.NamePattern + "(" + hashtag.ValueSeparator + valuesPattern + ")?)" // see tessernote.Selector
+	tagSeparator    = ","
+	tagsPattern     = "(" + selectorPattern + "+\\" + tagSeparator + ")*" + selectorPattern + "+"
+	untaggedURL     = "/untagged/"
+	inboxURL        = "/inbox/"
+	validPageURL    = regexp.MustCompile("^(/|(" + untaggedURL + ")|(" + inboxURL + ")|(/" + tagsPattern + "))(" + sortOrderURL.String() + ")?$")
+	templates       = getTemplates()
 )
 
 func init() {
@@ -45,8 +48,31 @@ func init() {
 
 // serve handles Tessernote's page requests
 func serve(w http.ResponseWriter, r *http.Request) {
-	if validDataURL.MatchString(r.URL.Path) {
+	if validDataURL.MatchString(r.URL.Path) || validSuggestedTagsURL.MatchString(r.URL.Path) ||
+		validSimilarURL.MatchString(r.URL.Path) {
 		serveData(w, r)
+	} else if validTagsURL.MatchString(r.URL.Path) {
+		serveTags(w, r)
+	} else if validAliasesURL.MatchString(r.URL.Path) {
+		serveAliases(w, r)
+	} else if strings.HasPrefix(r.URL.Path, DAVURL) {
+		serveDAV(w, r)
+	} else if strings.HasPrefix(r.URL.Path, FeedsURL) {
+		serveFeed(w, r)
+	} else if strings.HasPrefix(r.URL.Path, TokensURL) {
+		serveTokens(w, r)
+	} else if r.URL.Path == ExportURL {
+		serveExport(w, r)
+	} else if r.URL.Path == EPUBURL {
+		serveEPUB(w, r)
+	} else if r.URL.Path == CalendarURL {
+		serveCalendar(w, r)
+	} else if r.URL.Path == SiteURL {
+		serveSite(w, r)
+	} else if r.URL.Path == ImportURL {
+		serveImport(w, r)
+	} else if strings.HasPrefix(r.URL.Path, AdminURL) {
+		serveAdmin(w, r)
 	} else if validPageURL.MatchString(r.URL.Path) {
 		c := appengine.NewContext(r)
 		if !loggedIn(w, r, c) {
@@ -66,10 +92,14 @@ func serve(w http.ResponseWriter, r *http.Request) {
 			return
 		}
 
-		selectedTags, err := parseSelectedTags(w, r, notebook, c)
+		selectedGroups, err := parseSelectedTags(w, r, notebook, c)
 		if err != nil {
 			return
 		}
+		var selectedTags []tessernote.Tag
+		for _, group := range selectedGroups {
+			selectedTags = append(selectedTags, group...)
+		}
 		page.SetSelectedTags(selectedTags)
 
 		relatedTags, err := notebook.RelatedTags(selectedTags, c)
@@ -78,14 +108,30 @@ func serve(w http.ResponseWriter, r *http.Request) {
 			return
 		}
 		page.SetRelatedTags(relatedTags)
+		strengths, err := notebook.RelatedTagStrengths(selectedTags, c)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		page.SetRelatedTagStrengths(strengths)
+		page.Facets, err = notebook.TagFacets(selectedGroups, c)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
 
 		page.UntaggedNotes = len(notebook.UntaggedNoteKeys) > 0
 		if r.URL.Path == untaggedURL {
 			page.Notes, err = notebook.UntaggedNotes(c)
+		} else if r.URL.Path == inboxURL {
+			page.Notes, err = notebook.UntaggedNotes(c)
+			if err == nil {
+				err = suggestTags(page, notebook, c)
+			}
 		} else if len(selectedTags) == 0 {
 			page.Notes, err = notebook.Notes(c)
 		} else {
-			page.Notes, err = tessernote.RelatedNotes(selectedTags, c)
+			page.Notes, err = tessernote.MatchingNotes(selectedGroups, c)
 		}
 		if err != nil {
 			http.Error(w, err.Error(), http.StatusInternalServerError)
@@ -119,25 +165,47 @@ func loggedIn(w http.ResponseWriter, r *http.Request, c appengine.Context) bool
 	return true
 }
 
-// parseSelectedTags parses url for selected tags and redirects if it refers to missing tags
-func parseSelectedTags(w http.ResponseWriter, r *http.Request, notebook *tessernote.Notebook, c appengine.Context) ([]tessernote.Tag, error) {
-	var names []string
-	if r.URL.Path != "/" && r.URL.Path != untaggedURL {
-		names = strings.Split(r.URL.Path[1:], tagSeparator)
+// parseSelectedTags parses url for selected tags and redirects if it refers to missing tags. Each selector in the
+// url (see tessernote.Selector) selects a group of tags.
+func parseSelectedTags(w http.ResponseWriter, r *http.Request, notebook *tessernote.Notebook, c appengine.Context) ([][]tessernote.Tag, error) {
+	var selectors []string
+	if r.URL.Path != "/" && r.URL.Path != untaggedURL && r.URL.Path != inboxURL {
+		selectors = splitSelectors(r.URL.Path[1:])
 	}
-	tags, err := notebook.TagsFrom(names, c)
+	groups, err := notebook.TagsMatching(selectors, c)
 	if err != nil {
-		names = tessernote.Name(tags)
-		tagString := strings.Join(names, tagSeparator)
+		tagString := strings.Join(selectors[:len(groups)], tagSeparator)
 		http.Redirect(w, r, "/"+tagString, http.StatusFound)
 	}
-	return tags, err
+	return groups, err
+}
+
+// splitSelectors splits the tag part of a url (e.g. "go,status:done") into selectors.
+func splitSelectors(tags string) []string {
+	return strings.Split(tags, tagSeparator)
+}
+
+// suggestTags sets suggested tags for every Note on page.
+func suggestTags(page *tessernote.Page, notebook *tessernote.Notebook, c appengine.Context) error {
+	page.Suggestions = make(map[string][]tessernote.Suggestion)
+	for _, note := range page.Notes {
+		suggestions, err := notebook.SuggestTags(note, c)
+		if err != nil {
+			return err
+		}
+		page.Suggestions[note.ID] = suggestions
+	}
+	return nil
 }
 
 // getTemplates returns Tessernote's HTML templates
 func getTemplates() *template.Template {
+	return template.Must(template.ParseFiles(templateFile("main.html"), templateFile("site.html")))
+}
+
+// templateFile returns the path of a file in the templates directory
+func templateFile(name string) string {
 	pwd, _ := os.Getwd()
-	main := strings.Join([]string{"github.com", "oschmid", "tessernote", "api", "templates", "main.html"}, string(os.PathSeparator))
-	main = filepath.Merge(pwd, main)
-	return template.Must(template.ParseFiles(main))
+	file := strings.Join([]string{"github.com", "oschmid", "tessernote", "api", "templates", name}, string(os.PathSeparator))
+	return filepath.Merge(pwd, file)
 }
@@ -0,0 +1,249 @@
+/*
+This file is part of Tessernote.
+
+Tessernote is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+Tessernote is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with Tessernote.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package api
+
+import (
+	"appengine"
+	"archive/zip"
+	"crypto/sha256"
+	"encoding/hex"
+	"github.com/oschmid/tessernote"
+	"github.com/oschmid/tessernote/convert"
+	"github.com/oschmid/tessernote/hashtag"
+	"html/template"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"time"
+)
+
+// A site is a zip file of static HTML pages for the Notes with a publish tag:
+//
+//	index.html        every published Note, most recently modified first
+//	notes/<slug>.html a published Note rendered from Markdown, its hashtags link to tag pages
+//	tags/<tag>.html   the published Notes with a hashtag
+//	feed.atom         the latest published Notes
+//
+// Pages of Notes are named by a hash of their ID and the publish tag (see siteSlug), so the site doesn't reveal
+// the encoded datastore keys of Notes, which contain the app's and the User's IDs. Only
+// Notes with the publish tag are read, tag pages are made from the hashtags in those Notes rather than from
+// the Notebook's Tags so other Notes can't appear on them.
+const (
+	SiteURL       = "/site.zip"
+	siteNotesDir  = "notes/"
+	siteTagsDir   = "tags/"
+	siteFeedFile  = "feed.atom"
+	siteFeedLimit = 50
+)
+
+// sitePage is the data of a site template.
+type sitePage struct {
+	Site  string // name of the publish tag
+	Title string
+	Root  string // relative URL of the site from the page
+	Notes []siteNote
+	Note  *siteNote
+	Tags  []siteTag
+}
+
+// siteNote is a published Note.
+type siteNote struct {
+	Slug         string // opaque name of its page, see siteSlug
+	Title        string
+	Path         string // relative to the site
+	HTML         template.HTML
+	Created      time.Time
+	LastModified time.Time
+	Tags         []string // folded names of its hashtags
+}
+
+// siteTag is a hashtag used by published Notes.
+type siteTag struct {
+	Name string // the first spelling found
+	Path string // relative to the site
+}
+
+// serveSite handles requests for a site of the authorized User's Notes with the tag in the tag parameter.
+func serveSite(w http.ResponseWriter, r *http.Request) {
+	c := appengine.NewContext(r)
+	if r.Method != "GET" {
+		http.NotFound(w, r)
+		return
+	}
+	name := r.FormValue("tag")
+	if name == "" {
+		http.Error(w, "missing tag parameter", http.StatusBadRequest)
+		return
+	}
+	notebook := authorizedNotebook(w, r, c, tessernote.APIToken)
+	if notebook == nil {
+		return
+	}
+	tags, err := notebook.TagsFrom([]string{name}, c)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	notes, err := tags[0].Notes(c)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/zip")
+	w.Header().Set("Content-Disposition", `attachment; filename="site.zip"`)
+	err = writeSite(w, tags[0].Name, notes)
+	if err != nil {
+		c.Errorf("writing site (%s, %d): %s", tags[0].Name, len(notes), err)
+	}
+}
+
+// writeSite writes a site of notes, which must all have the publish tag, to w as a zip file.
+func writeSite(w io.Writer, publish string, notes []tessernote.Note) error {
+	notes = append([]tessernote.Note(nil), notes...)
+	sort.SliceStable(notes, func(i, j int) bool { return notes[i].LastModified.After(notes[j].LastModified) })
+
+	// collect the hashtags of the published notes
+	var tags []siteTag
+	tagIndex := make(map[string]int) // folded name -> index in tags
+	for _, note := range notes {
+		for _, name := range hashtag.Names(note.Body) {
+			folded := hashtag.Fold(name)
+			if _, ok := tagIndex[folded]; !ok {
+				tagIndex[folded] = len(tags)
+				tags = append(tags, siteTag{name, siteTagFile(folded)})
+			}
+		}
+	}
+	sort.SliceStable(tags, func(i, j int) bool { return hashtag.Fold(tags[i].Name) < hashtag.Fold(tags[j].Name) })
+
+	published := make([]siteNote, len(notes))
+	for i, note := range notes {
+		slug := siteSlug(note, publish)
+		published[i] = siteNote{
+			Slug:  slug,
+			Title: noteTitle(note),
+			Path:  siteNotesDir + slug + ".html",
+			HTML: template.HTML(convert.HTML(note.Body, func(name string) string {
+				return "../" + siteTagFile(hashtag.Fold(name))
+			})),
+			Created:      note.Created,
+			LastModified: note.LastModified,
+		}
+		for _, name := range hashtag.Names(note.Body) {
+			published[i].Tags = append(published[i].Tags, hashtag.Fold(name))
+		}
+	}
+
+	archive := zip.NewWriter(w)
+	page := sitePage{Site: publish, Title: "#" + publish, Notes: published, Tags: tags}
+	err := writeSitePage(archive, "index.html", "site-index", page)
+	if err != nil {
+		return err
+	}
+	for i := range published {
+		page := sitePage{Site: publish, Title: published[i].Title, Root: "../", Note: &published[i], Tags: tags}
+		err = writeSitePage(archive, published[i].Path, "site-note", page)
+		if err != nil {
+			return err
+		}
+	}
+	for _, tag := range tags {
+		folded := hashtag.Fold(tag.Name)
+		page := sitePage{Site: publish, Title: "#" + tag.Name, Root: "../", Tags: tags}
+		for _, note := range published {
+			if containsString(note.Tags, folded) {
+				page.Notes = append(page.Notes, note)
+			}
+		}
+		err = writeSitePage(archive, unescapePath(tag.Path), "site-index", page)
+		if err != nil {
+			return err
+		}
+	}
+
+	feed := atomFeed{
+		Title:  "#" + publish,
+		ID:     entryIDPrefix + "site/" + hashtag.Fold(publish),
+		Author: atomAuthor{publish},
+		Links:  []atomLink{{Rel: "alternate", Type: "text/html", Href: "index.html"}},
+	}
+	if len(notes) > 0 {
+		feed.Updated = atomTime(notes[0].LastModified)
+	} else {
+		feed.Updated = atomTime(time.Now())
+	}
+	for i, note := range notes {
+		if i == siteFeedLimit {
+			break
+		}
+		html := convert.HTML(note.Body, func(name string) string { return siteTagFile(hashtag.Fold(name)) })
+		entry := newAtomEntry(note, published[i].Path, html)
+		entry.ID = feed.ID + "/" + published[i].Slug
+		feed.Entries = append(feed.Entries, entry)
+	}
+	file, err := archive.Create(siteFeedFile)
+	if err != nil {
+		return err
+	}
+	err = writeAtom(file, feed)
+	if err != nil {
+		return err
+	}
+	return archive.Close()
+}
+
+// writeSitePage adds a page to a site.
+func writeSitePage(archive *zip.Writer, path, name string, page sitePage) error {
+	file, err := archive.Create(path)
+	if err != nil {
+		return err
+	}
+	return templates.ExecuteTemplate(file, name, page)
+}
+
+// siteSlug returns the name of a Note's page without extension, a hash of its ID and the publish tag. It stays the
+// same across runs and when the Note is edited, so links to the page keep working.
+func siteSlug(note tessernote.Note, publish string) string {
+	sum := sha256.Sum256([]byte(hashtag.Fold(publish) + "/" + note.ID))
+	return hex.EncodeToString(sum[:10])
+}
+
+// siteTagFile returns the URL of a tag's page relative to the site.
+func siteTagFile(folded string) string {
+	return siteTagsDir + url.PathEscape(convert.TagFile(folded, ".html"))
+}
+
+// unescapePath returns the file name of a relative URL made by siteTagFile.
+func unescapePath(path string) string {
+	unescaped, err := url.PathUnescape(path)
+	if err != nil {
+		return path
+	}
+	return unescaped
+}
+
+// containsString returns true if list contains s.
+func containsString(list []string, s string) bool {
+	for _, t := range list {
+		if t == s {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,270 @@
+/*
+This file is part of Tessernote.
+
+Tessernote is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+Tessernote is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with Tessernote.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package api
+
+import (
+	"appengine"
+	"appengine/user"
+	"encoding/json"
+	"github.com/oschmid/tessernote"
+	"net/http"
+	"regexp"
+)
+
+const (
+	TagsURL     = "/tags/"
+	TagGraphURL = TagsURL + "graph" // GET only, a Tag called graph can still be renamed, merged and deleted
+)
+
+var validTagsURL = regexp.MustCompile("^" + TagsURL + tagPattern + "?$")
+
+// TagInfo is the JSON representation of a Tag.
+type TagInfo struct {
+	Name      string
+	NoteCount int
+	Notes     []tessernote.Note `json:",omitempty"`
+}
+
+// TagGraph is the JSON representation of a Notebook's tag co-occurrence graph.
+type TagGraph struct {
+	Tags  []TagInfo
+	Edges []TagEdge
+}
+
+// TagEdge is the JSON representation of two Tags that share Notes.
+type TagEdge struct {
+	From    string
+	To      string
+	Count   int     // number of Notes with both Tags
+	Jaccard float64 // Count divided by the number of Notes with either Tag
+}
+
+// TagChange is the JSON input for renaming or merging a Tag.
+type TagChange struct {
+	Name string // new name when renaming
+	Into string // name of the Tag to merge into
+}
+
+// serveTags handles requests to Tessernote's RESTful tag API
+func serveTags(w http.ResponseWriter, r *http.Request) {
+	c := appengine.NewContext(r)
+	u := user.Current(c)
+	if u == nil {
+		http.Error(w, "", http.StatusUnauthorized)
+		return
+	}
+	notebook, err := tessernote.CurrentNotebook(c)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if r.URL.Path == TagsURL {
+		switch r.Method {
+		case "GET":
+			GetAllTags(w, c, notebook)
+		default:
+			http.NotFound(w, r)
+		}
+	} else if r.URL.Path == TagGraphURL && r.Method == "GET" {
+		GetTagGraph(w, r, c, notebook)
+	} else {
+		switch r.Method {
+		case "GET":
+			GetTag(w, r, c, notebook)
+		case "PUT":
+			RenameTag(w, r, c, notebook)
+		case "POST":
+			MergeTag(w, r, c, notebook)
+		case "DELETE":
+			DeleteTag(w, r, c, notebook)
+		default:
+			http.NotFound(w, r)
+		}
+	}
+}
+
+// GetAllTags writes a JSON formatted list of all Tags in the authorized User's Notebook and how many Notes
+// each refers to to w.
+func GetAllTags(w http.ResponseWriter, c appengine.Context, notebook *tessernote.Notebook) {
+	tags, err := notebook.Tags(c)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	infos := make([]TagInfo, len(tags))
+	for i, tag := range tags {
+		infos[i] = TagInfo{Name: tag.Name, NoteCount: len(tag.NoteKeys)}
+	}
+	reply, err := json.Marshal(infos)
+	if err != nil {
+		c.Errorf("marshaling tags (%d): %s", len(infos), err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Write(reply)
+}
+
+// GetTag writes the Tag named in the URL and its Notes in JSON format to w.
+func GetTag(w http.ResponseWriter, r *http.Request, c appengine.Context, notebook *tessernote.Notebook) {
+	name := r.URL.Path[len(TagsURL):]
+	tags, err := notebook.TagsFrom([]string{name}, c)
+	if err != nil {
+		writeTagError(w, tessernote.ErrMissingTag)
+		return
+	}
+	notes, err := tags[0].Notes(c)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	writeTag(w, c, TagInfo{Name: tags[0].Name, NoteCount: len(notes), Notes: notes})
+}
+
+// GetTagGraph writes the tag co-occurrence graph of the authorized User's Notebook to w. The format parameter
+// selects JSON (default), Graphviz DOT ("dot") or GraphML ("graphml").
+func GetTagGraph(w http.ResponseWriter, r *http.Request, c appengine.Context, notebook *tessernote.Notebook) {
+	g, err := notebook.TagGraph(c)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	names := make(map[string]string)
+	for label := range g.Nodes {
+		names[label], err = notebook.TagName(label, c)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+	}
+	name := func(label string) string {
+		return names[label]
+	}
+	switch r.FormValue("format") {
+	case "", "json":
+		tagGraph := TagGraph{Tags: make([]TagInfo, 0, len(g.Nodes)), Edges: make([]TagEdge, 0)}
+		for label, count := range g.Nodes {
+			tagGraph.Tags = append(tagGraph.Tags, TagInfo{Name: names[label], NoteCount: count})
+		}
+		for _, edge := range g.AllEdges() {
+			tagGraph.Edges = append(tagGraph.Edges, TagEdge{names[edge.From], names[edge.To], edge.Count, edge.Jaccard})
+		}
+		reply, err := json.Marshal(tagGraph)
+		if err != nil {
+			c.Errorf("marshaling tag graph (%d): %s", len(tagGraph.Edges), err)
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(reply)
+	case "dot":
+		w.Header().Set("Content-Type", "text/vnd.graphviz")
+		err = g.WriteDOT(w, name)
+	case "graphml":
+		w.Header().Set("Content-Type", "application/graphml+xml")
+		err = g.WriteGraphML(w, name)
+	default:
+		http.Error(w, "unknown format", http.StatusBadRequest)
+	}
+	if err != nil {
+		c.Errorf("writing tag graph: %s", err)
+	}
+}
+
+// RenameTag renames the Tag named in the URL to the Name given as JSON input, rewriting the hashtag in all of its
+// Notes. The renamed Tag is written in JSON format to w.
+func RenameTag(w http.ResponseWriter, r *http.Request, c appengine.Context, notebook *tessernote.Notebook) {
+	name := r.URL.Path[len(TagsURL):]
+	change, err := readTagChange(w, r)
+	if err != nil {
+		return
+	}
+	tag, err := notebook.RenameTag(name, change.Name, c)
+	if err != nil {
+		writeTagError(w, err)
+		return
+	}
+	writeTag(w, c, TagInfo{Name: tag.Name, NoteCount: len(tag.NoteKeys)})
+}
+
+// MergeTag moves all Notes of the Tag named in the URL into the Tag named by Into in the JSON input. The Tag that
+// was merged into is written in JSON format to w.
+func MergeTag(w http.ResponseWriter, r *http.Request, c appengine.Context, notebook *tessernote.Notebook) {
+	name := r.URL.Path[len(TagsURL):]
+	change, err := readTagChange(w, r)
+	if err != nil {
+		return
+	}
+	tag, err := notebook.MergeTags(name, change.Into, c)
+	if err != nil {
+		writeTagError(w, err)
+		return
+	}
+	writeTag(w, c, TagInfo{Name: tag.Name, NoteCount: len(tag.NoteKeys)})
+}
+
+// DeleteTag strips the hashtag of the Tag named in the URL from all of its Notes. Uses w to write true if the Tag
+// was deleted.
+func DeleteTag(w http.ResponseWriter, r *http.Request, c appengine.Context, notebook *tessernote.Notebook) {
+	name := r.URL.Path[len(TagsURL):]
+	err := notebook.DeleteTag(name, c)
+	if err != nil {
+		writeTagError(w, err)
+		return
+	}
+	w.Write([]byte("true"))
+}
+
+// readTagChange decodes a JSON formatted TagChange from the request body.
+func readTagChange(w http.ResponseWriter, r *http.Request) (change TagChange, err error) {
+	body, err := readRequestBody(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return change, err
+	}
+	err = json.Unmarshal(body, &change)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return change, err
+	}
+	return change, nil
+}
+
+// writeTag writes info in JSON format to w.
+func writeTag(w http.ResponseWriter, c appengine.Context, info TagInfo) {
+	reply, err := json.Marshal(info)
+	if err != nil {
+		c.Errorf("marshaling tag (%#v): %s", info, err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Write(reply)
+}
+
+// writeTagError writes err to w with a status code matching the kind of error.
+func writeTagError(w http.ResponseWriter, err error) {
+	switch err {
+	case tessernote.ErrMissingTag, tessernote.ErrMissingAlias:
+		http.Error(w, err.Error(), http.StatusNotFound)
+	case tessernote.ErrTagExists:
+		http.Error(w, err.Error(), http.StatusConflict)
+	case tessernote.ErrInvalidTag:
+		http.Error(w, err.Error(), http.StatusBadRequest)
+	default:
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
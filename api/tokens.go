@@ -0,0 +1,121 @@
+/*
+This file is part of Tessernote.
+
+Tessernote is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+Tessernote is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with Tessernote.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package api
+
+import (
+	"appengine"
+	"appengine/user"
+	"encoding/json"
+	"github.com/oschmid/tessernote"
+	"net/http"
+	"strings"
+)
+
+const (
+	TokensURL    = "/tokens/"
+	bearerPrefix = "Bearer "
+)
+
+// serveTokens handles requests for the authorized User's access tokens. GET /tokens/<kind> returns the token of
+// kind (creating it if needed) and POST replaces it with a new one.
+func serveTokens(w http.ResponseWriter, r *http.Request) {
+	c := appengine.NewContext(r)
+	u := user.Current(c)
+	if u == nil {
+		http.Error(w, "", http.StatusUnauthorized)
+		return
+	}
+	kind := r.URL.Path[len(TokensURL):]
+	if !validTokenKind(kind) {
+		http.NotFound(w, r)
+		return
+	}
+	notebook, err := tessernote.CurrentNotebook(c)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	var token string
+	switch r.Method {
+	case "GET":
+		token, err = notebook.Token(kind, c)
+	case "POST":
+		token, err = notebook.ResetToken(kind, c)
+	default:
+		http.NotFound(w, r)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	reply, err := json.Marshal(token)
+	if err != nil {
+		c.Errorf("marshaling token: %s", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.Write(reply)
+}
+
+// authorizedNotebook returns the Notebook of the logged in User or, for programs that can't log in, the Notebook
+// with the token of kind given as a bearer token, as the password of HTTP basic authentication or as the token
+// parameter. It writes an error to w and returns nil if none of them works.
+func authorizedNotebook(w http.ResponseWriter, r *http.Request, c appengine.Context, kind string) *tessernote.Notebook {
+	token := r.FormValue("token")
+	if _, password, ok := r.BasicAuth(); ok {
+		token = password
+	}
+	if auth := r.Header.Get("Authorization"); strings.HasPrefix(auth, bearerPrefix) {
+		token = auth[len(bearerPrefix):]
+	}
+	var notebook *tessernote.Notebook
+	var err error
+	if token != "" {
+		notebook, err = tessernote.NotebookByToken(kind, token, c)
+	} else if user.Current(c) != nil {
+		notebook, err = tessernote.CurrentNotebook(c)
+	} else {
+		err = tessernote.ErrInvalidToken
+	}
+	if err == tessernote.ErrInvalidToken {
+		if kind == tessernote.APIToken {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="Tessernote"`)
+		} else {
+			w.Header().Set("WWW-Authenticate", `Basic realm="Tessernote"`) // DAV clients and feed readers ask for it
+		}
+		http.Error(w, "", http.StatusUnauthorized)
+		return nil
+	} else if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return nil
+	}
+	return notebook
+}
+
+// validTokenKind returns true if kind is one of tessernote.TokenKinds.
+func validTokenKind(kind string) bool {
+	for _, k := range tessernote.TokenKinds {
+		if k == kind {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,91 @@
+/*
+This file is part of Tessernote.
+
+Tessernote is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+Tessernote is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with Tessernote.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package api
+
+import (
+	"appengine/datastore"
+	"context"
+	"errors"
+	"github.com/oschmid/appenginetesting"
+	"github.com/oschmid/tessernote"
+	"github.com/oschmid/tessernote/client"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestClientWithAPIToken(t *testing.T) {
+	c, err := appenginetesting.NewContext(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	notebook := &tessernote.Notebook{ID: "client"}
+	_, err = datastore.Put(c, notebook.Key(c), notebook)
+	if err != nil {
+		t.Fatal(err)
+	}
+	token, err := notebook.Token(tessernote.APIToken, c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	feedToken, err := notebook.Token(tessernote.FeedToken, c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		routeData(w, r, c)
+	}))
+	defer server.Close()
+	ctx := context.Background()
+
+	authorized := client.New(server.URL, token)
+	created, err := authorized.CreateNote(ctx, client.Note{Body: "body #tag"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	note, err := authorized.GetNote(ctx, created.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if note.Body != "body #tag" {
+		t.Fatalf("expected=%s actual=%s", "body #tag", note.Body)
+	}
+	notes, err := authorized.ListNotes(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(notes) != 1 || notes[0].ID != created.ID {
+		t.Fatalf("expected [%s] actual=%v", created.ID, notes)
+	}
+	deleted, err := authorized.DeleteNote(ctx, created.ID)
+	if err != nil || !deleted {
+		t.Fatalf("expected=true actual=%t, %v", deleted, err)
+	}
+	_, err = authorized.GetNote(ctx, created.ID)
+	if !errors.Is(err, client.ErrNotFound) {
+		t.Fatalf("expected=%s actual=%v", client.ErrNotFound, err)
+	}
+
+	for _, token := range []string{"", "wrong", feedToken} {
+		_, err = client.New(server.URL, token).ListNotes(ctx)
+		if !errors.Is(err, client.ErrUnauthorized) {
+			t.Errorf("token %q: expected=%s actual=%v", token, client.ErrUnauthorized, err)
+		}
+	}
+}
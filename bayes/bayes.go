@@ -0,0 +1,161 @@
+/*
+This file is part of Tessernote.
+
+Tessernote is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+Tessernote is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with Tessernote.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+// Package bayes is a multinomial naive Bayes classifier for documents with any number of classes (e.g. tags).
+package bayes
+
+import (
+	"math"
+	"sort"
+	"strings"
+	"unicode"
+)
+
+type Classifier struct {
+	Documents  int                       // number of documents learned
+	Classes    map[string]int            // class -> number of documents
+	Words      map[string]map[string]int // class -> word -> count
+	ClassWords map[string]int            // class -> number of words
+	Vocabulary map[string]int            // word -> count over all classes
+}
+
+// Score is how likely a document belongs to a class.
+type Score struct {
+	Class       string
+	Probability float64
+}
+
+// New creates an empty Classifier.
+func New() *Classifier {
+	return &Classifier{
+		Classes:    make(map[string]int),
+		Words:      make(map[string]map[string]int),
+		ClassWords: make(map[string]int),
+		Vocabulary: make(map[string]int),
+	}
+}
+
+// Learn adds a document made of words that belongs to classes.
+func (c *Classifier) Learn(words, classes []string) {
+	c.update(words, classes, 1)
+}
+
+// Forget removes a document that was learned before.
+func (c *Classifier) Forget(words, classes []string) {
+	c.update(words, classes, -1)
+}
+
+// update adds delta to the counts of a document.
+func (c *Classifier) update(words, classes []string, delta int) {
+	if len(classes) == 0 {
+		return
+	}
+	c.Documents += delta
+	for _, class := range classes {
+		c.Classes[class] += delta
+		if c.Classes[class] <= 0 {
+			delete(c.Classes, class)
+			delete(c.Words, class)
+			delete(c.ClassWords, class)
+			continue
+		}
+		counts, ok := c.Words[class]
+		if !ok {
+			counts = make(map[string]int)
+			c.Words[class] = counts
+		}
+		for _, word := range words {
+			counts[word] += delta
+			if counts[word] <= 0 {
+				delete(counts, word)
+			}
+		}
+		c.ClassWords[class] += delta * len(words)
+	}
+	for _, word := range words {
+		c.Vocabulary[word] += delta * len(classes)
+		if c.Vocabulary[word] <= 0 {
+			delete(c.Vocabulary, word)
+		}
+	}
+}
+
+// Classify returns the n most likely classes of a document made of words, most likely first. Each class is scored
+// one-vs-rest, i.e. against the documents that don't belong to it, so probabilities don't shrink as the number of
+// classes grows and a document can be likely to belong to several classes.
+func (c *Classifier) Classify(words []string, n int) []Score {
+	if c.Documents <= 0 {
+		return nil
+	}
+	allWords := 0
+	for _, count := range c.ClassWords {
+		allWords += count
+	}
+	scores := make([]Score, 0, len(c.Classes))
+	vocabulary := float64(len(c.Vocabulary) + 1)
+	for class, documents := range c.Classes {
+		// log P(class) + sum(log P(word|class)) with Laplace smoothing, likewise for the rest
+		logP := math.Log(float64(documents) / float64(c.Documents))
+		logRest := math.Log(float64(c.Documents-documents) / float64(c.Documents))
+		classWords := float64(c.ClassWords[class])
+		restWords := float64(allWords) - classWords
+		for _, word := range words {
+			count := float64(c.Words[class][word])
+			logP += math.Log((count + 1) / (classWords + vocabulary))
+			logRest += math.Log((float64(c.Vocabulary[word]) - count + 1) / (restWords + vocabulary))
+		}
+		scores = append(scores, Score{class, 1 / (1 + math.Exp(logRest-logP))})
+	}
+	sort.Sort(byProbability(scores))
+	if len(scores) > n {
+		scores = scores[:n]
+	}
+	return scores
+}
+
+type byProbability []Score
+
+func (s byProbability) Len() int {
+	return len(s)
+}
+
+func (s byProbability) Less(i, j int) bool {
+	if s[i].Probability == s[j].Probability {
+		return s[i].Class < s[j].Class
+	}
+	return s[i].Probability > s[j].Probability
+}
+
+func (s byProbability) Swap(i, j int) {
+	s[i], s[j] = s[j], s[i]
+}
+
+// Words splits text into lower case words, ignoring words shorter than 2 letters.
+func Words(text string) []string {
+	var words []string
+	for _, word := range strings.FieldsFunc(text, isSeparator) {
+		if len([]rune(word)) > 1 {
+			words = append(words, strings.ToLower(word))
+		}
+	}
+	return words
+}
+
+// isSeparator returns true for runes that aren't part of words.
+func isSeparator(r rune) bool {
+	return !unicode.IsLetter(r) && !unicode.IsNumber(r) && !unicode.Is(unicode.Mn, r)
+}
@@ -0,0 +1,86 @@
+/*
+This file is part of Tessernote.
+
+Tessernote is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+Tessernote is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with Tessernote.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package bayes
+
+import (
+	"reflect"
+	"strconv"
+	"testing"
+)
+
+func TestWords(t *testing.T) {
+	expected := []string{"café", "au", "lait", "42"}
+	actual := Words("Café au-lait, a 42!")
+	if !reflect.DeepEqual(actual, expected) {
+		t.Fatalf("expected=%q actual=%q", expected, actual)
+	}
+}
+
+func TestClassify(t *testing.T) {
+	c := New()
+	c.Learn(Words("buy milk and eggs"), []string{"groceries"})
+	c.Learn(Words("buy bread and milk"), []string{"groceries"})
+	c.Learn(Words("fix the bug in the parser"), []string{"work"})
+	c.Learn(Words("review the parser change"), []string{"work", "review"})
+
+	scores := c.Classify(Words("milk and bread"), 1)
+	if len(scores) != 1 || scores[0].Class != "groceries" || scores[0].Probability < 0.5 {
+		t.Fatalf("unexpected scores %v", scores)
+	}
+	scores = c.Classify(Words("parser bug"), 3)
+	if len(scores) != 3 || scores[0].Class != "work" || scores[0].Probability < 0.5 {
+		t.Fatalf("unexpected scores %v", scores)
+	}
+	for i := 1; i < len(scores); i++ {
+		if scores[i].Probability > scores[i-1].Probability {
+			t.Fatalf("scores not sorted %v", scores)
+		}
+	}
+}
+
+func TestClassifyManyClasses(t *testing.T) {
+	c := New()
+	for i := 0; i < 100; i++ {
+		c.Learn([]string{"word" + strconv.Itoa(i)}, []string{"class" + strconv.Itoa(i)})
+	}
+	for i := 0; i < 5; i++ {
+		c.Learn([]string{"milk", "eggs", "bread"}, []string{"groceries"})
+	}
+
+	// probabilities are one-vs-rest so they don't shrink with the number of classes
+	scores := c.Classify([]string{"milk", "eggs"}, 1)
+	if len(scores) != 1 || scores[0].Class != "groceries" || scores[0].Probability < 0.5 {
+		t.Fatalf("unexpected scores %v", scores)
+	}
+}
+
+func TestForget(t *testing.T) {
+	c := New()
+	c.Learn(Words("buy milk"), []string{"groceries"})
+	c.Learn(Words("fix bug"), []string{"work", "urgent"})
+	c.Forget(Words("fix bug"), []string{"work", "urgent"})
+
+	expected := New()
+	expected.Learn(Words("buy milk"), []string{"groceries"})
+	if !reflect.DeepEqual(c, expected) {
+		t.Fatalf("expected=%v actual=%v", expected, c)
+	}
+	if scores := New().Classify(Words("buy milk"), 1); scores != nil {
+		t.Fatalf("expected no scores from empty classifier, actual=%v", scores)
+	}
+}
@@ -0,0 +1,167 @@
+/*
+This file is part of Tessernote.
+
+Tessernote is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+Tessernote is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with Tessernote.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package tessernote
+
+import (
+	"appengine"
+	"appengine/datastore"
+	"appengine/memcache"
+	"github.com/oschmid/cachestore"
+	"strconv"
+)
+
+// trackingContext is the context of a transaction that remembers the Keys of the entities it wrote or deleted
+// through cachestore. cachestore updates memcache right away, so if the transaction fails those entries have to
+// be invalidated.
+type trackingContext struct {
+	appengine.Context
+	touched *keySet
+}
+
+// touch remembers keys if c is the context of a transaction.
+func touch(c appengine.Context, keys ...*datastore.Key) {
+	if t, ok := c.(*trackingContext); ok {
+		for _, key := range keys {
+			if key != nil && !key.Incomplete() {
+				t.touched.Add(key)
+			}
+		}
+	}
+}
+
+// runInTransaction runs f in a cross group transaction. If it fails only the memcache entries of the entities f
+// wrote or deleted are invalidated. If it succeeds the version this Notebook was saved with is published, see
+// checkVersion.
+func (notebook *Notebook) runInTransaction(c appengine.Context, f func(tc appengine.Context) error) error {
+	touched := newKeySet(nil)
+	notebook.savedVersion = 0
+	err := datastore.RunInTransaction(c, func(tc appengine.Context) error {
+		notebook.changes, notebook.modelsChanged = nil, false
+		return f(&trackingContext{tc, touched})
+	}, &datastore.TransactionOptions{XG: true})
+	if err != nil {
+		touched.Add(notebook.Key(c))
+		invalidate(c, touched.Keys())
+		return err
+	}
+	if notebook.savedVersion > notebook.Version {
+		notebook.Version = notebook.savedVersion
+	}
+	if notebook.modelsChanged {
+		notebook.forgetModels()
+	}
+	notebook.publishVersion(c)
+	return nil
+}
+
+// runTracked runs f in a transaction that, like runInTransaction, invalidates the memcache entries of the entities
+// f wrote or deleted if it fails. It's for writes that don't save a Notebook, so no version is published.
+func runTracked(c appengine.Context, f func(tc appengine.Context) error) error {
+	touched := newKeySet(nil)
+	err := datastore.RunInTransaction(c, func(tc appengine.Context) error {
+		return f(&trackingContext{tc, touched})
+	}, nil)
+	if err != nil {
+		invalidate(c, touched.Keys())
+	}
+	return err
+}
+
+// invalidate deletes the memcache entries of keys.
+func invalidate(c appengine.Context, keys []*datastore.Key) {
+	cacheKeys := make([]string, len(keys))
+	for i, key := range keys {
+		cacheKeys[i] = key.Encode() // as cached by cachestore
+	}
+	if Debug {
+		c.Debugf("invalidating cached entities: %d", len(cacheKeys))
+	}
+	err := memcache.DeleteMulti(c, cacheKeys)
+	if multi, ok := err.(appengine.MultiError); ok {
+		for _, err := range multi {
+			if err != nil && err != memcache.ErrCacheMiss {
+				c.Errorf("invalidating cached entities: %s", err)
+				return
+			}
+		}
+	} else if err != nil {
+		c.Errorf("invalidating cached entities: %s", err)
+	}
+}
+
+// versionCacheKey returns the memcache key of the latest version of this Notebook.
+func (notebook Notebook) versionCacheKey(c appengine.Context) string {
+	return "version:" + notebook.Key(c).Encode()
+}
+
+// publishVersion stores the version of this Notebook that was just committed in memcache.
+func (notebook *Notebook) publishVersion(c appengine.Context) {
+	err := memcache.Set(c, &memcache.Item{
+		Key:   notebook.versionCacheKey(c),
+		Value: []byte(strconv.FormatInt(notebook.Version, 10)),
+	})
+	if err != nil {
+		c.Warningf("publishing notebook version: %s", err)
+	}
+}
+
+// checkVersion rereads this Notebook from the datastore if the cached copy it was read from is older than the
+// latest committed version.
+func (notebook *Notebook) checkVersion(c appengine.Context) error {
+	item, err := memcache.Get(c, notebook.versionCacheKey(c))
+	if err != nil {
+		return nil // unknown, e.g. evicted
+	}
+	version, err := strconv.ParseInt(string(item.Value), 10, 64)
+	if err != nil || version <= notebook.Version {
+		return nil
+	}
+	if Debug {
+		c.Debugf("stale notebook version: %d < %d", notebook.Version, version)
+	}
+	key := notebook.Key(c)
+	invalidate(c, []*datastore.Key{key})
+	*notebook = Notebook{ID: notebook.ID} // loading appends to slices and the caches are stale too
+	return cachestore.Get(c, key, notebook)
+}
+
+// putEntity is cachestore.Put for entities written in transactions, see runInTransaction.
+func putEntity(c appengine.Context, key *datastore.Key, src interface{}) (*datastore.Key, error) {
+	key, err := cachestore.Put(c, key, src)
+	touch(c, key)
+	return key, err
+}
+
+// putEntities is cachestore.PutMulti for entities written in transactions, see runInTransaction.
+func putEntities(c appengine.Context, keys []*datastore.Key, src interface{}) ([]*datastore.Key, error) {
+	keys, err := cachestore.PutMulti(c, keys, src)
+	touch(c, keys...)
+	return keys, err
+}
+
+// deleteEntity is cachestore.Delete for entities deleted in transactions, see runInTransaction.
+func deleteEntity(c appengine.Context, key *datastore.Key) error {
+	touch(c, key)
+	return cachestore.Delete(c, key)
+}
+
+// deleteEntities is cachestore.DeleteMulti for entities deleted in transactions, see runInTransaction.
+func deleteEntities(c appengine.Context, keys []*datastore.Key) error {
+	touch(c, keys...)
+	return cachestore.DeleteMulti(c, keys)
+}
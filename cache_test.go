@@ -0,0 +1,62 @@
+/*
+This file is part of Tessernote.
+
+Tessernote is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+Tessernote is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with Tessernote.  If not, see <http://www.gnu.org/licenses/>.
+*/
+package tessernote
+
+import (
+	"github.com/oschmid/appenginetesting"
+	"testing"
+)
+
+func TestCheckVersionRereads(t *testing.T) {
+	c, err := appenginetesting.NewContext(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	notebook, _ := newTestNotebook(t, c, "versions", "a #go", "b")
+	_, err = notebook.AddAlias("golang", "go", c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = notebook.Token(FeedToken, c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stale, err := NotebookOf(notebook.ID, c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	token, err := notebook.ResetToken(FeedToken, c)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = stale.load(c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stale.Version != notebook.Version {
+		t.Errorf("expected version=%d actual=%d", notebook.Version, stale.Version)
+	}
+	if len(stale.Tokens) != 1 || stale.Tokens[0] != FeedToken+":"+token {
+		t.Errorf("expected one token, actual=%v", stale.Tokens)
+	}
+	if len(stale.Aliases) != 1 || len(stale.TagKeys) != 1 || len(stale.NoteKeys) != 2 || len(stale.UntaggedNoteKeys) != 1 {
+		t.Errorf("expected 1 alias, 1 tag, 2 notes and 1 untagged note, actual=%d,%d,%d,%d", len(stale.Aliases),
+			len(stale.TagKeys), len(stale.NoteKeys), len(stale.UntaggedNoteKeys))
+	}
+}
@@ -0,0 +1,305 @@
+/*
+This file is part of Tessernote.
+
+Tessernote is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+Tessernote is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with Tessernote.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+// Package client is a Go client for Tessernote's RESTful data API.
+//
+// It does not depend on the App Engine SDK so it can be used from ordinary Go programs.
+package client
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+const (
+	NotesURL          = "/notes/"
+	FsckURL           = "/admin/fsck"
+	ImportURL         = "/import/"
+	ExportURL         = "/export.zip"
+	SiteURL           = "/site.zip"
+	EPUBURL           = "/export.epub"
+	DefaultMaxRetries = 3
+	DefaultBackoff    = 100 * time.Millisecond
+)
+
+// Note mirrors the JSON encoding of tessernote.Note. Keys are datastore.Key.Encode() strings.
+type Note struct {
+	ID           string
+	Body         string
+	Created      time.Time
+	LastModified time.Time
+	TagKeys      []string
+	NotebookKeys []string
+	Pinned       bool // always sent, updates without it keep the old value
+	Archived     bool
+	Duplicates   []string `json:",omitempty"` // IDs of likely duplicates, set by CreateNote
+}
+
+// ImportFailure mirrors the JSON encoding of tessernote.ImportFailure, a note that couldn't be imported.
+type ImportFailure struct {
+	Index int // of the note in the imported notes
+	Error string
+}
+
+// ImportOptions change how Import adds notes.
+type ImportOptions struct {
+	DryRun         bool // only report what would change
+	KeepDuplicates bool // import notes even if the Notebook already has one with the same content
+}
+
+// ImportReport mirrors the JSON encoding of tessernote.ImportReport, what an import changed or would change.
+type ImportReport struct {
+	DryRun     bool
+	Imported   int
+	IDs        []string // of the imported notes in input order, "" for the ones that weren't imported
+	Duplicates []int    // indexes of notes that were skipped because their content was already in the Notebook
+	NewTags    []string
+	Failures   []ImportFailure
+}
+
+// Problem mirrors the JSON encoding of tessernote.Problem, an inconsistency between a Notebook, its Tags and
+// its Notes.
+type Problem struct {
+	Kind string
+	Tag  string `json:",omitempty"`
+	Note string `json:",omitempty"`
+}
+
+// FsckReport mirrors the JSON output of the admin consistency check.
+type FsckReport struct {
+	Repaired bool
+	Problems []Problem
+}
+
+// Client talks to a Tessernote server.
+type Client struct {
+	BaseURL    string        // e.g. https://tessernote.appspot.com
+	Token      string        // the Notebook's API token (see /tokens/api), sent as a bearer token if set
+	HTTPClient *http.Client  // http.DefaultClient if nil
+	MaxRetries int           // retries of idempotent requests for 5xx responses and transport errors
+	Backoff    time.Duration // wait before the first retry, doubled for each retry after that
+}
+
+// New creates a Client for the server at baseURL authorized with token.
+func New(baseURL, token string) *Client {
+	return &Client{
+		BaseURL:    strings.TrimRight(baseURL, "/"),
+		Token:      token,
+		MaxRetries: DefaultMaxRetries,
+		Backoff:    DefaultBackoff,
+	}
+}
+
+// CreateNote adds note to the authorized user's Notebook and returns it with its assigned ID.
+func (client *Client) CreateNote(ctx context.Context, note Note) (Note, error) {
+	var created Note
+	err := client.do(ctx, "POST", NotesURL, note, &created)
+	return created, err
+}
+
+// GetNote returns a note by its ID.
+func (client *Client) GetNote(ctx context.Context, id string) (Note, error) {
+	var note Note
+	err := client.do(ctx, "GET", noteURL(id), nil, &note)
+	return note, err
+}
+
+// ReplaceNote replaces the note with note.ID, creating it if it doesn't exist. The returned Note may have a
+// different ID if note.ID was already assigned in another Notebook.
+func (client *Client) ReplaceNote(ctx context.Context, note Note) (Note, error) {
+	var replaced Note
+	err := client.do(ctx, "PUT", noteURL(note.ID), note, &replaced)
+	return replaced, err
+}
+
+// DeleteNote deletes a note by its ID. It returns true if the note was deleted.
+func (client *Client) DeleteNote(ctx context.Context, id string) (bool, error) {
+	var deleted bool
+	err := client.do(ctx, "DELETE", noteURL(id), nil, &deleted)
+	return deleted, err
+}
+
+// ListNotes returns all notes in the authorized user's Notebook.
+func (client *Client) ListNotes(ctx context.Context) ([]Note, error) {
+	var notes []Note
+	err := client.do(ctx, "GET", NotesURL, nil, &notes)
+	return notes, err
+}
+
+// ReplaceAll replaces every note in the authorized user's Notebook with notes.
+func (client *Client) ReplaceAll(ctx context.Context, notes []Note) ([]Note, error) {
+	var replaced []Note
+	err := client.do(ctx, "PUT", NotesURL, notes, &replaced)
+	return replaced, err
+}
+
+// DeleteAll deletes every note in the authorized user's Notebook. It returns false if the Notebook was empty.
+func (client *Client) DeleteAll(ctx context.Context) (bool, error) {
+	var deleted bool
+	err := client.do(ctx, "DELETE", NotesURL, nil, &deleted)
+	return deleted, err
+}
+
+// Import adds notes to the authorized user's Notebook, keeping their Created and LastModified times. Notes that
+// fail are reported in the returned ImportReport rather than as an error.
+func (client *Client) Import(ctx context.Context, notes []Note, options ImportOptions) (ImportReport, error) {
+	var report ImportReport
+	query := url.Values{}
+	if options.DryRun {
+		query.Set("dryrun", "true")
+	}
+	if options.KeepDuplicates {
+		query.Set("duplicates", "keep")
+	}
+	path := ImportURL
+	if len(query) > 0 {
+		path += "?" + query.Encode()
+	}
+	err := client.do(ctx, "POST", path, notes, &report)
+	return report, err
+}
+
+// Export returns every note in the authorized user's Notebook as a Markdown archive (a zip file), see package
+// convert. It can be imported again with convert.ReadMarkdownArchive and Import.
+func (client *Client) Export(ctx context.Context) ([]byte, error) {
+	return client.retry(ctx, "GET", ExportURL, nil)
+}
+
+// EPUB returns the notes matching tags (e.g. "go,status:done", every note if empty) as an EPUB with a table of
+// contents grouped by tag. Notes are sorted by order, one of the sort codes of the web pages (e.g. "lm" for last
+// modified first), alphabetically if empty.
+func (client *Client) EPUB(ctx context.Context, tags, order string) ([]byte, error) {
+	query := url.Values{}
+	if tags != "" {
+		query.Set("tags", tags)
+	}
+	if order != "" {
+		query.Set("sort", order)
+	}
+	path := EPUBURL
+	if len(query) > 0 {
+		path += "?" + query.Encode()
+	}
+	return client.retry(ctx, "GET", path, nil)
+}
+
+// Site returns a static website of the notes with tag as a zip file: a page for every note, index pages for the
+// hashtags in them and an Atom feed. Notes without tag are left out.
+func (client *Client) Site(ctx context.Context, tag string) ([]byte, error) {
+	return client.retry(ctx, "GET", SiteURL+"?tag="+url.QueryEscape(tag), nil)
+}
+
+// Check checks the Notebook of the user with id (the authorized user's if empty) for inconsistencies between its
+// Notes and Tags and repairs them if repair is true. Only administrators may check other users' Notebooks.
+func (client *Client) Check(ctx context.Context, id string, repair bool) (FsckReport, error) {
+	var report FsckReport
+	path := FsckURL
+	if id != "" {
+		path += "?user=" + url.QueryEscape(id)
+	}
+	method := "GET"
+	if repair {
+		method = "POST"
+	}
+	err := client.do(ctx, method, path, nil, &report)
+	return report, err
+}
+
+// noteURL returns the path of a single note.
+func noteURL(id string) string {
+	return NotesURL + url.PathEscape(id)
+}
+
+// do sends a request with in as its JSON body and decodes the JSON reply into out.
+func (client *Client) do(ctx context.Context, method, path string, in, out interface{}) error {
+	var body []byte
+	if in != nil {
+		var err error
+		body, err = json.Marshal(in)
+		if err != nil {
+			return err
+		}
+	}
+	reply, err := client.retry(ctx, method, path, body)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(reply, out)
+}
+
+// retry sends a request and returns the reply body. GET, PUT and DELETE requests that fail with a 5xx status or a
+// transport error are retried up to client.MaxRetries times, other requests are sent once.
+func (client *Client) retry(ctx context.Context, method, path string, body []byte) ([]byte, error) {
+	backoff := client.Backoff
+	for retry := 0; ; retry++ {
+		reply, err := client.send(ctx, method, path, body)
+		if err == nil {
+			return reply, nil
+		}
+		if !retryable(method, err) || retry >= client.MaxRetries || ctx.Err() != nil {
+			return nil, err
+		}
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(backoff):
+		}
+		backoff *= 2
+	}
+}
+
+// send makes a single request and returns the reply body or an *Error for non-2xx responses.
+func (client *Client) send(ctx context.Context, method, path string, body []byte) ([]byte, error) {
+	var reader io.Reader
+	if body != nil {
+		reader = bytes.NewReader(body)
+	}
+	r, err := http.NewRequestWithContext(ctx, method, client.BaseURL+path, reader)
+	if err != nil {
+		return nil, err
+	}
+	if body != nil {
+		r.Header.Set("Content-Type", "application/json")
+	}
+	if client.Token != "" {
+		r.Header.Set("Authorization", "Bearer "+client.Token)
+	}
+	httpClient := client.HTTPClient
+	if httpClient == nil {
+		httpClient = http.DefaultClient
+	}
+	resp, err := httpClient.Do(r)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	reply, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return nil, newError(resp.StatusCode, reply)
+	}
+	return reply, nil
+}
@@ -0,0 +1,226 @@
+/*
+This file is part of Tessernote.
+
+Tessernote is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+Tessernote is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with Tessernote.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// newTestClient returns a Client for a test server that handles requests with handler.
+func newTestClient(handler http.HandlerFunc) (*Client, *httptest.Server) {
+	server := httptest.NewServer(handler)
+	client := New(server.URL, "secret")
+	client.Backoff = time.Millisecond
+	return client, server
+}
+
+func TestCreateNote(t *testing.T) {
+	client, server := newTestClient(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "POST" || r.URL.Path != NotesURL {
+			t.Errorf("unexpected request %s %s", r.Method, r.URL.Path)
+		}
+		if r.Header.Get("Authorization") != "Bearer secret" {
+			t.Errorf("expected=%s actual=%s", "Bearer secret", r.Header.Get("Authorization"))
+		}
+		var note Note
+		body, _ := ioutil.ReadAll(r.Body)
+		if err := json.Unmarshal(body, &note); err != nil {
+			t.Error(err)
+		}
+		note.ID = "abc"
+		reply, _ := json.Marshal(note)
+		w.Write(reply)
+	})
+	defer server.Close()
+
+	note, err := client.CreateNote(context.Background(), Note{Body: "body #tag"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if note.ID != "abc" || note.Body != "body #tag" {
+		t.Fatalf("unexpected note %#v", note)
+	}
+}
+
+func TestGetNoteNotFound(t *testing.T) {
+	client, server := newTestClient(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != NotesURL+"missing" {
+			t.Errorf("expected=%s actual=%s", NotesURL+"missing", r.URL.Path)
+		}
+		http.NotFound(w, r)
+	})
+	defer server.Close()
+
+	_, err := client.GetNote(context.Background(), "missing")
+	if !errors.Is(err, ErrNotFound) {
+		t.Fatalf("expected=%s actual=%v", ErrNotFound, err)
+	}
+	var e *Error
+	if !errors.As(err, &e) || e.StatusCode != http.StatusNotFound {
+		t.Fatalf("expected *Error with status %d, actual %#v", http.StatusNotFound, err)
+	}
+}
+
+func TestRetryOnServerError(t *testing.T) {
+	attempts := 0
+	client, server := newTestClient(func(w http.ResponseWriter, r *http.Request) {
+		attempts++
+		if attempts < 3 {
+			http.Error(w, "try again", http.StatusServiceUnavailable)
+			return
+		}
+		w.Write([]byte("true"))
+	})
+	defer server.Close()
+
+	deleted, err := client.DeleteNote(context.Background(), "abc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !deleted || attempts != 3 {
+		t.Fatalf("expected=%t,%d actual=%t,%d", true, 3, deleted, attempts)
+	}
+}
+
+func TestRetriesExhausted(t *testing.T) {
+	attempts := 0
+	client, server := newTestClient(func(w http.ResponseWriter, r *http.Request) {
+		attempts++
+		http.Error(w, "broken", http.StatusInternalServerError)
+	})
+	defer server.Close()
+
+	_, err := client.ListNotes(context.Background())
+	if !errors.Is(err, ErrServer) {
+		t.Fatalf("expected=%s actual=%v", ErrServer, err)
+	}
+	if attempts != client.MaxRetries+1 {
+		t.Fatalf("expected=%d actual=%d", client.MaxRetries+1, attempts)
+	}
+}
+
+func TestNoRetryOnClientError(t *testing.T) {
+	attempts := 0
+	client, server := newTestClient(func(w http.ResponseWriter, r *http.Request) {
+		attempts++
+		http.Error(w, "mismatched note.ID and URL", http.StatusBadRequest)
+	})
+	defer server.Close()
+
+	_, err := client.ReplaceNote(context.Background(), Note{ID: "abc"})
+	if !errors.Is(err, ErrBadRequest) {
+		t.Fatalf("expected=%s actual=%v", ErrBadRequest, err)
+	}
+	if attempts != 1 {
+		t.Fatalf("expected=%d actual=%d", 1, attempts)
+	}
+}
+
+func TestNoRetryOnPost(t *testing.T) {
+	attempts := 0
+	client, server := newTestClient(func(w http.ResponseWriter, r *http.Request) {
+		attempts++
+		http.Error(w, "timed out after committing", http.StatusServiceUnavailable)
+	})
+	defer server.Close()
+
+	_, err := client.CreateNote(context.Background(), Note{Body: "body"})
+	if !errors.Is(err, ErrServer) {
+		t.Fatalf("expected=%s actual=%v", ErrServer, err)
+	}
+	if attempts != 1 {
+		t.Fatalf("expected=%d actual=%d", 1, attempts)
+	}
+}
+
+func TestContextCancelled(t *testing.T) {
+	client, server := newTestClient(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "try again", http.StatusServiceUnavailable)
+	})
+	defer server.Close()
+	client.Backoff = time.Hour
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	_, err := client.DeleteAll(ctx)
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expected=%s actual=%v", context.DeadlineExceeded, err)
+	}
+}
+
+func TestReplaceAll(t *testing.T) {
+	client, server := newTestClient(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "PUT" || r.URL.Path != NotesURL {
+			t.Errorf("unexpected request %s %s", r.Method, r.URL.Path)
+		}
+		body, _ := ioutil.ReadAll(r.Body)
+		w.Write(body)
+	})
+	defer server.Close()
+
+	notes, err := client.ReplaceAll(context.Background(), []Note{{Body: "a"}, {Body: "b"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(notes) != 2 {
+		t.Fatalf("expected=%d actual=%d", 2, len(notes))
+	}
+}
+
+func TestCheck(t *testing.T) {
+	client, server := newTestClient(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "POST" || r.URL.Path != FsckURL || r.FormValue("user") != "42" {
+			t.Errorf("unexpected request %s %s", r.Method, r.URL)
+		}
+		w.Write([]byte(`{"Repaired":true,"Problems":[{"Kind":"untagged","Note":"abc"}]}`))
+	})
+	defer server.Close()
+
+	report, err := client.Check(context.Background(), "42", true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !report.Repaired || len(report.Problems) != 1 || report.Problems[0] != (Problem{Kind: "untagged", Note: "abc"}) {
+		t.Fatalf("unexpected report %#v", report)
+	}
+}
+
+func TestImportDryRun(t *testing.T) {
+	client, server := newTestClient(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "POST" || r.URL.Path != ImportURL || r.FormValue("dryrun") != "true" || r.FormValue("duplicates") != "" {
+			t.Errorf("unexpected request %s %s", r.Method, r.URL)
+		}
+		w.Write([]byte(`{"DryRun":true,"Imported":1,"IDs":["",""],"Duplicates":[0],"NewTags":["go"],"Failures":[]}`))
+	})
+	defer server.Close()
+
+	report, err := client.Import(context.Background(), []Note{{Body: "a"}, {Body: "b #go"}}, ImportOptions{DryRun: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !report.DryRun || report.Imported != 1 || len(report.Duplicates) != 1 || report.NewTags[0] != "go" {
+		t.Fatalf("unexpected report %#v", report)
+	}
+}
@@ -0,0 +1,84 @@
+/*
+This file is part of Tessernote.
+
+Tessernote is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+Tessernote is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with Tessernote.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package client
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+)
+
+var (
+	ErrBadRequest   = errors.New("tessernote: bad request")
+	ErrUnauthorized = errors.New("tessernote: unauthorized")
+	ErrForbidden    = errors.New("tessernote: forbidden")
+	ErrNotFound     = errors.New("tessernote: not found")
+	ErrConflict     = errors.New("tessernote: conflict")
+	ErrServer       = errors.New("tessernote: server error")
+)
+
+// Error is returned for responses with a non-2xx status. Use errors.Is with ErrBadRequest, ErrNotFound, etc.
+// to check what kind of error it is.
+type Error struct {
+	StatusCode int
+	Message    string // the server's error message
+}
+
+func (err *Error) Error() string {
+	if err.Message == "" {
+		return "tessernote: " + http.StatusText(err.StatusCode)
+	}
+	return "tessernote: " + http.StatusText(err.StatusCode) + ": " + err.Message
+}
+
+// Unwrap returns the sentinel error for err.StatusCode, or nil if there isn't one.
+func (err *Error) Unwrap() error {
+	switch {
+	case err.StatusCode == http.StatusBadRequest:
+		return ErrBadRequest
+	case err.StatusCode == http.StatusUnauthorized:
+		return ErrUnauthorized
+	case err.StatusCode == http.StatusForbidden:
+		return ErrForbidden
+	case err.StatusCode == http.StatusNotFound:
+		return ErrNotFound
+	case err.StatusCode == http.StatusConflict:
+		return ErrConflict
+	case err.StatusCode >= 500:
+		return ErrServer
+	}
+	return nil
+}
+
+// newError creates an Error from a response status and body.
+func newError(status int, body []byte) *Error {
+	return &Error{StatusCode: status, Message: strings.TrimSpace(string(body))}
+}
+
+// retryable returns true if a request with method that failed with err may succeed if sent again. Only idempotent
+// methods are retried: a POST that timed out may have succeeded, and sending it again would e.g. create a note
+// twice.
+func retryable(method string, err error) bool {
+	if method != "GET" && method != "PUT" && method != "DELETE" {
+		return false
+	}
+	if e, ok := err.(*Error); ok {
+		return e.StatusCode >= 500
+	}
+	return true
+}
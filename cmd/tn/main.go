@@ -0,0 +1,497 @@
+//go:build !appengine
+// +build !appengine
+
+/*
+This file is part of Tessernote.
+
+Tessernote is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+Tessernote is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with Tessernote.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+// Command tn is a command line tool for a Tessernote server.
+//
+// Usage:
+//
+//	tn [-url URL] [-token TOKEN] fsck [-user ID] [-repair]
+//	tn [-url URL] [-token TOKEN] backup DIR
+//	tn [-url URL] [-token TOKEN] export [-o FILE] [-format zip|epub] [-tags TAGS] [-sort ORDER]
+//	tn [-url URL] [-token TOKEN] import [-format enex|simplenote|keep|fetchnotes|markdown] [-text] [-batch N]
+//		[-dry-run] [-keep-duplicates] FILE|DIR...
+//	tn [-url URL] [-token TOKEN] site -tag TAG DIR
+//	tn [-url URL] [-token TOKEN] sync [-interval D] [-once] DIR
+//
+// The token is the notebook's API token (see /tokens/api) and defaults to the TESSERNOTE_TOKEN environment
+// variable.
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"github.com/oschmid/tessernote/client"
+	"github.com/oschmid/tessernote/convert"
+	"github.com/oschmid/tessernote/gitstore"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// siteListFile lists the files written by the last run of site in its directory.
+const siteListFile = ".tessernote-site"
+
+var (
+	baseURL = flag.String("url", "https://tessernote.appspot.com", "Tessernote server")
+	token   = flag.String("token", os.Getenv("TESSERNOTE_TOKEN"), "API token (see /tokens/api)")
+)
+
+// commands maps subcommand names to their implementations.
+var commands = map[string]func(c *client.Client, args []string) error{
+	"backup": backup,
+	"export": export,
+	"fsck":   fsck,
+	"import": importNotes,
+	"site":   site,
+	"sync":   syncDir,
+}
+
+func main() {
+	flag.Usage = usage
+	flag.Parse()
+	if flag.NArg() == 0 {
+		usage()
+	}
+	command, ok := commands[flag.Arg(0)]
+	if !ok {
+		usage()
+	}
+	err := command(client.New(*baseURL, *token), flag.Args()[1:])
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "tn:", err)
+		os.Exit(1)
+	}
+}
+
+func usage() {
+	fmt.Fprintln(os.Stderr, "usage: tn [-url URL] [-token TOKEN] command [arguments]")
+	fmt.Fprintln(os.Stderr, "commands:")
+	fmt.Fprintln(os.Stderr, "\tbackup DIR\tcommit every changed note to a git repository")
+	fmt.Fprintln(os.Stderr, "\texport [-o FILE] [-format zip|epub]\tsave every note as Markdown in a zip file, or as an e-book")
+	fmt.Fprintln(os.Stderr, "\tfsck [-user ID] [-repair]\tcheck a notebook's notes and tags for inconsistencies")
+	fmt.Fprintln(os.Stderr, "\timport [-format FORMAT] [-dry-run] FILE|DIR...\timport notes exported from another app")
+	fmt.Fprintln(os.Stderr, "\tsite -tag TAG DIR\tpublish the notes with a tag as a static website")
+	fmt.Fprintln(os.Stderr, "\tsync [-interval D] [-once] DIR\tkeep a directory of Markdown files in sync with the notebook")
+	flag.PrintDefaults()
+	os.Exit(2)
+}
+
+// backup commits the notebook to a bare git repository (see package gitstore) so its history is kept. Notes
+// deleted on the server are deleted in the repository.
+func backup(c *client.Client, args []string) error {
+	if len(args) != 1 {
+		return errors.New("usage: tn backup DIR")
+	}
+	store, err := gitstore.Open(args[0])
+	if err != nil {
+		return err
+	}
+	notes, err := c.ListNotes(context.Background())
+	if err != nil {
+		return err
+	}
+	stored := store.Notes()
+	changed := 0
+	err = store.Update("Backup "+time.Now().UTC().Format(time.RFC3339), func(tx *gitstore.Tx) error {
+		ids := make(map[string]bool, len(notes))
+		for _, note := range notes {
+			ids[note.ID] = true
+			old, ok := tx.Get(note.ID)
+			if ok && old.Body == note.Body && old.LastModified.Equal(note.LastModified) &&
+				old.Pinned == note.Pinned && old.Archived == note.Archived {
+				continue
+			}
+			changed++
+			err := tx.Write(convert.Note{
+				ID:           note.ID,
+				Body:         note.Body,
+				Created:      note.Created,
+				LastModified: note.LastModified,
+				Pinned:       note.Pinned,
+				Archived:     note.Archived,
+			})
+			if err != nil {
+				return err
+			}
+		}
+		for _, note := range stored {
+			if !ids[note.ID] && tx.Delete(note.ID) {
+				changed++
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	fmt.Printf("backed up %d notes, %d changed\n", len(notes), changed)
+	return nil
+}
+
+// export saves the notebook as a Markdown archive, see convert.WriteMarkdownArchive. Import it again with
+// import -format markdown. With -format epub it saves the notes matching -tags as an e-book instead.
+func export(c *client.Client, args []string) error {
+	flags := flag.NewFlagSet("export", flag.ExitOnError)
+	output := flags.String("o", "", "file to write (default tessernote.zip or tessernote.epub)")
+	format := flags.String("format", "zip", "zip (Markdown archive) or epub")
+	tags := flags.String("tags", "", "comma separated tags of the notes in an epub (default all notes)")
+	order := flags.String("sort", "", "order of the notes in an epub: aa, ad, lm, fm, lc or fc (default aa)")
+	flags.Parse(args)
+	var archive []byte
+	var err error
+	switch *format {
+	case "zip":
+		archive, err = c.Export(context.Background())
+	case "epub":
+		archive, err = c.EPUB(context.Background(), *tags, *order)
+	default:
+		return fmt.Errorf("unknown format %q", *format)
+	}
+	if err != nil {
+		return err
+	}
+	if *output == "" {
+		*output = "tessernote." + *format
+	}
+	return ioutil.WriteFile(*output, archive, 0644)
+}
+
+// site writes a static website of the notes with a tag to a directory. The files it writes are listed in
+// siteListFile, files listed there by an earlier run that aren't part of the site anymore (e.g. pages of notes that
+// lost the tag) are removed. Other files in the directory are left alone.
+func site(c *client.Client, args []string) error {
+	flags := flag.NewFlagSet("site", flag.ExitOnError)
+	tag := flags.String("tag", "", "tag of the notes to publish")
+	flags.Parse(args)
+	if *tag == "" || flags.NArg() != 1 {
+		return errors.New("usage: tn site -tag TAG DIR")
+	}
+	archive, err := c.Site(context.Background(), *tag)
+	if err != nil {
+		return err
+	}
+	reader, err := zip.NewReader(bytes.NewReader(archive), int64(len(archive)))
+	if err != nil {
+		return err
+	}
+	dir := flags.Arg(0)
+	list := filepath.Join(dir, siteListFile)
+	previous, err := ioutil.ReadFile(list)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	var names []string
+	written := make(map[string]bool)
+	for _, file := range reader.File {
+		path, err := siteFile(dir, file.Name)
+		if err != nil {
+			return err
+		}
+		err = os.MkdirAll(filepath.Dir(path), 0755)
+		if err != nil {
+			return err
+		}
+		r, err := file.Open()
+		if err != nil {
+			return err
+		}
+		data, err := ioutil.ReadAll(r)
+		r.Close()
+		if err != nil {
+			return err
+		}
+		err = ioutil.WriteFile(path, data, 0644)
+		if err != nil {
+			return err
+		}
+		names = append(names, file.Name)
+		written[file.Name] = true
+	}
+	removed := 0
+	for _, name := range strings.Split(string(previous), "\n") {
+		if name == "" || written[name] {
+			continue
+		}
+		path, err := siteFile(dir, name)
+		if err != nil {
+			return err
+		}
+		err = os.Remove(path)
+		if err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		removed++
+	}
+	err = ioutil.WriteFile(list, []byte(strings.Join(names, "\n")+"\n"), 0644)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("wrote %d files to %s, removed %d\n", len(names), dir, removed)
+	return nil
+}
+
+// siteFile returns the path of a file of a site in dir. name must be a relative slash-separated path inside dir.
+func siteFile(dir, name string) (string, error) {
+	path := filepath.FromSlash(name)
+	if filepath.IsAbs(path) || strings.HasPrefix(filepath.Clean(path), "..") {
+		return "", fmt.Errorf("invalid file name in site: %s", name)
+	}
+	return filepath.Join(dir, path), nil
+}
+
+// fsck prints the problems found in a Notebook, one per line, and repairs them if -repair is given.
+func fsck(c *client.Client, args []string) error {
+	flags := flag.NewFlagSet("fsck", flag.ExitOnError)
+	id := flags.String("user", "", "user ID of the notebook to check (default: the authorized user's)")
+	repair := flags.Bool("repair", false, "repair the problems found")
+	flags.Parse(args)
+	report, err := c.Check(context.Background(), *id, *repair)
+	if err != nil {
+		return err
+	}
+	for _, problem := range report.Problems {
+		fmt.Printf("%s\ttag=%s\tnote=%s\n", problem.Kind, problem.Tag, problem.Note)
+	}
+	if report.Repaired {
+		fmt.Printf("repaired %d problems\n", len(report.Problems))
+	} else if len(report.Problems) > 0 {
+		fmt.Printf("%d problems, run with -repair to fix them\n", len(report.Problems))
+	}
+	return nil
+}
+
+// noteReader reads the notes of an export one at a time, see convert.ENEXReader.
+type noteReader interface {
+	Next() (convert.Note, error)
+}
+
+// sliceReader is a noteReader for formats that are read all at once.
+type sliceReader struct {
+	notes []convert.Note
+}
+
+func (reader *sliceReader) Next() (convert.Note, error) {
+	if len(reader.notes) == 0 {
+		return convert.Note{}, io.EOF
+	}
+	note := reader.notes[0]
+	reader.notes = reader.notes[1:]
+	return note, nil
+}
+
+// readers maps import formats to functions that create a noteReader for a file.
+var readers = map[string]func(file *os.File, markdown bool) (noteReader, error){
+	"enex": func(file *os.File, markdown bool) (noteReader, error) {
+		reader := convert.NewENEXReader(file)
+		reader.Markdown = markdown
+		return reader, nil
+	},
+	"simplenote": readAll(convert.ReadSimplenote),
+	"keep": func(file *os.File, markdown bool) (noteReader, error) {
+		if strings.HasSuffix(file.Name(), ".html") {
+			return readAll(convert.ReadKeepHTML)(file, markdown)
+		}
+		return readAll(convert.ReadKeepJSON)(file, markdown)
+	},
+	"fetchnotes": readAll(convert.ReadFetchnotes),
+	"markdown": func(file *os.File, markdown bool) (noteReader, error) {
+		if strings.HasSuffix(file.Name(), ".md") {
+			return readAll(convert.ReadMarkdown)(file, markdown)
+		}
+		info, err := file.Stat()
+		if err != nil {
+			return nil, err
+		}
+		notes, err := convert.ReadMarkdownArchive(file, info.Size())
+		return &sliceReader{notes}, err
+	},
+}
+
+// readAll returns a function that creates a noteReader with the notes read by read.
+func readAll(read func(r io.Reader) ([]convert.Note, error)) func(*os.File, bool) (noteReader, error) {
+	return func(file *os.File, markdown bool) (noteReader, error) {
+		notes, err := read(file)
+		return &sliceReader{notes}, err
+	}
+}
+
+// importNotes converts the notes in export files and imports them in batches, printing the ones that failed.
+func importNotes(c *client.Client, args []string) error {
+	flags := flag.NewFlagSet("import", flag.ExitOnError)
+	format := flags.String("format", "enex", "format of the files: enex, simplenote, keep, fetchnotes or markdown")
+	text := flags.Bool("text", false, "convert rich text to plain text instead of Markdown")
+	batchSize := flags.Int("batch", 100, "notes sent per request")
+	dryRun := flags.Bool("dry-run", false, "only report what would change")
+	keepDuplicates := flags.Bool("keep-duplicates", false, "import notes whose content is already in the notebook")
+	flags.Parse(args)
+	newReader, ok := readers[*format]
+	if !ok {
+		return fmt.Errorf("unknown format %q", *format)
+	}
+	names, err := exportFiles(flags.Args(), *format)
+	if err != nil {
+		return err
+	}
+	options := client.ImportOptions{DryRun: *dryRun, KeepDuplicates: *keepDuplicates}
+	imported, duplicates, failed := 0, 0, 0
+	var newTags []string
+	for _, name := range names {
+		file, err := os.Open(name)
+		if err != nil {
+			return err
+		}
+		reader, err := newReader(file, !*text)
+		if err != nil {
+			file.Close()
+			return fmt.Errorf("%s: %s", name, err)
+		}
+		var batch []convert.Note
+		send := func() error {
+			if len(batch) == 0 {
+				return nil
+			}
+			notes := make([]client.Note, len(batch))
+			for i, note := range batch {
+				notes[i] = client.Note{
+					ID:           note.ID,
+					Body:         note.Body,
+					Created:      note.Created,
+					LastModified: note.LastModified,
+					Pinned:       note.Pinned,
+					Archived:     note.Archived,
+				}
+			}
+			report, err := c.Import(context.Background(), notes, options)
+			if err != nil {
+				return err
+			}
+			for _, failure := range report.Failures {
+				fmt.Fprintf(os.Stderr, "%s: %q: %s\n", name, batch[failure.Index].Title, failure.Error)
+			}
+			if *dryRun {
+				for _, i := range report.Duplicates {
+					fmt.Printf("%s: %q: duplicate\n", name, batch[i].Title)
+				}
+			}
+			imported += report.Imported
+			duplicates += len(report.Duplicates)
+			failed += len(report.Failures)
+			for _, tag := range report.NewTags {
+				if !containsString(newTags, tag) {
+					newTags = append(newTags, tag)
+				}
+			}
+			batch = batch[:0]
+			return nil
+		}
+		for {
+			note, err := reader.Next()
+			if err == io.EOF {
+				break
+			}
+			var noteErr *convert.NoteError
+			if errors.As(err, &noteErr) {
+				fmt.Fprintf(os.Stderr, "%s: %s\n", name, err)
+				failed++
+				continue
+			} else if err != nil {
+				file.Close()
+				return fmt.Errorf("%s: %s", name, err)
+			}
+			batch = append(batch, note)
+			if len(batch) == *batchSize {
+				err = send()
+				if err != nil {
+					file.Close()
+					return err
+				}
+			}
+		}
+		err = send()
+		file.Close()
+		if err != nil {
+			return err
+		}
+	}
+	if *dryRun {
+		fmt.Printf("would import %d notes, skip %d duplicates, %d failed\n", imported, duplicates, failed)
+		if len(newTags) > 0 {
+			fmt.Printf("would create tags: %s\n", strings.Join(newTags, " "))
+		}
+	} else {
+		fmt.Printf("imported %d notes, skipped %d duplicates, %d failed\n", imported, duplicates, failed)
+	}
+	return nil
+}
+
+// exportFiles returns the files named in args, replacing directories with the files in them that can be in an
+// export of format. Google Keep exports have a JSON and an HTML file per note, only the JSON file is used if both
+// exist.
+func exportFiles(args []string, format string) ([]string, error) {
+	var names []string
+	for _, arg := range args {
+		info, err := os.Stat(arg)
+		if err != nil {
+			return nil, err
+		}
+		if !info.IsDir() {
+			names = append(names, arg)
+			continue
+		}
+		infos, err := ioutil.ReadDir(arg)
+		if err != nil {
+			return nil, err
+		}
+		have := make(map[string]bool)
+		for _, info := range infos {
+			have[info.Name()] = true
+		}
+		for _, info := range infos {
+			name := info.Name()
+			ext := filepath.Ext(name)
+			switch {
+			case info.IsDir():
+			case format == "enex" && ext == ".enex",
+				format == "markdown" && (ext == ".md" || ext == ".zip"),
+				format != "enex" && format != "markdown" && ext == ".json",
+				format == "keep" && ext == ".html" && !have[strings.TrimSuffix(name, ext)+".json"]:
+				names = append(names, filepath.Join(arg, name))
+			}
+		}
+	}
+	return names, nil
+}
+
+// containsString returns true if list contains s.
+func containsString(list []string, s string) bool {
+	for _, t := range list {
+		if t == s {
+			return true
+		}
+	}
+	return false
+}
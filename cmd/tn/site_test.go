@@ -0,0 +1,115 @@
+//go:build !appengine
+// +build !appengine
+
+/*
+This file is part of Tessernote.
+
+Tessernote is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+Tessernote is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with Tessernote.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"github.com/oschmid/tessernote/client"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// siteArchive returns a zip file of files by name.
+func siteArchive(t *testing.T, files ...string) []byte {
+	var b bytes.Buffer
+	archive := zip.NewWriter(&b)
+	for _, name := range files {
+		file, err := archive.Create(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		file.Write([]byte(name))
+	}
+	err := archive.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return b.Bytes()
+}
+
+func TestSiteRemovesOnlyItsOwnFiles(t *testing.T) {
+	dir, err := ioutil.TempDir("", "tn-site")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	var archive []byte
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write(archive)
+	}))
+	defer server.Close()
+	c := client.New(server.URL, "secret")
+
+	// files that were there before the site
+	for _, name := range []string{"notes/mine.html", "CNAME"} {
+		path := filepath.Join(dir, filepath.FromSlash(name))
+		os.MkdirAll(filepath.Dir(path), 0755)
+		err = ioutil.WriteFile(path, []byte("mine"), 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	archive = siteArchive(t, "index.html", "notes/a.html", "notes/b.html", "tags/go.html")
+	err = site(c, []string{"-tag", "blog", dir})
+	if err != nil {
+		t.Fatal(err)
+	}
+	archive = siteArchive(t, "index.html", "notes/a.html")
+	err = site(c, []string{"-tag", "blog", dir})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for name, exists := range map[string]bool{
+		"index.html":      true,
+		"notes/a.html":    true,
+		"notes/b.html":    false,
+		"tags/go.html":    false,
+		"notes/mine.html": true,
+		"CNAME":           true,
+	} {
+		_, err := os.Stat(filepath.Join(dir, filepath.FromSlash(name)))
+		if exists && err != nil {
+			t.Errorf("%s was removed: %s", name, err)
+		} else if !exists && !os.IsNotExist(err) {
+			t.Errorf("%s wasn't removed", name)
+		}
+	}
+}
+
+func TestSiteRejectsPathsOutsideDir(t *testing.T) {
+	for _, name := range []string{"../index.html", "/etc/passwd", "notes/../../x.html"} {
+		_, err := siteFile("site", name)
+		if err == nil {
+			t.Errorf("siteFile(%q) = nil error", name)
+		}
+	}
+	path, err := siteFile("site", "notes/a.html")
+	if err != nil || path != filepath.Join("site", "notes", "a.html") {
+		t.Errorf("siteFile(notes/a.html) = %q, %v", path, err)
+	}
+}
@@ -0,0 +1,446 @@
+//go:build !appengine
+// +build !appengine
+
+/*
+This file is part of Tessernote.
+
+Tessernote is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+Tessernote is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with Tessernote.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package main
+
+import (
+	"context"
+	"crypto/sha1"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"github.com/oschmid/tessernote/client"
+	"github.com/oschmid/tessernote/convert"
+	"io/ioutil"
+	"log"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	syncStateFile = ".tessernote-sync.json"
+	syncTrashDir  = ".trash"
+)
+
+// syncState is what a directory and the notebook looked like after the last sync.
+type syncState struct {
+	Notes map[string]*syncedNote // by ID
+}
+
+// syncedNote is the state of a note after the last sync.
+type syncedNote struct {
+	File         string    // relative to the synced directory
+	Hash         string    // of the file's content
+	LastModified time.Time // of the note on the server
+}
+
+// localNote is a Markdown file in the synced directory.
+type localNote struct {
+	File string
+	Hash string
+	Note convert.Note
+}
+
+// syncer mirrors a notebook into a directory of Markdown files, see syncDir.
+type syncer struct {
+	client *client.Client
+	dir    string
+	state  syncState
+	ctx    context.Context
+}
+
+// syncDir mirrors the notebook into a directory of Markdown files with front matter (see convert.MarshalMarkdown),
+// polling both for changes until interrupted. A note changed on both sides is kept twice: the server's version
+// in the note's file and the local version in a new file that is uploaded as a new note. Deleted files delete
+// their notes and notes deleted on the server are moved to the directory's .trash folder. Files can be renamed
+// and moved, notes are tracked by the ID in their front matter.
+func syncDir(c *client.Client, args []string) error {
+	flags := flag.NewFlagSet("sync", flag.ExitOnError)
+	interval := flags.Duration("interval", 30*time.Second, "time between syncs")
+	once := flags.Bool("once", false, "sync once and exit")
+	flags.Parse(args)
+	if flags.NArg() != 1 {
+		return errors.New("usage: tn sync [-interval D] [-once] DIR")
+	}
+	s := &syncer{client: c, dir: flags.Arg(0), ctx: context.Background()}
+	err := os.MkdirAll(s.dir, 0755)
+	if err != nil {
+		return err
+	}
+	err = s.loadState()
+	if err != nil {
+		return err
+	}
+	interrupt := make(chan os.Signal, 1)
+	signal.Notify(interrupt, os.Interrupt)
+	for {
+		err = s.sync()
+		if err != nil {
+			if *once {
+				return err
+			}
+			log.Printf("sync: %s", err)
+		}
+		if *once {
+			return nil
+		}
+		select {
+		case <-interrupt:
+			return nil
+		case <-time.After(*interval):
+		}
+	}
+}
+
+// loadState reads the state of the last sync, if there was one.
+func (s *syncer) loadState() error {
+	s.state.Notes = make(map[string]*syncedNote)
+	data, err := ioutil.ReadFile(filepath.Join(s.dir, syncStateFile))
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, &s.state)
+}
+
+// saveState writes the state of the last sync.
+func (s *syncer) saveState() error {
+	data, err := json.MarshalIndent(s.state, "", "\t")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(filepath.Join(s.dir, syncStateFile), data, 0644)
+}
+
+// sync syncs once. Changes that fail are logged and tried again next time.
+func (s *syncer) sync() error {
+	remote, err := s.client.ListNotes(s.ctx)
+	if err != nil {
+		return err
+	}
+	remoteNotes := make(map[string]client.Note, len(remote))
+	for _, note := range remote {
+		remoteNotes[note.ID] = note
+	}
+	localNotes, newNotes, unreadable, err := s.scan()
+	if err != nil {
+		return err
+	}
+	skipped := make(map[string]bool) // IDs of notes with unreadable files, left alone until the files are fixed
+	for _, local := range unreadable {
+		if local.Note.ID != "" {
+			skipped[local.Note.ID] = true
+		}
+		for id, synced := range s.state.Notes {
+			if synced.File == local.File {
+				skipped[id] = true
+			}
+		}
+	}
+
+	for id, local := range localNotes {
+		if _, ok := s.state.Notes[id]; ok {
+			continue
+		}
+		if _, ok := remoteNotes[id]; ok {
+			s.state.Notes[id] = &syncedNote{File: local.File} // e.g. an export, compare it like a conflict
+		} else {
+			newNotes = append(newNotes, local)
+		}
+	}
+	ids := make([]string, 0, len(s.state.Notes))
+	known := make(map[string]bool) // IDs of notes that were synced before, even if they're deleted now
+	for id := range s.state.Notes {
+		ids = append(ids, id)
+		known[id] = true
+	}
+	for _, id := range ids {
+		if skipped[id] {
+			continue
+		}
+		synced := s.state.Notes[id]
+		local, hasLocal := localNotes[id]
+		note, hasRemote := remoteNotes[id]
+		localChanged := !hasLocal || local.Hash != synced.Hash
+		remoteChanged := !hasRemote || !note.LastModified.Equal(synced.LastModified)
+		if hasLocal {
+			synced.File = local.File // renamed or moved
+		}
+		switch {
+		case !localChanged && !remoteChanged:
+		case !hasLocal && !hasRemote:
+			delete(s.state.Notes, id)
+		case !hasLocal && remoteChanged:
+			err = s.pull(note, synced.File) // edited on the server, keep it
+		case !hasLocal:
+			err = s.deleteRemote(id)
+		case !hasRemote && localChanged:
+			delete(s.state.Notes, id)
+			newNotes = append(newNotes, local) // edited locally, keep it
+		case !hasRemote:
+			err = s.trash(id, local.File)
+		case !remoteChanged:
+			err = s.push(local, synced)
+		case !localChanged:
+			err = s.pull(note, local.File)
+		default:
+			err = s.conflict(local, note)
+		}
+		if err != nil {
+			log.Printf("sync %s: %s", synced.File, err)
+		}
+	}
+	for _, local := range newNotes {
+		err = s.create(local)
+		if err != nil {
+			log.Printf("sync %s: %s", local.File, err)
+		}
+	}
+	for id, note := range remoteNotes {
+		if !known[id] && !skipped[id] {
+			err = s.pull(note, s.newFile(note.Body))
+			if err != nil {
+				log.Printf("sync %s: %s", id, err)
+			}
+		}
+	}
+	return s.saveState()
+}
+
+// scan reads the Markdown files in the synced directory. Files with an ID in their front matter are returned by
+// ID, other files are new notes. Files whose front matter can't be read are returned as unreadable with the ID
+// found in it, if any, so their notes aren't mistaken for deleted ones.
+func (s *syncer) scan() (notes map[string]localNote, newNotes, unreadable []localNote, err error) {
+	notes = make(map[string]localNote)
+	err = filepath.Walk(s.dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if strings.HasPrefix(info.Name(), ".") && path != s.dir {
+			if info.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if info.IsDir() || filepath.Ext(path) != ".md" {
+			return nil
+		}
+		data, err := ioutil.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		file, err := filepath.Rel(s.dir, path)
+		if err != nil {
+			return err
+		}
+		local := localNote{File: file, Hash: hash(data)}
+		local.Note, err = convert.UnmarshalMarkdown(data)
+		if err == convert.ErrNoFrontMatter {
+			local.Note = convert.Note{Body: string(data)} // written by hand
+		} else if err != nil {
+			log.Printf("sync %s: %s, skipped until fixed", file, err)
+			local.Note = convert.Note{ID: frontMatterID(data)}
+			unreadable = append(unreadable, local)
+			return nil
+		}
+		if _, seen := notes[local.Note.ID]; local.Note.ID == "" || seen {
+			local.Note.ID = "" // new, or a copy of another note's file
+			newNotes = append(newNotes, local)
+		} else {
+			notes[local.Note.ID] = local
+		}
+		return nil
+	})
+	return notes, newNotes, unreadable, err
+}
+
+var frontMatterIDLine = regexp.MustCompile(`(?m)^id:[ \t]*(.*?)[ \t]*$`)
+
+// frontMatterID returns the ID in the front matter of a note's file that can't be read otherwise, or "".
+func frontMatterID(data []byte) string {
+	match := frontMatterIDLine.FindSubmatch(data)
+	if match == nil {
+		return ""
+	}
+	id := string(match[1])
+	if unquoted, err := strconv.Unquote(id); err == nil {
+		return unquoted
+	}
+	return strings.Trim(id, `'`)
+}
+
+// push uploads a note changed locally. If the note changed on the server since it was listed it's a conflict.
+func (s *syncer) push(local localNote, synced *syncedNote) error {
+	note, err := s.client.GetNote(s.ctx, local.Note.ID)
+	if err != nil {
+		return err
+	}
+	if !note.LastModified.Equal(synced.LastModified) {
+		return s.conflict(local, note)
+	}
+	note.Body, note.Pinned, note.Archived = local.Note.Body, local.Note.Pinned, local.Note.Archived
+	replaced, err := s.client.ReplaceNote(s.ctx, note)
+	if err != nil {
+		return err
+	}
+	if replaced.ID != note.ID {
+		delete(s.state.Notes, note.ID)
+		return s.pull(replaced, local.File)
+	}
+	log.Printf("pushed %s", local.File)
+	s.state.Notes[note.ID] = &syncedNote{local.File, local.Hash, replaced.LastModified}
+	return nil
+}
+
+// create uploads a new note and rewrites its file with the note's ID.
+func (s *syncer) create(local localNote) error {
+	note, err := s.client.CreateNote(s.ctx, client.Note{
+		Body:     local.Note.Body,
+		Pinned:   local.Note.Pinned,
+		Archived: local.Note.Archived,
+	})
+	if err != nil {
+		return err
+	}
+	log.Printf("created %s", local.File)
+	return s.pull(note, local.File)
+}
+
+// pull writes a note to file.
+func (s *syncer) pull(note client.Note, file string) error {
+	data := convert.MarshalMarkdown(convert.Note{
+		ID:           note.ID,
+		Body:         note.Body,
+		Created:      note.Created,
+		LastModified: note.LastModified,
+		Pinned:       note.Pinned,
+		Archived:     note.Archived,
+	})
+	path := filepath.Join(s.dir, file)
+	err := os.MkdirAll(filepath.Dir(path), 0755)
+	if err != nil {
+		return err
+	}
+	err = ioutil.WriteFile(path, data, 0644)
+	if err != nil {
+		return err
+	}
+	log.Printf("pulled %s", file)
+	s.state.Notes[note.ID] = &syncedNote{file, hash(data), note.LastModified}
+	return nil
+}
+
+// conflict keeps both versions of a note changed locally and on the server. The server's version is written to
+// the note's file and the local version is uploaded as a new note.
+func (s *syncer) conflict(local localNote, note client.Note) error {
+	if local.Note.Body == note.Body {
+		return s.pull(note, local.File) // same change on both sides
+	}
+	ext := filepath.Ext(local.File)
+	kept := local
+	kept.File = strings.TrimSuffix(local.File, ext) + ".conflict-" + time.Now().Format("20060102-150405") + ext
+	kept.Note.ID = ""
+	err := s.create(kept)
+	if err != nil {
+		return err
+	}
+	log.Printf("conflict in %s, local version kept in %s", local.File, kept.File)
+	return s.pull(note, local.File)
+}
+
+// deleteRemote deletes a note whose file was deleted.
+func (s *syncer) deleteRemote(id string) error {
+	_, err := s.client.DeleteNote(s.ctx, id)
+	if err != nil && !errors.Is(err, client.ErrNotFound) {
+		return err
+	}
+	log.Printf("deleted %s", s.state.Notes[id].File)
+	delete(s.state.Notes, id)
+	return nil
+}
+
+// trash moves the file of a note deleted on the server to the trash folder.
+func (s *syncer) trash(id, file string) error {
+	trashed := filepath.Join(s.dir, syncTrashDir, file)
+	err := os.MkdirAll(filepath.Dir(trashed), 0755)
+	if err != nil {
+		return err
+	}
+	err = os.Rename(filepath.Join(s.dir, file), trashed)
+	if err != nil {
+		return err
+	}
+	log.Printf("trashed %s", file)
+	delete(s.state.Notes, id)
+	return nil
+}
+
+var nonSlugChars = regexp.MustCompile(`[^\pL\pN]+`)
+
+// newFile returns an unused file name for a note based on its first line.
+func (s *syncer) newFile(body string) string {
+	slug := strings.Trim(nonSlugChars.ReplaceAllString(strings.ToLower(firstLine(body)), "-"), "-")
+	if len(slug) > 50 {
+		slug = strings.TrimRight(slug[:50], "-")
+	}
+	if slug == "" {
+		slug = "note"
+	}
+	file := slug + ".md"
+	for i := 2; s.exists(file); i++ {
+		file = fmt.Sprintf("%s-%d.md", slug, i)
+	}
+	return file
+}
+
+// exists returns true if file exists in the synced directory or belongs to a synced note.
+func (s *syncer) exists(file string) bool {
+	for _, synced := range s.state.Notes {
+		if synced.File == file {
+			return true
+		}
+	}
+	_, err := os.Stat(filepath.Join(s.dir, file))
+	return err == nil
+}
+
+// firstLine returns the first non-empty line of text without Markdown heading marks.
+func firstLine(text string) string {
+	for _, line := range strings.Split(text, "\n") {
+		if line = strings.TrimSpace(strings.TrimLeft(line, "# ")); line != "" {
+			return line
+		}
+	}
+	return ""
+}
+
+// hash returns a hash of a file's content for finding changes.
+func hash(data []byte) string {
+	sum := sha1.Sum(data)
+	return hex.EncodeToString(sum[:])
+}
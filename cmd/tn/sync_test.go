@@ -0,0 +1,294 @@
+//go:build !appengine
+// +build !appengine
+
+/*
+This file is part of Tessernote.
+
+Tessernote is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+Tessernote is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with Tessernote.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/oschmid/tessernote/client"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// testServer is an in-memory notebook served like the notes API.
+type testServer struct {
+	mu    sync.Mutex
+	notes map[string]client.Note
+	next  int
+	clock time.Time
+}
+
+// put stores note, giving new notes an ID, and returns it.
+func (server *testServer) put(note client.Note) client.Note {
+	if note.ID == "" {
+		server.next++
+		note.ID = "n" + strconv.Itoa(server.next)
+	}
+	server.clock = server.clock.Add(time.Second)
+	note.LastModified = server.clock
+	server.notes[note.ID] = note
+	return note
+}
+
+func (server *testServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	server.mu.Lock()
+	defer server.mu.Unlock()
+	id := strings.TrimPrefix(r.URL.Path, client.NotesURL)
+	var reply interface{}
+	switch {
+	case r.Method == "GET" && id == "":
+		notes := []client.Note{}
+		for _, note := range server.notes {
+			notes = append(notes, note)
+		}
+		reply = notes
+	case r.Method == "POST" && id == "", r.Method == "PUT" && id != "":
+		var note client.Note
+		err := json.NewDecoder(r.Body).Decode(&note)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if _, ok := server.notes[id]; id != "" && !ok {
+			http.NotFound(w, r)
+			return
+		}
+		note.ID = id
+		reply = server.put(note)
+	case r.Method == "GET":
+		note, ok := server.notes[id]
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		reply = note
+	case r.Method == "DELETE":
+		_, ok := server.notes[id]
+		delete(server.notes, id)
+		reply = ok
+	default:
+		http.Error(w, "unexpected request", http.StatusBadRequest)
+		return
+	}
+	json.NewEncoder(w).Encode(reply)
+}
+
+// bodies returns the bodies of the notes on the server.
+func (server *testServer) bodies() map[string]string {
+	server.mu.Lock()
+	defer server.mu.Unlock()
+	bodies := make(map[string]string)
+	for id, note := range server.notes {
+		bodies[id] = note.Body
+	}
+	return bodies
+}
+
+// newTestSyncer returns a syncer of a temporary directory with a test server.
+func newTestSyncer(t *testing.T) (*syncer, *testServer, func()) {
+	dir, err := ioutil.TempDir("", "tn-sync")
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := &testServer{notes: make(map[string]client.Note), clock: time.Date(2013, 6, 1, 0, 0, 0, 0, time.UTC)}
+	httpServer := httptest.NewServer(server)
+	c := client.New(httpServer.URL, "secret")
+	c.Backoff = time.Millisecond
+	s := &syncer{client: c, dir: dir, ctx: context.Background()}
+	err = s.loadState()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s, server, func() {
+		httpServer.Close()
+		os.RemoveAll(dir)
+	}
+}
+
+// syncOnce syncs and fails the test on errors.
+func syncOnce(t *testing.T, s *syncer) {
+	err := s.sync()
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+// readFile returns the content of a file in the synced directory, or "" if it doesn't exist.
+func readFile(s *syncer, file string) string {
+	data, _ := ioutil.ReadFile(filepath.Join(s.dir, file))
+	return string(data)
+}
+
+// editFile replaces old with new in a file in the synced directory.
+func editFile(t *testing.T, s *syncer, file, old, new string) {
+	content := readFile(s, file)
+	if !strings.Contains(content, old) {
+		t.Fatalf("expected %q in %s: %q", old, file, content)
+	}
+	err := ioutil.WriteFile(filepath.Join(s.dir, file), []byte(strings.Replace(content, old, new, 1)), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestSyncPullPushCreate(t *testing.T) {
+	s, server, cleanup := newTestSyncer(t)
+	defer cleanup()
+	alpha := server.put(client.Note{Body: "# Alpha\nfirst #x"})
+
+	// pull
+	syncOnce(t, s)
+	if content := readFile(s, "alpha.md"); !strings.Contains(content, `id: "`+alpha.ID+`"`) ||
+		!strings.HasSuffix(content, "first #x") {
+		t.Fatalf("unexpected alpha.md %q", content)
+	}
+
+	// push
+	editFile(t, s, "alpha.md", "first", "second")
+	syncOnce(t, s)
+	if body := server.bodies()[alpha.ID]; body != "# Alpha\nsecond #x" {
+		t.Fatalf("expected pushed body, actual=%q", body)
+	}
+
+	// create
+	err := ioutil.WriteFile(filepath.Join(s.dir, "new.md"), []byte("# New\nby hand"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	syncOnce(t, s)
+	bodies := server.bodies()
+	if len(bodies) != 2 || bodies["n2"] != "# New\nby hand" {
+		t.Fatalf("expected created note, actual=%q", bodies)
+	}
+	if content := readFile(s, "new.md"); !strings.Contains(content, `id: "n2"`) {
+		t.Fatalf("expected new.md to be rewritten with its ID, actual=%q", content)
+	}
+
+	// nothing changed
+	syncOnce(t, s)
+	if len(server.bodies()) != 2 || server.notes[alpha.ID].LastModified != s.state.Notes[alpha.ID].LastModified {
+		t.Fatalf("unexpected changes %q", server.bodies())
+	}
+}
+
+func TestSyncRenameConflict(t *testing.T) {
+	s, server, cleanup := newTestSyncer(t)
+	defer cleanup()
+	alpha := server.put(client.Note{Body: "# Alpha\nfirst"})
+	syncOnce(t, s)
+
+	// rename
+	err := os.MkdirAll(filepath.Join(s.dir, "sub"), 0755)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = os.Rename(filepath.Join(s.dir, "alpha.md"), filepath.Join(s.dir, "sub", "renamed.md"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	syncOnce(t, s)
+	if bodies := server.bodies(); len(bodies) != 1 || bodies[alpha.ID] != "# Alpha\nfirst" {
+		t.Fatalf("expected renamed note to be kept, actual=%q", bodies)
+	}
+	if file := s.state.Notes[alpha.ID].File; file != filepath.Join("sub", "renamed.md") {
+		t.Fatalf("expected=%s actual=%s", filepath.Join("sub", "renamed.md"), file)
+	}
+
+	// conflict
+	server.mu.Lock()
+	note := server.notes[alpha.ID]
+	note.Body = "# Alpha\nremote"
+	server.put(note)
+	server.mu.Unlock()
+	editFile(t, s, filepath.Join("sub", "renamed.md"), "first", "local")
+	syncOnce(t, s)
+	bodies := server.bodies()
+	if len(bodies) != 2 || bodies[alpha.ID] != "# Alpha\nremote" || bodies["n2"] != "# Alpha\nlocal" {
+		t.Fatalf("expected both versions on the server, actual=%q", bodies)
+	}
+	if content := readFile(s, filepath.Join("sub", "renamed.md")); !strings.HasSuffix(content, "remote") {
+		t.Fatalf("expected server version in renamed.md, actual=%q", content)
+	}
+	conflicts, _ := filepath.Glob(filepath.Join(s.dir, "sub", "renamed.conflict-*.md"))
+	if len(conflicts) != 1 {
+		t.Fatalf("expected one conflict file, actual=%v", conflicts)
+	}
+}
+
+func TestSyncDeleteTrash(t *testing.T) {
+	s, server, cleanup := newTestSyncer(t)
+	defer cleanup()
+	alpha := server.put(client.Note{Body: "# Alpha"})
+	beta := server.put(client.Note{Body: "# Beta"})
+	gamma := server.put(client.Note{Body: "# Gamma"})
+	syncOnce(t, s)
+
+	// delete
+	err := os.Remove(filepath.Join(s.dir, "alpha.md"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// trash
+	server.mu.Lock()
+	delete(server.notes, beta.ID)
+	server.mu.Unlock()
+	// unreadable front matter isn't a delete
+	editFile(t, s, "gamma.md", "modified: ", "modified: not a time ")
+
+	syncOnce(t, s)
+	bodies := server.bodies()
+	if _, ok := bodies[alpha.ID]; ok {
+		t.Fatalf("expected %s to be deleted, actual=%q", alpha.ID, bodies)
+	}
+	if len(bodies) != 1 || bodies[gamma.ID] != "# Gamma" {
+		t.Fatalf("expected note with unreadable file to be kept, actual=%q", bodies)
+	}
+	if readFile(s, "beta.md") != "" || !strings.HasSuffix(readFile(s, filepath.Join(syncTrashDir, "beta.md")), "# Beta") {
+		t.Fatal("expected beta.md to be trashed")
+	}
+	if !strings.Contains(readFile(s, "gamma.md"), "not a time") || s.state.Notes[gamma.ID] == nil {
+		t.Fatal("expected gamma.md to be left alone")
+	}
+}
+
+func TestFrontMatterID(t *testing.T) {
+	tests := []struct {
+		data, expected string
+	}{
+		{"---\nid: \"abc\"\nmodified: bad\n---\nbody", "abc"},
+		{"---\nid: abc \nmodified: bad\n---\nbody", "abc"},
+		{"---\nid: 'abc'\n---\n", "abc"},
+		{"---\nmodified: bad\n---\nbody", ""},
+	}
+	for _, test := range tests {
+		if actual := frontMatterID([]byte(test.data)); actual != test.expected {
+			t.Errorf("%q: expected=%q actual=%q", test.data, test.expected, actual)
+		}
+	}
+}
@@ -19,6 +19,8 @@ package tessernote
 
 import (
 	"appengine/datastore"
+	"github.com/oschmid/tessernote/hashtag"
+	"time"
 )
 
 // addKey appends add to keys if add doesn't already exist in keys
@@ -45,8 +47,9 @@ func containsKey(keys []*datastore.Key, key *datastore.Key) bool {
 }
 
 func indexOfKey(keys []*datastore.Key, key *datastore.Key) int {
+	encoded := key.Encode()
 	for i := range keys {
-		if keys[i].Encode() == key.Encode() {
+		if keys[i].Encode() == encoded {
 			return i
 		}
 	}
@@ -54,30 +57,93 @@ func indexOfKey(keys []*datastore.Key, key *datastore.Key) int {
 }
 
 func containsString(strings []string, s string) bool {
-	for _, elem := range strings {
+	return indexOfString(strings, s) >= 0
+}
+
+func indexOfString(strings []string, s string) int {
+	for i, elem := range strings {
 		if elem == s {
+			return i
+		}
+	}
+	return -1
+}
+
+func equalStrings(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// sameTime returns true if a and b are the same to the microsecond, the precision of times in the datastore.
+func sameTime(a, b time.Time) bool {
+	return a.Truncate(time.Microsecond).Equal(b.Truncate(time.Microsecond))
+}
+
+func containsInt(ints []int, n int) bool {
+	for _, elem := range ints {
+		if elem == n {
 			return true
 		}
 	}
 	return false
 }
 
+func minInt(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+// indexOfTag returns the index of the Tag called name. Names are compared by their folded form.
 func indexOfTag(tags []Tag, name string) int {
+	name = hashtag.Fold(name)
 	for i, tag := range tags {
-		if tag.Name == name {
+		if tag.foldedName() == name {
 			return i
 		}
 	}
 	return -1
 }
 
-func unionKeys(a, b []*datastore.Key) []*datastore.Key {
-	c := *new([]*datastore.Key)
-	for _, elem := range a {
-		j := indexOfKey(b, elem)
-		if j >= 0 {
-			c = append(c, b[j])
+// containsTagName returns true if names contains a spelling of name.
+func containsTagName(names []string, name string) bool {
+	return indexOfTagName(names, name) >= 0
+}
+
+// indexOfTagName returns the index of a spelling of name in names.
+func indexOfTagName(names []string, name string) int {
+	name = hashtag.Fold(name)
+	for i, elem := range names {
+		if hashtag.Fold(elem) == name {
+			return i
 		}
 	}
-	return c
+	return -1
+}
+
+// tagsByName sorts Tags and their Keys by folded name.
+type tagsByName struct {
+	keys []*datastore.Key
+	tags []Tag
+}
+
+func (s tagsByName) Len() int {
+	return len(s.tags)
+}
+
+func (s tagsByName) Less(i, j int) bool {
+	return s.tags[i].foldedName() < s.tags[j].foldedName()
+}
+
+func (s tagsByName) Swap(i, j int) {
+	s.keys[i], s.keys[j] = s.keys[j], s.keys[i]
+	s.tags[i], s.tags[j] = s.tags[j], s.tags[i]
 }
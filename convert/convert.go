@@ -0,0 +1,142 @@
+/*
+This file is part of Tessernote.
+
+Tessernote is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+Tessernote is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with Tessernote.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+// Package convert converts notes between Tessernote and the export formats of other note taking apps.
+//
+// It does not depend on the App Engine SDK so it can be used from ordinary Go programs, e.g. to convert an export
+// before sending it to a Tessernote server with package client.
+package convert
+
+import (
+	"github.com/oschmid/tessernote/hashtag"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Note is a note read from an export.
+type Note struct {
+	ID           string // Tessernote ID, only kept by Markdown archives
+	Title        string // for reporting, Body already starts with it
+	Body         string // including the note's tags as hashtags
+	Created      time.Time
+	LastModified time.Time
+	Pinned       bool
+	Archived     bool
+}
+
+// NoteError is returned for a note that can't be converted. Reading can continue with the next note.
+type NoteError struct {
+	Title string
+	Err   error
+}
+
+func (err *NoteError) Error() string {
+	return "converting note " + strconv.Quote(err.Title) + ": " + err.Err.Error()
+}
+
+func (err *NoteError) Unwrap() error {
+	return err.Err
+}
+
+var nonTagChars = regexp.MustCompile("[^" + hashtag.AlphaNumericChars + "]+")
+
+// TagName returns the hashtag name (without its hash mark) for a label or tag of another app, e.g. "to do" becomes
+// to_do. Labels without a letter are prefixed with "tag_" because hashtags need at least one.
+func TagName(label string) string {
+	if isTagName(label) {
+		return label
+	}
+	name := strings.Trim(nonTagChars.ReplaceAllString(label, "_"), "_")
+	if name == "" {
+		return ""
+	}
+	if !isTagName(name) {
+		name = "tag_" + name
+	}
+	return name
+}
+
+// isTagName returns true if name is a valid hashtag name.
+func isTagName(name string) bool {
+	names := hashtag.Names("#" + name)
+	return len(names) == 1 && names[0] == name
+}
+
+// AppendTags returns body with hashtags for labels appended on a line of their own. Labels body already has a
+// hashtag for are left out.
+func AppendTags(body string, labels []string) string {
+	have := make(map[string]bool)
+	for _, name := range hashtag.Names(body) {
+		have[hashtag.Fold(name)] = true
+	}
+	var tags []string
+	for _, label := range labels {
+		name := TagName(label)
+		if name == "" || have[hashtag.Fold(name)] {
+			continue
+		}
+		have[hashtag.Fold(name)] = true
+		tags = append(tags, "#"+name)
+	}
+	if len(tags) == 0 {
+		return body
+	}
+	if body == "" {
+		return strings.Join(tags, " ")
+	}
+	return strings.TrimRight(body, "\n") + "\n\n" + strings.Join(tags, " ")
+}
+
+// checklist returns items as a Markdown task list.
+func checklist(items []string, checked []bool) string {
+	lines := make([]string, len(items))
+	for i, item := range items {
+		if checked[i] {
+			lines[i] = "- [x] " + item
+		} else {
+			lines[i] = "- [ ] " + item
+		}
+	}
+	return strings.Join(lines, "\n")
+}
+
+// firstLine returns the first line of text, e.g. the title of a note without a separate one.
+func firstLine(text string) string {
+	text = strings.TrimSpace(text)
+	if i := strings.Index(text, "\n"); i >= 0 {
+		text = text[:i]
+	}
+	return strings.TrimSpace(text)
+}
+
+// withTitle returns body with title as its first line, as a Markdown heading if markdown is true. The title is
+// left out if it's empty or body already starts with it.
+func withTitle(title, body string, markdown bool) string {
+	title = strings.TrimSpace(title)
+	if title == "" || strings.HasPrefix(strings.TrimLeft(body, "# "), title) {
+		return body
+	}
+	if markdown {
+		title = "# " + title
+	}
+	if body == "" {
+		return title
+	}
+	return title + "\n\n" + body
+}
@@ -0,0 +1,236 @@
+/*
+This file is part of Tessernote.
+
+Tessernote is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+Tessernote is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with Tessernote.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package convert
+
+import (
+	"archive/zip"
+	"bytes"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+	"time"
+)
+
+const enex = `<?xml version="1.0" encoding="UTF-8"?>
+<!DOCTYPE en-export SYSTEM "http://xml.evernote.com/pub/evernote-export3.dtd">
+<en-export export-date="20130601T100000Z" application="Evernote" version="Evernote Mac 5.0">
+<note><title>Shopping</title><content><![CDATA[<?xml version="1.0" encoding="UTF-8"?>
+<!DOCTYPE en-note SYSTEM "http://xml.evernote.com/pub/enml2.dtd">
+<en-note><div><b>Buy</b>&nbsp;these:</div><ul><li><en-todo checked="true"/>milk</li><li><en-todo/>eggs</li></ul>
+<div>See <a href="http://example.com">the list</a> #groceries</div><en-media type="image/png" hash="abc"/></en-note>]]></content>
+<created>20130501T120000Z</created><updated>20130502T083000Z</updated><tag>to do</tag><tag>Groceries</tag><tag>2013</tag>
+<resource><data encoding="base64">iVBORw0KGgo=</data><mime>image/png</mime></resource></note>
+<note><title>Empty</title><content><![CDATA[<en-note/>]]></content><created>bad</created></note>
+<note><title></title><content><![CDATA[<en-note><p>one</p><p>two</p></en-note>]]></content></note>
+</en-export>`
+
+func TestENEXReader(t *testing.T) {
+	reader := NewENEXReader(strings.NewReader(enex))
+	note, err := reader.Next()
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := "# Shopping\n\n**Buy** these:\n\n- [x] milk\n- [ ] eggs\n\nSee [the list](http://example.com) #groceries\n\n#to_do #tag_2013"
+	if note.Body != expected {
+		t.Fatalf("expected=%q actual=%q", expected, note.Body)
+	}
+	if !note.Created.Equal(time.Date(2013, 5, 1, 12, 0, 0, 0, time.UTC)) || !note.LastModified.Equal(time.Date(2013, 5, 2, 8, 30, 0, 0, time.UTC)) {
+		t.Fatalf("unexpected times %s %s", note.Created, note.LastModified)
+	}
+
+	_, err = reader.Next()
+	var noteErr *NoteError
+	if !errors.As(err, &noteErr) || noteErr.Title != "Empty" {
+		t.Fatalf("expected NoteError for bad created time, actual=%v", err)
+	}
+
+	reader.Markdown = false
+	note, err = reader.Next()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if note.Body != "one\n\ntwo" || !note.Created.IsZero() {
+		t.Fatalf("unexpected note %#v", note)
+	}
+
+	_, err = reader.Next()
+	if err != io.EOF {
+		t.Fatalf("expected=%s actual=%v", io.EOF, err)
+	}
+}
+
+func TestENMLText(t *testing.T) {
+	text, err := ENML(`<en-note><h1>Title</h1><div>a <a href="http://example.com">link</a></div><blockquote>quote</blockquote></en-note>`, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := "Title\n\na link (http://example.com)\n\nquote"
+	if text != expected {
+		t.Fatalf("expected=%q actual=%q", expected, text)
+	}
+}
+
+func TestTagName(t *testing.T) {
+	for label, expected := range map[string]string{
+		"go":          "go",
+		"status:done": "status:done",
+		"to do":       "to_do",
+		" C++ ":       "C",
+		"2013":        "tag_2013",
+		"!!!":         "",
+	} {
+		if actual := TagName(label); actual != expected {
+			t.Errorf("%q: expected=%q actual=%q", label, expected, actual)
+		}
+	}
+}
+
+func TestReadSimplenote(t *testing.T) {
+	notes, err := ReadSimplenote(strings.NewReader(`{"activeNotes":[{"id":"1","content":"Title\nbody #go","creationDate":"2019-06-07T15:04:05.000Z","lastModified":"2019-06-08T15:04:05.000Z","tags":["go","work"],"pinned":true}],"trashedNotes":[{"id":"2","content":"gone"}]}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(notes) != 1 || notes[0].Body != "Title\nbody #go\n\n#work" || notes[0].Title != "Title" || !notes[0].Pinned {
+		t.Fatalf("unexpected notes %#v", notes)
+	}
+	if !notes[0].Created.Equal(time.Date(2019, 6, 7, 15, 4, 5, 0, time.UTC)) {
+		t.Fatalf("unexpected created time %s", notes[0].Created)
+	}
+}
+
+func TestReadKeepJSON(t *testing.T) {
+	notes, err := ReadKeepJSON(strings.NewReader(`{"title":"Shopping","isArchived":true,"isTrashed":false,"isPinned":false,"listContent":[{"text":"milk","isChecked":true},{"text":"eggs","isChecked":false}],"labels":[{"name":"home"}],"createdTimestampUsec":1559919845000000,"userEditedTimestampUsec":1559919900000000}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := "Shopping\n\n- [x] milk\n- [ ] eggs\n\n#home"
+	if len(notes) != 1 || notes[0].Body != expected || !notes[0].Archived || notes[0].Pinned {
+		t.Fatalf("unexpected notes %#v", notes)
+	}
+	if !notes[0].LastModified.Equal(time.Unix(1559919900, 0)) {
+		t.Fatalf("unexpected last modified time %s", notes[0].LastModified)
+	}
+	notes, err = ReadKeepJSON(strings.NewReader(`{"textContent":"gone","isTrashed":true}`))
+	if err != nil || len(notes) != 0 {
+		t.Fatalf("expected trashed note to be left out, actual=%#v %v", notes, err)
+	}
+}
+
+func TestReadKeepHTML(t *testing.T) {
+	notes, err := ReadKeepHTML(strings.NewReader(`<?xml version="1.0" encoding="UTF-8" ?>
+<!DOCTYPE html>
+<html><head><meta charset="UTF-8"><title>Ideas</title><style>.note { color: red; }</style></head>
+<body><div class="note DEFAULT"><div class="heading"><div class="meta-icons"><span class="pinned" title="Note pinned"></span></div>
+Jun 7, 2019, 3:04:05 PM
+</div>
+<div class="title">Ideas</div>
+<div class="content">first<br>second</div>
+<div class="labels"><span class="label"><span class="label-name">side project</span></span></div></div></body></html>`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(notes) != 1 || notes[0].Body != "Ideas\n\nfirst\nsecond\n\n#side_project" || !notes[0].Pinned {
+		t.Fatalf("unexpected notes %#v", notes)
+	}
+	if !notes[0].Created.Equal(time.Date(2019, 6, 7, 15, 4, 5, 0, time.UTC)) {
+		t.Fatalf("unexpected created time %s", notes[0].Created)
+	}
+}
+
+func TestReadFetchnotes(t *testing.T) {
+	notes, err := ReadFetchnotes(strings.NewReader(`[{"text":"call mom #todo","created_at":"2013-05-01T12:00:00Z","updated_at":"2013-05-02T12:00:00Z","tags":["todo"],"archived":true}]`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(notes) != 1 || notes[0].Body != "call mom #todo" || !notes[0].Archived {
+		t.Fatalf("unexpected notes %#v", notes)
+	}
+}
+
+func TestMarkdownArchive(t *testing.T) {
+	created := time.Date(2013, 5, 1, 12, 0, 0, 123, time.UTC)
+	notes := []Note{
+		{ID: "abc", Title: "Go", Body: "# Go\n\nbody #go #status:done #Go\n", Created: created, LastModified: created.Add(time.Hour), Pinned: true},
+		{ID: "def", Title: "---", Body: "---\nnot front matter\n---\n#go", Created: created, LastModified: created, Archived: true},
+		{Body: ""},
+	}
+	var b bytes.Buffer
+	err := WriteMarkdownArchive(&b, notes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	read, err := ReadMarkdownArchive(bytes.NewReader(b.Bytes()), int64(b.Len()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(read) != len(notes) {
+		t.Fatalf("expected=%d actual=%d", len(notes), len(read))
+	}
+	for i := range notes {
+		if read[i] != notes[i] {
+			t.Errorf("expected=%#v actual=%#v", notes[i], read[i])
+		}
+	}
+
+	archive, _ := zip.NewReader(bytes.NewReader(b.Bytes()), int64(b.Len()))
+	var names []string
+	for _, f := range archive.File {
+		names = append(names, f.Name)
+	}
+	expected := "notes/abc.md notes/def.md notes/3.md tags/go.md tags/status=done.md"
+	if strings.Join(names, " ") != expected {
+		t.Fatalf("expected=%s actual=%s", expected, strings.Join(names, " "))
+	}
+}
+
+func TestUnmarshalMarkdown(t *testing.T) {
+	note, err := UnmarshalMarkdown([]byte("---\r\nid: abc\r\ntags:\r\n  - go\r\n  - 'to do'\r\n---\r\nbody #go"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if note.ID != "abc" || note.Body != "body #go\n\n#to_do" {
+		t.Fatalf("unexpected note %#v", note)
+	}
+	_, err = UnmarshalMarkdown([]byte("just text"))
+	if err != ErrNoFrontMatter {
+		t.Fatalf("expected=%s actual=%v", ErrNoFrontMatter, err)
+	}
+}
+
+func TestHTML(t *testing.T) {
+	text := "# Plan #work\nfirst **line**\nsee [docs](http://example.com/a_b) & `x<y`\n\n- [x] done\n- [ ] *todo*\n\n" +
+		"> quoted\n\n```\n#not <tag>\n```\n[bad](javascript:alert(1)) www.example.com."
+	expected := `<h1>Plan <a class="tag" href="tags/work">#work</a></h1>
+<p>first <strong>line</strong><br/>
+see <a href="http://example.com/a_b">docs</a> &amp; <code>x&lt;y</code></p>
+<ul>
+<li><input type="checkbox" disabled="disabled" checked="checked"/> done</li>
+<li><input type="checkbox" disabled="disabled"/> <em>todo</em></li>
+</ul>
+<blockquote>
+<p>quoted</p>
+</blockquote>
+<pre><code>#not &lt;tag&gt;</code></pre>
+<p>[bad](javascript:alert(1)) <a href="http://www.example.com">www.example.com</a>.</p>
+`
+	actual := HTML(text, func(name string) string { return "tags/" + name })
+	if actual != expected {
+		t.Fatalf("expected=%s\nactual=%s", expected, actual)
+	}
+}
@@ -0,0 +1,347 @@
+/*
+This file is part of Tessernote.
+
+Tessernote is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+Tessernote is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with Tessernote.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package convert
+
+import (
+	"bytes"
+	"encoding/xml"
+	"io"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const enexTimeFormat = "20060102T150405Z"
+
+// ENEXReader streams Notes from an Evernote export (ENEX) file. Note content (ENML) is converted to Markdown or
+// plain text and Evernote tags are appended as hashtags. Attachments are left out.
+type ENEXReader struct {
+	Markdown bool // convert content to Markdown instead of plain text
+	decoder  *xml.Decoder
+}
+
+// enexNote is a note element of an ENEX file.
+type enexNote struct {
+	Title   string   `xml:"title"`
+	Content string   `xml:"content"`
+	Created string   `xml:"created"`
+	Updated string   `xml:"updated"`
+	Tags    []string `xml:"tag"`
+}
+
+// NewENEXReader creates an ENEXReader that reads from r and converts content to Markdown.
+func NewENEXReader(r io.Reader) *ENEXReader {
+	decoder := xml.NewDecoder(r)
+	decoder.Strict = false
+	return &ENEXReader{Markdown: true, decoder: decoder}
+}
+
+// Next returns the next Note. It returns io.EOF after the last one. A note that can't be converted returns a
+// *NoteError, reading can continue with the next one. Other errors are final.
+func (reader *ENEXReader) Next() (Note, error) {
+	for {
+		token, err := reader.decoder.Token()
+		if err != nil {
+			return Note{}, err
+		}
+		start, ok := token.(xml.StartElement)
+		if !ok || start.Name.Local != "note" {
+			continue
+		}
+		var n enexNote
+		err = reader.decoder.DecodeElement(&n, &start)
+		if err != nil {
+			return Note{}, err
+		}
+		note, err := n.convert(reader.Markdown)
+		if err != nil {
+			return note, &NoteError{n.Title, err}
+		}
+		return note, nil
+	}
+}
+
+// convert converts an ENEX note to a Note.
+func (n enexNote) convert(markdown bool) (Note, error) {
+	note := Note{Title: strings.TrimSpace(n.Title)}
+	var err error
+	if n.Created != "" {
+		note.Created, err = time.Parse(enexTimeFormat, n.Created)
+		if err != nil {
+			return note, err
+		}
+	}
+	note.LastModified = note.Created
+	if n.Updated != "" {
+		note.LastModified, err = time.Parse(enexTimeFormat, n.Updated)
+		if err != nil {
+			return note, err
+		}
+	}
+	body, err := ENML(n.Content, markdown)
+	if err != nil {
+		return note, err
+	}
+	note.Body = AppendTags(withTitle(note.Title, body, markdown), n.Tags)
+	return note, nil
+}
+
+var (
+	spaces         = regexp.MustCompile("[ \t\r\n\u00a0]+")
+	blankLines     = regexp.MustCompile(`\n\n\n+`)
+	trailingSpaces = regexp.MustCompile(`[ \t]+\n`)
+)
+
+// enml converts ENML, Evernote's XHTML subset, to text.
+type enml struct {
+	markdown bool
+	text     []byte   // written so far
+	lists    []int    // open lists, the number of the next item or -1 if unordered
+	links    []string // hrefs of open links
+	quotes   int      // open blockquotes
+	pre      int      // open pre elements
+	cells    int      // cells written in the current table row
+}
+
+// ENML converts ENML (the content of an Evernote note) to Markdown if markdown is true, or to plain text.
+func ENML(content string, markdown bool) (string, error) {
+	decoder := xml.NewDecoder(strings.NewReader(content))
+	decoder.Strict = false
+	decoder.AutoClose = xml.HTMLAutoClose
+	decoder.Entity = xml.HTMLEntity
+	e := &enml{markdown: markdown}
+	for {
+		token, err := decoder.Token()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return "", err
+		}
+		switch t := token.(type) {
+		case xml.StartElement:
+			e.start(t)
+		case xml.EndElement:
+			e.end(t.Name.Local)
+		case xml.CharData:
+			e.chars(string(t))
+		}
+	}
+	text := trailingSpaces.ReplaceAllString(string(e.text), "\n")
+	return strings.TrimSpace(blankLines.ReplaceAllString(text, "\n\n")), nil
+}
+
+// start converts the start of an element.
+func (e *enml) start(t xml.StartElement) {
+	switch name := t.Name.Local; name {
+	case "div", "tr", "dd", "dt":
+		e.breakLine()
+		e.cells = 0
+	case "p", "table", "dl":
+		e.breakParagraph()
+	case "h1", "h2", "h3", "h4", "h5", "h6":
+		e.breakParagraph()
+		e.markup(strings.Repeat("#", int(name[1]-'0')) + " ")
+	case "br":
+		e.write("\n")
+	case "hr":
+		e.breakParagraph()
+		e.markup("---")
+		e.breakParagraph()
+	case "blockquote":
+		e.breakParagraph()
+		e.quotes++
+	case "pre":
+		e.breakParagraph()
+		e.markup("```\n")
+		e.pre++
+	case "ul", "ol":
+		if len(e.lists) == 0 {
+			e.breakParagraph()
+		}
+		next := -1
+		if name == "ol" {
+			next = 1
+		}
+		e.lists = append(e.lists, next)
+	case "li":
+		e.breakLine()
+		if len(e.lists) == 0 {
+			e.write("- ")
+			break
+		}
+		e.write(strings.Repeat("  ", len(e.lists)-1))
+		if i := len(e.lists) - 1; e.lists[i] > 0 {
+			e.write(strconv.Itoa(e.lists[i]) + ". ")
+			e.lists[i]++
+		} else {
+			e.write("- ")
+		}
+	case "td", "th":
+		if e.cells > 0 {
+			e.write(" | ")
+		}
+		e.cells++
+	case "b", "strong":
+		e.markup("**")
+	case "i", "em":
+		e.markup("_")
+	case "s", "strike", "del":
+		e.markup("~~")
+	case "code":
+		if e.pre == 0 {
+			e.markup("`")
+		}
+	case "a":
+		href := attr(t, "href")
+		e.links = append(e.links, href)
+		if href != "" {
+			e.markup("[")
+		}
+	case "img":
+		if src := attr(t, "src"); src != "" && e.markdown {
+			e.write("![" + attr(t, "alt") + "](" + src + ")")
+		}
+	case "en-todo":
+		if attr(t, "checked") == "true" {
+			e.write("[x] ")
+		} else {
+			e.write("[ ] ")
+		}
+	}
+}
+
+// end converts the end of an element.
+func (e *enml) end(name string) {
+	switch name {
+	case "div", "tr", "li", "dd", "dt":
+		e.breakLine()
+	case "p", "h1", "h2", "h3", "h4", "h5", "h6", "table", "dl":
+		e.breakParagraph()
+	case "blockquote":
+		if e.quotes > 0 {
+			e.quotes--
+		}
+		e.breakParagraph()
+	case "pre":
+		if e.pre > 0 {
+			e.pre--
+		}
+		e.breakLine()
+		e.markup("```")
+		e.breakParagraph()
+	case "ul", "ol":
+		if len(e.lists) > 0 {
+			e.lists = e.lists[:len(e.lists)-1]
+		}
+		if len(e.lists) == 0 {
+			e.breakParagraph()
+		}
+	case "b", "strong":
+		e.markup("**")
+	case "i", "em":
+		e.markup("_")
+	case "s", "strike", "del":
+		e.markup("~~")
+	case "code":
+		if e.pre == 0 {
+			e.markup("`")
+		}
+	case "a":
+		if len(e.links) == 0 {
+			break
+		}
+		href := e.links[len(e.links)-1]
+		e.links = e.links[:len(e.links)-1]
+		if href == "" {
+			break
+		}
+		if e.markdown {
+			e.write("](" + href + ")")
+		} else if !bytes.HasSuffix(e.text, []byte(href)) {
+			e.write(" (" + href + ")")
+		}
+	}
+}
+
+// chars converts text. Whitespace is collapsed outside of pre elements.
+func (e *enml) chars(s string) {
+	if e.pre > 0 {
+		e.write(s)
+		return
+	}
+	s = spaces.ReplaceAllString(s, " ")
+	if e.atLineStart() {
+		s = strings.TrimLeft(s, " ")
+	}
+	if s != "" {
+		e.write(s)
+	}
+}
+
+// markup writes s if converting to Markdown.
+func (e *enml) markup(s string) {
+	if e.markdown {
+		e.write(s)
+	}
+}
+
+// write appends s, starting new lines inside blockquotes with a quote mark.
+func (e *enml) write(s string) {
+	if e.markdown && e.quotes > 0 {
+		prefix := strings.Repeat("> ", e.quotes)
+		if e.atLineStart() {
+			s = prefix + s
+		}
+		s = strings.Replace(s, "\n", "\n"+prefix, -1)
+		if strings.HasSuffix(s, "\n"+prefix) {
+			s = s[:len(s)-len(prefix)]
+		}
+	}
+	e.text = append(e.text, s...)
+}
+
+// breakLine starts a new line unless at the start of one.
+func (e *enml) breakLine() {
+	if !e.atLineStart() {
+		e.text = append(e.text, '\n')
+	}
+}
+
+// breakParagraph starts a new paragraph unless at the start of one.
+func (e *enml) breakParagraph() {
+	e.breakLine()
+	if len(e.text) > 0 && !bytes.HasSuffix(e.text, []byte("\n\n")) {
+		e.text = append(e.text, '\n')
+	}
+}
+
+// atLineStart returns true if nothing was written yet or the text written ends with a new line.
+func (e *enml) atLineStart() bool {
+	return len(e.text) == 0 || e.text[len(e.text)-1] == '\n'
+}
+
+// attr returns the value of an element's attribute or "" if it doesn't have it.
+func attr(t xml.StartElement, name string) string {
+	for _, a := range t.Attr {
+		if a.Name.Local == name {
+			return a.Value
+		}
+	}
+	return ""
+}
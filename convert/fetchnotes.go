@@ -0,0 +1,55 @@
+/*
+This file is part of Tessernote.
+
+Tessernote is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+Tessernote is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with Tessernote.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package convert
+
+import (
+	"encoding/json"
+	"io"
+	"time"
+)
+
+// fetchnotesNote is a note in a Fetchnotes export. Fetchnotes keeps tags as hashtags in the text, tags are only
+// listed separately by some versions.
+type fetchnotesNote struct {
+	Text      string    `json:"text"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+	Tags      []string  `json:"tags"`
+	Archived  bool      `json:"archived"`
+}
+
+// ReadFetchnotes reads a Fetchnotes export, a JSON list of notes. Tags that aren't hashtags in a note's text
+// already are appended.
+func ReadFetchnotes(r io.Reader) ([]Note, error) {
+	var export []fetchnotesNote
+	err := json.NewDecoder(r).Decode(&export)
+	if err != nil {
+		return nil, err
+	}
+	notes := make([]Note, len(export))
+	for i, n := range export {
+		notes[i] = Note{
+			Title:        firstLine(n.Text),
+			Body:         AppendTags(n.Text, n.Tags),
+			Created:      n.CreatedAt,
+			LastModified: n.UpdatedAt,
+			Archived:     n.Archived,
+		}
+	}
+	return notes, nil
+}
@@ -0,0 +1,243 @@
+/*
+This file is part of Tessernote.
+
+Tessernote is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+Tessernote is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with Tessernote.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package convert
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/oschmid/tessernote/hashtag"
+	"html"
+	"net/url"
+	"regexp"
+	"strings"
+)
+
+// Markdown syntax rendered by HTML.
+var (
+	fenceLine    = regexp.MustCompile("^[ \t]*(```|~~~)")
+	quoteLine    = regexp.MustCompile(`^[ \t]*>[ \t]?(.*)$`)
+	ruleLine     = regexp.MustCompile(`^[ \t]*(?:(?:-[ \t]*){3,}|(?:\*[ \t]*){3,}|(?:_[ \t]*){3,})$`)
+	headingLine  = regexp.MustCompile(`^(#{1,6})[ \t]+(.+?)[ \t]*$`)
+	listLine     = regexp.MustCompile(`^[ \t]*([-*+]|[0-9]+[.)])[ \t]+(.*)$`)
+	checkbox     = regexp.MustCompile(`^\[([ xX])\][ \t]+(.*)$`)
+	codeSpan     = regexp.MustCompile("`([^`]+)`")
+	markdownLink = regexp.MustCompile(`\[([^\]]+)\]\(([^()\s]+)\)`)
+	strong       = regexp.MustCompile(`\*\*([^*]+)\*\*`)
+	emphasis     = regexp.MustCompile(`\*([^*\s](?:[^*]*[^*\s])?)\*`)
+)
+
+// htmlWriter renders Markdown blocks as they're read.
+type htmlWriter struct {
+	b      bytes.Buffer
+	tagURL func(name string) string
+	para   []string // lines of the open paragraph
+	list   string   // element of the open list, ul or ol
+}
+
+// HTML returns Markdown text as HTML that is also valid XHTML. It renders the Markdown most notes use: paragraphs
+// (keeping line breaks), headings, lists and checklists, block quotes, fenced code, rules, code spans, links and
+// (strong) emphasis with asterisks. Underscores are left alone because hashtags and URLs use them. Hashtags link
+// to tagURL(name) unless tagURL is nil or returns "".
+func HTML(text string, tagURL func(name string) string) string {
+	h := &htmlWriter{tagURL: tagURL}
+	h.blocks(strings.Split(strings.Replace(text, "\r\n", "\n", -1), "\n"))
+	return h.b.String()
+}
+
+// blocks renders lines as block elements.
+func (h *htmlWriter) blocks(lines []string) {
+	for i := 0; i < len(lines); i++ {
+		line := lines[i]
+		switch {
+		case fenceLine.MatchString(line):
+			h.close()
+			fence := fenceLine.FindStringSubmatch(line)[1]
+			var code []string
+			for i++; i < len(lines) && !strings.HasPrefix(strings.TrimSpace(lines[i]), fence); i++ {
+				code = append(code, lines[i])
+			}
+			h.b.WriteString("<pre><code>" + escape(strings.Join(code, "\n")) + "</code></pre>\n")
+		case strings.TrimSpace(line) == "":
+			h.close()
+		case quoteLine.MatchString(line):
+			h.close()
+			var quoted []string
+			for ; i < len(lines) && quoteLine.MatchString(lines[i]); i++ {
+				quoted = append(quoted, quoteLine.FindStringSubmatch(lines[i])[1])
+			}
+			i--
+			h.b.WriteString("<blockquote>\n")
+			h.blocks(quoted)
+			h.b.WriteString("</blockquote>\n")
+		case ruleLine.MatchString(line):
+			h.close()
+			h.b.WriteString("<hr/>\n")
+		case headingLine.MatchString(line):
+			h.close()
+			match := headingLine.FindStringSubmatch(line)
+			fmt.Fprintf(&h.b, "<h%d>%s</h%d>\n", len(match[1]), h.inline(match[2]), len(match[1]))
+		case listLine.MatchString(line):
+			h.closeParagraph()
+			match := listLine.FindStringSubmatch(line)
+			list := "ul"
+			if match[1][0] >= '0' && match[1][0] <= '9' {
+				list = "ol"
+			}
+			if h.list != list {
+				h.closeList()
+				h.b.WriteString("<" + list + ">\n")
+				h.list = list
+			}
+			h.b.WriteString("<li>" + h.item(match[2]) + "</li>\n")
+		default:
+			h.closeList()
+			h.para = append(h.para, strings.TrimSpace(line))
+		}
+	}
+	h.close()
+}
+
+// item renders the text of a list item, which may start with a checkbox.
+func (h *htmlWriter) item(text string) string {
+	match := checkbox.FindStringSubmatch(text)
+	if match == nil {
+		return h.inline(text)
+	}
+	input := `<input type="checkbox" disabled="disabled"/> `
+	if match[1] != " " {
+		input = `<input type="checkbox" disabled="disabled" checked="checked"/> `
+	}
+	return input + h.inline(match[2])
+}
+
+// close ends the open paragraph or list.
+func (h *htmlWriter) close() {
+	h.closeParagraph()
+	h.closeList()
+}
+
+func (h *htmlWriter) closeParagraph() {
+	if len(h.para) == 0 {
+		return
+	}
+	lines := make([]string, len(h.para))
+	for i, line := range h.para {
+		lines[i] = h.inline(line)
+	}
+	h.b.WriteString("<p>" + strings.Join(lines, "<br/>\n") + "</p>\n")
+	h.para = nil
+}
+
+func (h *htmlWriter) closeList() {
+	if h.list != "" {
+		h.b.WriteString("</" + h.list + ">\n")
+		h.list = ""
+	}
+}
+
+// inline renders code spans and the text between them.
+func (h *htmlWriter) inline(text string) string {
+	var b bytes.Buffer
+	last := 0
+	for _, match := range codeSpan.FindAllStringSubmatchIndex(text, -1) {
+		b.WriteString(h.links(text[last:match[0]]))
+		b.WriteString("<code>" + escape(text[match[2]:match[3]]) + "</code>")
+		last = match[1]
+	}
+	b.WriteString(h.links(text[last:]))
+	return b.String()
+}
+
+// links renders links and bare URLs and the text between them. Links to URLs with schemes other than http, https,
+// mailto and ftp are rendered as text.
+func (h *htmlWriter) links(text string) string {
+	var b bytes.Buffer
+	for {
+		link := markdownLink.FindStringSubmatchIndex(text)
+		bare := hashtag.URLRegex.FindStringIndex(text)
+		if link != nil && (bare == nil || link[0] <= bare[0]) {
+			b.WriteString(h.text(text[:link[0]]))
+			label, target := text[link[2]:link[3]], text[link[4]:link[5]]
+			if safeURL(target) {
+				b.WriteString(`<a href="` + escape(target) + `">` + h.text(label) + "</a>")
+			} else {
+				b.WriteString(h.text(text[link[0]:link[1]]))
+			}
+			text = text[link[1]:]
+		} else if bare != nil {
+			b.WriteString(h.text(text[:bare[0]]))
+			target := strings.TrimRight(text[bare[0]:bare[1]], ".,;:!?)'")
+			if target == "" {
+				target = text[bare[0]:bare[1]]
+			}
+			href := target
+			if !strings.Contains(href, "://") {
+				href = "http://" + href
+			}
+			b.WriteString(`<a href="` + escape(href) + `">` + escape(target) + "</a>")
+			text = text[bare[0]+len(target):]
+		} else {
+			break
+		}
+	}
+	b.WriteString(h.text(text))
+	return b.String()
+}
+
+// text renders hashtags and emphasis.
+func (h *htmlWriter) text(text string) string {
+	var b bytes.Buffer
+	last := 0
+	for _, span := range hashtag.Find(text) {
+		b.WriteString(emphasize(escape(text[last:span.Start])))
+		tag := escape(text[span.Start:span.End])
+		if h.tagURL != nil {
+			if href := h.tagURL(span.Name); href != "" {
+				tag = `<a class="tag" href="` + escape(href) + `">` + tag + "</a>"
+			}
+		}
+		b.WriteString(tag)
+		last = span.End
+	}
+	b.WriteString(emphasize(escape(text[last:])))
+	return b.String()
+}
+
+// emphasize renders emphasis in escaped text.
+func emphasize(text string) string {
+	text = strong.ReplaceAllString(text, "<strong>$1</strong>")
+	return emphasis.ReplaceAllString(text, "<em>$1</em>")
+}
+
+// safeURL returns true if target is relative or uses a scheme that can't run scripts.
+func safeURL(target string) bool {
+	u, err := url.Parse(target)
+	if err != nil {
+		return false
+	}
+	switch strings.ToLower(u.Scheme) {
+	case "", "http", "https", "mailto", "ftp":
+		return true
+	}
+	return false
+}
+
+// escape escapes text for HTML and XML.
+func escape(text string) string {
+	return html.EscapeString(text)
+}
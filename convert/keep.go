@@ -0,0 +1,188 @@
+/*
+This file is part of Tessernote.
+
+Tessernote is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+Tessernote is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with Tessernote.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package convert
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"io"
+	"strings"
+	"time"
+)
+
+// keepTimeFormat is the format of the edit time in Google Keep's HTML export.
+const keepTimeFormat = "Jan 2, 2006, 3:04:05 PM"
+
+// keepNote is a note in Google Keep's JSON export (one file per note).
+type keepNote struct {
+	Title                   string         `json:"title"`
+	TextContent             string         `json:"textContent"`
+	ListContent             []keepListItem `json:"listContent"`
+	Labels                  []keepLabel    `json:"labels"`
+	IsPinned                bool           `json:"isPinned"`
+	IsArchived              bool           `json:"isArchived"`
+	IsTrashed               bool           `json:"isTrashed"`
+	CreatedTimestampUsec    int64          `json:"createdTimestampUsec"`
+	UserEditedTimestampUsec int64          `json:"userEditedTimestampUsec"`
+}
+
+type keepLabel struct {
+	Name string `json:"name"`
+}
+
+type keepListItem struct {
+	Text      string `json:"text"`
+	IsChecked bool   `json:"isChecked"`
+}
+
+// ReadKeepJSON reads a note from Google Keep's JSON export (Takeout), which has one file per note. Labels are
+// appended as hashtags and checklists are converted to Markdown task lists. Trashed notes are left out, i.e. no
+// Notes are returned.
+func ReadKeepJSON(r io.Reader) ([]Note, error) {
+	var n keepNote
+	err := json.NewDecoder(r).Decode(&n)
+	if err != nil {
+		return nil, err
+	}
+	if n.IsTrashed {
+		return nil, nil
+	}
+	body := n.TextContent
+	if len(n.ListContent) > 0 {
+		items, checked := make([]string, len(n.ListContent)), make([]bool, len(n.ListContent))
+		for i, item := range n.ListContent {
+			items[i], checked[i] = item.Text, item.IsChecked
+		}
+		body = checklist(items, checked)
+	}
+	labels := make([]string, len(n.Labels))
+	for i, label := range n.Labels {
+		labels[i] = label.Name
+	}
+	note := Note{
+		Title:        n.Title,
+		Body:         AppendTags(withTitle(n.Title, body, false), labels),
+		Created:      usec(n.CreatedTimestampUsec),
+		LastModified: usec(n.UserEditedTimestampUsec),
+		Pinned:       n.IsPinned,
+		Archived:     n.IsArchived,
+	}
+	if note.Created.IsZero() {
+		note.Created = note.LastModified
+	}
+	return []Note{note}, nil
+}
+
+// usec converts microseconds since the Unix epoch to a time, 0 to the zero time.
+func usec(t int64) time.Time {
+	if t == 0 {
+		return time.Time{}
+	}
+	return time.Unix(t/1e6, t%1e6*1e3).UTC()
+}
+
+// ReadKeepHTML reads a note from Google Keep's older HTML export (Takeout), which has one file per note. It only
+// has the time a note was last edited, in UTC. Labels are appended as hashtags and checklists are converted to
+// Markdown task lists. Trashed notes are left out.
+func ReadKeepHTML(r io.Reader) ([]Note, error) {
+	decoder := xml.NewDecoder(r)
+	decoder.Strict = false
+	decoder.AutoClose = xml.HTMLAutoClose
+	decoder.Entity = xml.HTMLEntity
+	var note Note
+	var heading, content, labels []string
+	var items []string
+	var checked []bool
+	var trashed bool
+	var classes []string // of open elements
+	in := func(class string) bool {
+		for _, c := range classes {
+			if c == class {
+				return true
+			}
+		}
+		return false
+	}
+	for {
+		token, err := decoder.Token()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, err
+		}
+		switch t := token.(type) {
+		case xml.StartElement:
+			if t.Name.Local == "br" && in("content") {
+				content = append(content, "\n")
+			}
+			class := strings.Fields(attr(t, "class"))
+			if len(class) == 0 {
+				class = []string{""}
+			}
+			switch class[0] {
+			case "archived":
+				note.Archived = true
+			case "pinned":
+				note.Pinned = true
+			case "trashed":
+				trashed = true
+			case "listitem":
+				items = append(items, "")
+				checked = append(checked, len(class) > 1 && class[1] == "checked")
+			}
+			classes = append(classes, class[0])
+		case xml.EndElement:
+			if len(classes) > 0 {
+				classes = classes[:len(classes)-1]
+			}
+		case xml.CharData:
+			text := string(t)
+			switch {
+			case in("text") && in("listitem"):
+				items[len(items)-1] += strings.TrimSpace(text)
+			case in("bullet"):
+			case in("label-name"):
+				labels = append(labels, strings.TrimSpace(text))
+			case in("title"):
+				note.Title += strings.TrimSpace(text)
+			case in("content") && !in("listitem"):
+				content = append(content, text)
+			case in("heading"):
+				heading = append(heading, text)
+			}
+		}
+	}
+	if trashed {
+		return nil, nil
+	}
+	edited := strings.TrimSpace(strings.Join(heading, " "))
+	if edited != "" {
+		var err error
+		note.LastModified, err = time.Parse(keepTimeFormat, edited)
+		if err != nil {
+			return nil, &NoteError{note.Title, err}
+		}
+		note.Created = note.LastModified
+	}
+	body := strings.TrimSpace(strings.Join(content, ""))
+	if len(items) > 0 {
+		body = strings.TrimSpace(body + "\n" + checklist(items, checked))
+	}
+	note.Body = AppendTags(withTitle(note.Title, body, false), labels)
+	return []Note{note}, nil
+}
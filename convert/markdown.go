@@ -0,0 +1,289 @@
+/*
+This file is part of Tessernote.
+
+Tessernote is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+Tessernote is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with Tessernote.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package convert
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/oschmid/tessernote/hashtag"
+	"io"
+	"io/ioutil"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// A Markdown archive is a zip file with a Markdown file for every note in notes/ and an index of the notes of
+// every tag in tags/. Note files start with YAML front matter:
+//
+//	---
+//	id: "ahF0ZXNzZXJub3RlLWV4YW1wbGVyCgsSBE5vdGUYAQw"
+//	created: 2013-05-01T12:00:00Z
+//	modified: 2013-05-02T08:30:00Z
+//	tags: ["go", "status:done"]
+//	pinned: true
+//	---
+//	body #go #status:done
+//
+// The body follows the front matter unchanged so notes can be imported again without loss. The tags are only
+// listed for other tools, a note's tags are its hashtags.
+const (
+	markdownNotesDir = "notes/"
+	markdownTagsDir  = "tags/"
+	frontMatterLine  = "---"
+)
+
+var ErrNoFrontMatter = errors.New("convert: missing front matter")
+
+// WriteMarkdownArchive writes notes as a Markdown archive to w.
+func WriteMarkdownArchive(w io.Writer, notes []Note) error {
+	archive := zip.NewWriter(w)
+	tags := make(map[string][]int) // folded name -> indexes of notes
+	names := make(map[string]string)
+	for i, note := range notes {
+		name := markdownNoteFile(note, i)
+		f, err := archive.CreateHeader(&zip.FileHeader{Name: name, Method: zip.Deflate, Modified: note.LastModified})
+		if err != nil {
+			return err
+		}
+		_, err = f.Write(MarshalMarkdown(note))
+		if err != nil {
+			return err
+		}
+		for _, tag := range TagNames(note.Body) {
+			folded := hashtag.Fold(tag)
+			if _, ok := names[folded]; !ok {
+				names[folded] = tag
+			}
+			tags[folded] = append(tags[folded], i)
+		}
+	}
+	folded := make([]string, 0, len(tags))
+	for tag := range tags {
+		folded = append(folded, tag)
+	}
+	sort.Strings(folded)
+	for _, tag := range folded {
+		f, err := archive.Create(markdownTagsDir + TagFile(names[tag], ".md"))
+		if err != nil {
+			return err
+		}
+		index := []string{"# " + names[tag], ""}
+		for _, i := range tags[tag] {
+			title := strings.TrimLeft(firstLine(notes[i].Body), "# ")
+			index = append(index, fmt.Sprintf("- [%s](../%s)", title, markdownNoteFile(notes[i], i)))
+		}
+		_, err = io.WriteString(f, strings.Join(index, "\n")+"\n")
+		if err != nil {
+			return err
+		}
+	}
+	return archive.Close()
+}
+
+// markdownNoteFile returns the path of a note in a Markdown archive. IDs are URL safe base64 so they can be used
+// as file names, notes without one are numbered.
+func markdownNoteFile(note Note, i int) string {
+	if note.ID != "" {
+		return markdownNotesDir + note.ID + ".md"
+	}
+	return markdownNotesDir + strconv.Itoa(i+1) + ".md"
+}
+
+// TagFile returns the name of a file for a tag, e.g. its index in a Markdown archive, with extension ext. The value
+// separator is replaced because some file systems don't allow it.
+func TagFile(name, ext string) string {
+	return strings.Replace(name, hashtag.ValueSeparator, "=", -1) + ext
+}
+
+// TagNames returns the names of the hashtags in text, leaving out other spellings of the same tag.
+func TagNames(text string) []string {
+	var names []string
+	seen := make(map[string]bool)
+	for _, name := range hashtag.Names(text) {
+		if folded := hashtag.Fold(name); !seen[folded] {
+			seen[folded] = true
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+// MarshalMarkdown returns note as Markdown with YAML front matter.
+func MarshalMarkdown(note Note) []byte {
+	var b bytes.Buffer
+	b.WriteString(frontMatterLine + "\n")
+	if note.ID != "" {
+		fmt.Fprintf(&b, "id: %s\n", strconv.Quote(note.ID))
+	}
+	if !note.Created.IsZero() {
+		fmt.Fprintf(&b, "created: %s\n", note.Created.UTC().Format(time.RFC3339Nano))
+	}
+	if !note.LastModified.IsZero() {
+		fmt.Fprintf(&b, "modified: %s\n", note.LastModified.UTC().Format(time.RFC3339Nano))
+	}
+	if tags := TagNames(note.Body); len(tags) > 0 {
+		quoted := make([]string, len(tags))
+		for i, tag := range tags {
+			quoted[i] = strconv.Quote(tag)
+		}
+		fmt.Fprintf(&b, "tags: [%s]\n", strings.Join(quoted, ", "))
+	}
+	if note.Pinned {
+		b.WriteString("pinned: true\n")
+	}
+	if note.Archived {
+		b.WriteString("archived: true\n")
+	}
+	b.WriteString(frontMatterLine + "\n")
+	b.WriteString(note.Body)
+	return b.Bytes()
+}
+
+// UnmarshalMarkdown reads a note written by MarshalMarkdown. Tags listed in the front matter that aren't hashtags
+// in the body (e.g. because they were added by another tool) are appended.
+func UnmarshalMarkdown(data []byte) (Note, error) {
+	var note Note
+	text := strings.Replace(string(data), "\r\n", "\n", -1)
+	if !strings.HasPrefix(text, frontMatterLine+"\n") {
+		return note, ErrNoFrontMatter
+	}
+	text = text[len(frontMatterLine):] // keep the new line so an empty front matter ends with "\n---"
+	end := strings.Index(text, "\n"+frontMatterLine+"\n")
+	if end < 0 {
+		if !strings.HasSuffix(text, "\n"+frontMatterLine) {
+			return note, ErrNoFrontMatter
+		}
+		end = len(text) - len(frontMatterLine) - 1
+	}
+	front, body := text[:end], ""
+	if start := end + len(frontMatterLine) + 2; start < len(text) {
+		body = text[start:]
+	}
+	var tags []string
+	lines := strings.Split(front, "\n")
+	for i := 0; i < len(lines); i++ {
+		colon := strings.Index(lines[i], ":")
+		if colon < 0 || strings.HasPrefix(lines[i], " ") {
+			continue
+		}
+		key, value := strings.TrimSpace(lines[i][:colon]), strings.TrimSpace(lines[i][colon+1:])
+		var err error
+		switch key {
+		case "id":
+			note.ID = yamlString(value)
+		case "created":
+			note.Created, err = time.Parse(time.RFC3339Nano, yamlString(value))
+		case "modified":
+			note.LastModified, err = time.Parse(time.RFC3339Nano, yamlString(value))
+		case "pinned":
+			note.Pinned = value == "true"
+		case "archived":
+			note.Archived = value == "true"
+		case "tags":
+			if value != "" {
+				tags = yamlFlowList(value)
+				break
+			}
+			for ; i+1 < len(lines) && strings.HasPrefix(strings.TrimSpace(lines[i+1]), "- "); i++ {
+				tags = append(tags, yamlString(strings.TrimSpace(lines[i+1])[2:]))
+			}
+		}
+		if err != nil {
+			return note, fmt.Errorf("front matter %s: %s", key, err)
+		}
+	}
+	note.Title = strings.TrimLeft(firstLine(body), "# ")
+	note.Body = AppendTags(body, tags)
+	return note, nil
+}
+
+// yamlString returns the value of a YAML scalar, removing quotes.
+func yamlString(value string) string {
+	if strings.HasPrefix(value, `"`) {
+		if s, err := strconv.Unquote(value); err == nil {
+			return s
+		}
+	}
+	if len(value) >= 2 && strings.HasPrefix(value, "'") && strings.HasSuffix(value, "'") {
+		return strings.Replace(value[1:len(value)-1], "''", "'", -1)
+	}
+	return value
+}
+
+// yamlFlowList returns the values of a YAML flow sequence, e.g. [a, "b"].
+func yamlFlowList(value string) []string {
+	var list []string
+	if json.Unmarshal([]byte(value), &list) == nil {
+		return list
+	}
+	value = strings.TrimSuffix(strings.TrimPrefix(value, "["), "]")
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			list = append(list, yamlString(item))
+		}
+	}
+	return list
+}
+
+// ReadMarkdown reads a single note written by MarshalMarkdown.
+func ReadMarkdown(r io.Reader) ([]Note, error) {
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	note, err := UnmarshalMarkdown(data)
+	if err != nil {
+		return nil, err
+	}
+	return []Note{note}, nil
+}
+
+// ReadMarkdownArchive reads the notes of a Markdown archive of size bytes. Tag indexes are ignored.
+func ReadMarkdownArchive(r io.ReaderAt, size int64) ([]Note, error) {
+	archive, err := zip.NewReader(r, size)
+	if err != nil {
+		return nil, err
+	}
+	var notes []Note
+	for _, f := range archive.File {
+		if !strings.HasPrefix(f.Name, markdownNotesDir) || path.Ext(f.Name) != ".md" {
+			continue
+		}
+		rc, err := f.Open()
+		if err != nil {
+			return notes, err
+		}
+		data, err := ioutil.ReadAll(rc)
+		rc.Close()
+		if err != nil {
+			return notes, err
+		}
+		note, err := UnmarshalMarkdown(data)
+		if err != nil {
+			return notes, fmt.Errorf("%s: %s", f.Name, err)
+		}
+		notes = append(notes, note)
+	}
+	return notes, nil
+}
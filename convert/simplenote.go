@@ -0,0 +1,59 @@
+/*
+This file is part of Tessernote.
+
+Tessernote is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+Tessernote is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with Tessernote.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package convert
+
+import (
+	"encoding/json"
+	"io"
+	"time"
+)
+
+// simplenoteExport is the notes.json file of a Simplenote export.
+type simplenoteExport struct {
+	ActiveNotes  []simplenoteNote `json:"activeNotes"`
+	TrashedNotes []simplenoteNote `json:"trashedNotes"`
+}
+
+type simplenoteNote struct {
+	Content      string    `json:"content"`
+	CreationDate time.Time `json:"creationDate"`
+	LastModified time.Time `json:"lastModified"`
+	Tags         []string  `json:"tags"`
+	Pinned       bool      `json:"pinned"`
+}
+
+// ReadSimplenote reads the notes.json file of a Simplenote export. Its tags are appended as hashtags. Trashed
+// notes are left out.
+func ReadSimplenote(r io.Reader) ([]Note, error) {
+	var export simplenoteExport
+	err := json.NewDecoder(r).Decode(&export)
+	if err != nil {
+		return nil, err
+	}
+	notes := make([]Note, len(export.ActiveNotes))
+	for i, n := range export.ActiveNotes {
+		notes[i] = Note{
+			Title:        firstLine(n.Content),
+			Body:         AppendTags(n.Content, n.Tags),
+			Created:      n.CreationDate,
+			LastModified: n.LastModified,
+			Pinned:       n.Pinned,
+		}
+	}
+	return notes, nil
+}
@@ -0,0 +1,117 @@
+/*
+This file is part of Tessernote.
+
+Tessernote is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+Tessernote is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with Tessernote.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package tessernote
+
+import (
+	"appengine"
+	"appengine/datastore"
+	"github.com/oschmid/tessernote/graph"
+)
+
+// tagGraphKey returns the datastore.Key of this Notebook's tag co-occurrence graph.
+func (notebook Notebook) tagGraphKey(c appengine.Context) *datastore.Key {
+	return datastore.NewKey(c, "TagGraph", "", 1, notebook.Key(c))
+}
+
+// TagGraph returns the co-occurrence graph of this Notebook's Tags. Its labels are encoded Tag Keys so they don't
+// change when a Tag is renamed, see TagName. If it doesn't exist yet it is built from all Notes, see buildModel.
+func (notebook *Notebook) TagGraph(c appengine.Context) (*graph.Graph, error) {
+	if notebook.graph != nil {
+		return notebook.graph, nil
+	}
+	g := graph.New()
+	err := notebook.readModel(notebook.tagGraphModel(g, c), c)
+	if err == nil {
+		notebook.graph = g
+		return g, nil
+	}
+	if err != datastore.ErrNoSuchEntity {
+		c.Errorf("getting tag graph: %s", err)
+		return nil, err
+	}
+	g = graph.New()
+	err = notebook.buildModel(notebook.tagGraphKey(c), g, func(note Note) {
+		g.Add(noteClasses(note))
+	}, c)
+	if err == errStaleModel {
+		return g, nil // good enough for now, but not to be updated
+	} else if err != nil {
+		c.Errorf("building tag graph: %s", err)
+		return nil, err
+	}
+	notebook.graph = g
+	return g, nil
+}
+
+// TagName returns the name of the Tag with an encoded Key, or "" if there is none.
+func (notebook *Notebook) TagName(encodedKey string, c appengine.Context) (string, error) {
+	allTags, err := notebook.lazyTags(c)
+	if err != nil {
+		return "", err
+	}
+	key, err := datastore.DecodeKey(encodedKey)
+	if err != nil {
+		return "", nil
+	}
+	i := notebook.indexOfTagKey(key)
+	if i < 0 {
+		return "", nil
+	}
+	return allTags[i].Name, nil
+}
+
+// RelatedTagStrengths returns how strongly each Tag related to tags is related to them by name. The strength is
+// the highest Jaccard score of a Tag with any of tags.
+func (notebook *Notebook) RelatedTagStrengths(tags []Tag, c appengine.Context) (map[string]float64, error) {
+	g, err := notebook.TagGraph(c)
+	if err != nil {
+		return nil, err
+	}
+	allTags, err := notebook.lazyTags(c)
+	if err != nil {
+		return nil, err
+	}
+	strengths := make(map[string]float64)
+	for _, tag := range tags {
+		i := indexOfTag(allTags, tag.Name)
+		if i < 0 {
+			continue
+		}
+		for _, edge := range g.Neighbours(notebook.TagKeys[i].Encode()) {
+			key, err := datastore.DecodeKey(edge.To)
+			if err != nil {
+				continue
+			}
+			j := notebook.indexOfTagKey(key)
+			if j >= 0 && edge.Jaccard > strengths[allTags[j].Name] {
+				strengths[allTags[j].Name] = edge.Jaccard
+			}
+		}
+	}
+	return strengths, nil
+}
+
+// tagGraphModel returns how g is stored and updated as this Notebook's tag co-occurrence graph.
+func (notebook *Notebook) tagGraphModel(g *graph.Graph, c appengine.Context) learnedModel {
+	return learnedModel{notebook.tagGraphKey(c), g, func(change modelChange) {
+		if len(change.OldClasses) > 0 || len(change.Classes) > 0 {
+			g.Remove(change.OldClasses)
+			g.Add(change.Classes)
+		}
+	}}
+}
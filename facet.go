@@ -0,0 +1,67 @@
+/*
+This file is part of Tessernote.
+
+Tessernote is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+Tessernote is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with Tessernote.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package tessernote
+
+import (
+	"appengine"
+	"github.com/oschmid/tessernote/hashtag"
+)
+
+// Facet counts the Notes of a Tag for drilling down from a selection of Tags.
+type Facet struct {
+	Count   int // Notes with the Tag
+	Refined int // Notes that would still match if the Tag were added to the selection
+}
+
+// TagFacets returns the Facets of every Tag in this Notebook by name for the selection groups (see MatchingNotes).
+// Key-value Tags are also counted together under their key and AnyValue (e.g. status:*). Only the Note Keys of Tags
+// are compared, Notes aren't read.
+func (notebook *Notebook) TagFacets(groups [][]Tag, c appengine.Context) (map[string]Facet, error) {
+	allTags, err := notebook.Tags(c)
+	if err != nil {
+		return nil, err
+	}
+	matching := matchingNoteKeys(groups)
+	facets := make(map[string]Facet, len(allTags))
+	keyNotes := make(map[string]*keySet) // key:* -> Note Keys
+	for i := range allTags {
+		tag := &allTags[i]
+		facets[tag.Name] = refine(tag.noteKeySet(), matching)
+		if key, value := hashtag.SplitValue(tag.Name); value != "" {
+			name := key + hashtag.ValueSeparator + AnyValue
+			if keyNotes[name] == nil {
+				keyNotes[name] = newKeySet(nil)
+			}
+			keyNotes[name].Union(tag.noteKeySet())
+		}
+	}
+	for name, notes := range keyNotes {
+		facets[name] = refine(notes, matching)
+	}
+	return facets, nil
+}
+
+// refine returns the Facet of a Tag with noteKeys. matching holds the Notes matching the selection, or is nil if
+// nothing is selected.
+func refine(noteKeys, matching *keySet) Facet {
+	facet := Facet{Count: noteKeys.Len(), Refined: noteKeys.Len()}
+	if matching != nil {
+		facet.Refined = noteKeys.Intersect(matching).Len()
+	}
+	return facet
+}
@@ -0,0 +1,69 @@
+/*
+This file is part of Tessernote.
+
+Tessernote is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+Tessernote is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with Tessernote.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package tessernote
+
+import (
+	"github.com/oschmid/appenginetesting"
+	"testing"
+)
+
+func TestTagFacets(t *testing.T) {
+	c, err := appenginetesting.NewContext(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	notebook, _ := newTestNotebook(t, c, "facets",
+		"a #go #status:done", "b #go #status:open", "c #web #status:done", "d #go #web", "e")
+
+	tests := []struct {
+		selectors []string
+		facets    map[string]Facet
+	}{
+		{nil, map[string]Facet{
+			"go": {3, 3}, "web": {2, 2}, "status:done": {2, 2}, "status:open": {1, 1}, "status:*": {3, 3},
+		}},
+		{[]string{"go"}, map[string]Facet{
+			"go": {3, 3}, "web": {2, 1}, "status:done": {2, 1}, "status:open": {1, 1}, "status:*": {3, 2},
+		}},
+		{[]string{"go", "status:*"}, map[string]Facet{
+			"go": {3, 2}, "web": {2, 0}, "status:done": {2, 1}, "status:open": {1, 1}, "status:*": {3, 2},
+		}},
+		{[]string{"web", "status:done"}, map[string]Facet{
+			"go": {3, 0}, "web": {2, 1}, "status:done": {2, 1}, "status:open": {1, 0}, "status:*": {3, 1},
+		}},
+	}
+	for _, test := range tests {
+		groups, err := notebook.TagsMatching(test.selectors, c)
+		if err != nil {
+			t.Fatal(err)
+		}
+		facets, err := notebook.TagFacets(groups, c)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(facets) != len(test.facets) {
+			t.Errorf("%v: expected=%v actual=%v", test.selectors, test.facets, facets)
+		}
+		for name, facet := range test.facets {
+			if facets[name] != facet {
+				t.Errorf("%v: %s expected=%+v actual=%+v", test.selectors, name, facet, facets[name])
+			}
+		}
+	}
+}
@@ -0,0 +1,330 @@
+/*
+This file is part of Tessernote.
+
+Tessernote is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+Tessernote is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with Tessernote.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package tessernote
+
+import (
+	"appengine"
+	"appengine/datastore"
+	"github.com/oschmid/tessernote/hashtag"
+)
+
+// Kinds of Problems found by Check.
+const (
+	ProblemMissingNote   = "missing note"    // NoteKeys refers to a Note that doesn't exist
+	ProblemUnlistedNote  = "unlisted note"   // a Note of this Notebook isn't in NoteKeys
+	ProblemMissingTag    = "missing tag"     // TagKeys refers to a Tag that doesn't exist, or a hashtag has no Tag
+	ProblemUnlistedTag   = "unlisted tag"    // a Tag of this Notebook isn't in TagKeys
+	ProblemDuplicateTag  = "duplicate tag"   // two Tags have the same folded name
+	ProblemEmptyTag      = "empty tag"       // a Tag isn't used by any Note
+	ProblemTagMissesNote = "tag misses note" // a Tag doesn't refer to a Note with its hashtag
+	ProblemTagHasNote    = "tag has note"    // a Tag refers to a Note without its hashtag
+	ProblemNoteTags      = "note tags"       // a Note's TagKeys don't match its hashtags
+	ProblemUntagged      = "untagged"        // UntaggedNoteKeys misses a Note without hashtags or has another Note
+	ProblemSpellings     = "spellings"       // a Tag's spellings don't match the hashtags of its Notes
+)
+
+// fsckBatchSize is the number of entities written per transaction when repairing.
+const fsckBatchSize = 100
+
+// Problem is an inconsistency between a Notebook, its Tags and its Notes.
+type Problem struct {
+	Kind string
+	Tag  string `json:",omitempty"` // name, or encoded Key of a missing Tag
+	Note string `json:",omitempty"` // ID
+}
+
+// Check re-parses the body of every Note in this Notebook and compares the Tags each should have with the Keys
+// stored in this Notebook, its Tags and its Notes. If repair is true the differences are fixed in batched
+// transactions and the models learned from the Notes are rebuilt.
+func (notebook *Notebook) Check(repair bool, c appengine.Context) ([]Problem, error) {
+	notebookKey := notebook.Key(c)
+	var notes []Note
+	noteKeys, err := datastore.NewQuery("Note").Ancestor(notebookKey).GetAll(c, &notes)
+	if err != nil {
+		c.Errorf("getting notes: %s", err)
+		return nil, err
+	}
+	var tags []Tag
+	tagKeys, err := datastore.NewQuery("Tag").Ancestor(notebookKey).GetAll(c, &tags)
+	if err != nil {
+		c.Errorf("getting tags: %s", err)
+		return nil, err
+	}
+	err = loadTagKeyShards(tagKeys, tags, c)
+	if err != nil {
+		return nil, err
+	}
+	var problems []Problem
+
+	// notebook lists
+	existingNotes, listedNotes := newKeySet(noteKeys), newKeySet(notebook.NoteKeys)
+	for _, key := range notebook.NoteKeys {
+		if !existingNotes.Contains(key) {
+			problems = append(problems, Problem{Kind: ProblemMissingNote, Note: key.Encode()})
+		}
+	}
+	for _, key := range noteKeys {
+		if !listedNotes.Contains(key) {
+			problems = append(problems, Problem{Kind: ProblemUnlistedNote, Note: key.Encode()})
+		}
+	}
+	existingTags, listedTags := newKeySet(tagKeys), newKeySet(notebook.TagKeys)
+	for _, key := range notebook.TagKeys {
+		if !existingTags.Contains(key) {
+			problems = append(problems, Problem{Kind: ProblemMissingTag, Tag: key.Encode()})
+		}
+	}
+	for i, key := range tagKeys {
+		if !listedTags.Contains(key) {
+			problems = append(problems, Problem{Kind: ProblemUnlistedTag, Tag: tags[i].Name})
+		}
+	}
+
+	// one Tag per folded name
+	byName := make(map[string]int) // folded name -> index in tags
+	var duplicates []int
+	for i := range tags {
+		name := tags[i].foldedName()
+		if _, ok := byName[name]; ok {
+			problems = append(problems, Problem{Kind: ProblemDuplicateTag, Tag: tags[i].Name})
+			duplicates = append(duplicates, i)
+		} else {
+			byName[name] = i
+		}
+	}
+
+	// expected tags of every note
+	expectedNotes := make([]*keySet, len(tags)) // Note Keys expected for each Tag
+	spellings := make([]map[string]int, len(tags))
+	noteTags := make([][]int, len(notes)) // indexes in tags of the Tags expected for each Note
+	for i, note := range notes {
+		names := notebook.parseTagNames(note.Body)
+		for j, name := range names {
+			if containsTagName(names[:j], name) {
+				continue
+			}
+			k, ok := byName[hashtag.Fold(name)]
+			if !ok {
+				problems = append(problems, Problem{Kind: ProblemMissingTag, Tag: name})
+				k = len(tags)
+				byName[hashtag.Fold(name)] = k
+				tags = append(tags, Tag{FoldedName: hashtag.Fold(name), NotebookKeys: []*datastore.Key{notebookKey}})
+				tagKeys = append(tagKeys, datastore.NewIncompleteKey(c, "Tag", notebookKey))
+				expectedNotes = append(expectedNotes, nil)
+				spellings = append(spellings, nil)
+			}
+			if expectedNotes[k] == nil {
+				expectedNotes[k], spellings[k] = newKeySet(nil), make(map[string]int)
+			}
+			expectedNotes[k].Add(noteKeys[i])
+			spellings[k][name]++
+			noteTags[i] = append(noteTags[i], k)
+		}
+	}
+
+	// compare tags
+	var changedTags, emptyTags []int
+	for i := range tags {
+		if containsInt(duplicates, i) {
+			continue
+		}
+		if expectedNotes[i] == nil {
+			problems = append(problems, Problem{Kind: ProblemEmptyTag, Tag: tags[i].Name})
+			emptyTags = append(emptyTags, i)
+			continue
+		}
+		changed := tagKeys[i].Incomplete()
+		actual := tags[i].noteKeySet()
+		for _, key := range actual.Keys() {
+			if !expectedNotes[i].Contains(key) {
+				problems = append(problems, Problem{Kind: ProblemTagHasNote, Tag: tags[i].Name, Note: key.Encode()})
+				changed = true
+			}
+		}
+		for _, key := range expectedNotes[i].Keys() {
+			if !actual.Contains(key) && !tagKeys[i].Incomplete() {
+				problems = append(problems, Problem{Kind: ProblemTagMissesNote, Tag: tags[i].Name, Note: key.Encode()})
+				changed = true
+			}
+		}
+		if !sameSpellings(tags[i], spellings[i]) {
+			if !tagKeys[i].Incomplete() {
+				problems = append(problems, Problem{Kind: ProblemSpellings, Tag: tags[i].Name})
+			}
+			changed = true
+		}
+		if changed || !listedTags.Contains(tagKeys[i]) {
+			changedTags = append(changedTags, i)
+		}
+	}
+
+	// compare notes
+	tagIndexes := make(map[string]int) // encoded Key -> index in tags
+	for i, key := range tagKeys {
+		if !key.Incomplete() {
+			tagIndexes[key.Encode()] = i
+		}
+	}
+	var changedNotes []int
+	var untagged []*datastore.Key
+	listedUntagged := newKeySet(notebook.UntaggedNoteKeys)
+	for i, note := range notes {
+		if !sameTags(note.TagKeys, noteTags[i], tagIndexes) {
+			problems = append(problems, Problem{Kind: ProblemNoteTags, Note: noteKeys[i].Encode()})
+			changedNotes = append(changedNotes, i)
+		}
+		if len(noteTags[i]) == 0 {
+			untagged = append(untagged, noteKeys[i])
+			if !listedUntagged.Contains(noteKeys[i]) {
+				problems = append(problems, Problem{Kind: ProblemUntagged, Note: noteKeys[i].Encode()})
+			}
+		} else if listedUntagged.Contains(noteKeys[i]) {
+			problems = append(problems, Problem{Kind: ProblemUntagged, Note: noteKeys[i].Encode()})
+		}
+	}
+	for _, key := range notebook.UntaggedNoteKeys {
+		if !existingNotes.Contains(key) {
+			problems = append(problems, Problem{Kind: ProblemUntagged, Note: key.Encode()})
+		}
+	}
+
+	if !repair || len(problems) == 0 {
+		return problems, nil
+	}
+	if Debug {
+		c.Debugf("repairing notebook: %d problems", len(problems))
+	}
+
+	// repair tags first so new Tags have Keys for the notes
+	for i := range changedTags {
+		j := changedTags[i]
+		tags[j].NoteKeys = expectedNotes[j].Keys()
+		tags[j].Spellings, tags[j].SpellingCounts = nil, nil
+		for spelling, count := range spellings[j] {
+			tags[j].Spellings = append(tags[j].Spellings, spelling)
+			tags[j].SpellingCounts = append(tags[j].SpellingCounts, count)
+		}
+		tags[j].updateName()
+	}
+	for start := 0; start < len(changedTags); start += fsckBatchSize {
+		batch := changedTags[start:minInt(start+fsckBatchSize, len(changedTags))]
+		keys, batchTags := make([]*datastore.Key, len(batch)), make([]Tag, len(batch))
+		for i, j := range batch {
+			keys[i], batchTags[i] = tagKeys[j], tags[j]
+		}
+		err = notebook.runInTransaction(c, func(tc appengine.Context) error {
+			keys, err = putTags(tc, keys, batchTags)
+			return err
+		})
+		if err != nil {
+			return problems, err
+		}
+		for i, j := range batch {
+			tagKeys[j] = keys[i]
+		}
+	}
+
+	// repair notes
+	for start := 0; start < len(changedNotes); start += fsckBatchSize {
+		batch := changedNotes[start:minInt(start+fsckBatchSize, len(changedNotes))]
+		keys, batchNotes := make([]*datastore.Key, len(batch)), make([]Note, len(batch))
+		for i, j := range batch {
+			keys[i], batchNotes[i] = noteKeys[j], notes[j]
+			batchNotes[i].TagKeys = nil
+			for _, k := range noteTags[j] {
+				batchNotes[i].TagKeys = append(batchNotes[i].TagKeys, tagKeys[k])
+			}
+		}
+		err = notebook.runInTransaction(c, func(tc appengine.Context) error {
+			_, err := putEntities(tc, keys, batchNotes)
+			return err
+		})
+		if err != nil {
+			return problems, err
+		}
+	}
+
+	// repair notebook
+	var deletedKeys []*datastore.Key
+	var deletedTags []Tag
+	for _, i := range append(emptyTags, duplicates...) {
+		deletedKeys = append(deletedKeys, tagKeys[i])
+		deletedTags = append(deletedTags, tags[i])
+	}
+	var keptTagKeys []*datastore.Key
+	for i, key := range tagKeys {
+		if !containsInt(emptyTags, i) && !containsInt(duplicates, i) {
+			keptTagKeys = append(keptTagKeys, key)
+		}
+	}
+	var keptNoteKeys []*datastore.Key
+	for _, key := range notebook.NoteKeys {
+		if existingNotes.Contains(key) {
+			keptNoteKeys = append(keptNoteKeys, key)
+		}
+	}
+	for _, key := range noteKeys {
+		if !listedNotes.Contains(key) {
+			keptNoteKeys = append(keptNoteKeys, key)
+		}
+	}
+	return problems, notebook.runInTransaction(c, func(tc appengine.Context) error {
+		if len(deletedKeys) > 0 {
+			err := deleteTags(tc, deletedKeys)
+			if err != nil {
+				return err
+			}
+			notebook.Order.Cleanup(deletedTags)
+		}
+		notebook.NoteKeys, notebook.TagKeys, notebook.UntaggedNoteKeys = keptNoteKeys, keptTagKeys, untagged
+		notebook.tags, notebook.notes, notebook.untaggedNotes = nil, nil, nil
+		notebook.noteSet, notebook.tagSet, notebook.untaggedSet = nil, nil, nil
+		err := notebook.deleteModels(tc)
+		if err != nil {
+			return err
+		}
+		return notebook.save(tc)
+	})
+}
+
+// sameSpellings returns true if tag has the spellings counted in spellings.
+func sameSpellings(tag Tag, spellings map[string]int) bool {
+	if len(tag.Spellings) != len(spellings) || len(tag.SpellingCounts) != len(tag.Spellings) {
+		return false
+	}
+	for i, spelling := range tag.Spellings {
+		if spellings[spelling] != tag.SpellingCounts[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// sameTags returns true if tagKeys are the Keys of the Tags at indexes, in any order.
+func sameTags(tagKeys []*datastore.Key, indexes []int, tagIndexes map[string]int) bool {
+	if len(tagKeys) != len(indexes) {
+		return false
+	}
+	for _, key := range tagKeys {
+		i, ok := tagIndexes[key.Encode()]
+		if !ok || !containsInt(indexes, i) {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,103 @@
+/*
+This file is part of Tessernote.
+
+Tessernote is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+Tessernote is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with Tessernote.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package tessernote
+
+import (
+	"appengine/datastore"
+	"github.com/oschmid/appenginetesting"
+	"testing"
+)
+
+func TestCheckRepair(t *testing.T) {
+	c, err := appenginetesting.NewContext(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	notebook := &Notebook{ID: "fsck"}
+	_, err = datastore.Put(c, notebook.Key(c), notebook)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, body := range []string{"one #a", "two #a #b", "three"} {
+		_, err = notebook.Put(Note{Body: body}, c)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	problems, err := notebook.Check(false, c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(problems) != 0 {
+		t.Fatalf("expected no problems, actual=%v", problems)
+	}
+
+	// a note that was written without updating its notebook or tags
+	unlisted := Note{Body: "four #b #c"}
+	key, err := datastore.Put(c, datastore.NewIncompleteKey(c, "Note", notebook.Key(c)), &unlisted)
+	if err != nil {
+		t.Fatal(err)
+	}
+	problems, err = notebook.Check(true, c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := map[string]bool{ProblemUnlistedNote: true, ProblemMissingTag: true, ProblemTagMissesNote: true,
+		ProblemNoteTags: true, ProblemSpellings: true}
+	for _, problem := range problems {
+		if !expected[problem.Kind] {
+			t.Errorf("unexpected problem %v", problem)
+		}
+		delete(expected, problem.Kind)
+	}
+	if len(expected) > 0 {
+		t.Fatalf("missing problems %v in %v", expected, problems)
+	}
+
+	// repaired
+	problems, err = notebook.Check(false, c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(problems) != 0 {
+		t.Fatalf("expected no problems after repair, actual=%v", problems)
+	}
+	if !notebook.hasNote(key) {
+		t.Fatalf("expected notebook to list %s", key)
+	}
+	unlisted.ID = key.Encode()
+	err = datastore.Get(c, key, &unlisted)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tags, err := notebook.TagsOf(unlisted, c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if names := Name(tags); len(names) != 2 || !containsTagName(names, "b") || !containsTagName(names, "c") {
+		t.Fatalf("expected tags b and c, actual=%v", names)
+	}
+	tags, err = notebook.TagsFrom([]string{"b"}, c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(tags) != 1 || len(tags[0].NoteKeys) != 2 || !tags[0].noteKeySet().Contains(key) {
+		t.Fatalf("expected tag b to refer to 2 notes including %s, actual=%v", key, tags)
+	}
+}
@@ -0,0 +1,510 @@
+/*
+This file is part of Tessernote.
+
+Tessernote is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+Tessernote is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with Tessernote.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+// Package gitstore keeps backups of notes as Markdown files (see convert.MarshalMarkdown) in a bare git repository,
+// so every backup is a commit and history, blame and revert work with ordinary git tools. It is used by tn backup.
+//
+// A Store is not a storage backend for tessernote.Notebook: it has no Tags of its own, no aliases, orders or
+// models, and it doesn't share the datastore's transactions. Put adds a Note (keeping an unused ID it already has)
+// or replaces it (keeping its Created time), and tags are derived from the hashtags in Note bodies for browsing a
+// backup. It doesn't depend on the App Engine SDK.
+//
+// Objects are written loose and never packed. The repository is created with gc.auto disabled. Running git gc by
+// hand packs objects and refs, which a Store can't read, so Open refuses packed repositories with ErrPacked.
+package gitstore
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"github.com/oschmid/tessernote/convert"
+	"github.com/oschmid/tessernote/hashtag"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	notesDir   = "notes"
+	noteExt    = ".md"
+	headRef    = "refs/heads/master"
+	repoConfig = "[core]\n\trepositoryformatversion = 0\n\tbare = true\n[gc]\n\tauto = 0\n"
+)
+
+var (
+	ErrInvalidID = errors.New("gitstore: invalid note ID")
+	ErrPacked    = errors.New("gitstore: repository has packed objects or refs (e.g. after git gc), unpack them first")
+	validID      = regexp.MustCompile("^[0-9a-zA-Z_-]+$") // URL safe base64, like datastore.Key.Encode()
+)
+
+// Store is a notebook kept in a git repository. It is safe for concurrent use, but only one Store should use a
+// repository at a time.
+type Store struct {
+	Author string           // of commits, e.g. "Tessernote <tessernote@example.com>"
+	Now    func() time.Time // time.Now if nil, used for commits and Note times
+	dir    string
+	mutex  sync.Mutex
+	head   string                  // hash of the latest commit, "" if there isn't one
+	notes  map[string]convert.Note // by ID
+	blobs  map[string]string       // hash of every Note's file by ID
+}
+
+// Tag is a hashtag used by the Notes in a Store.
+type Tag struct {
+	Name string   // spelling of its first use
+	IDs  []string // of the Notes that use it, sorted
+}
+
+// Revision is a commit that changed a Note.
+type Revision struct {
+	Commit  string
+	Time    time.Time
+	Message string
+	Note    convert.Note // the Note after the commit, the Note before it if Deleted
+	Deleted bool
+}
+
+// Open opens the bare git repository in dir, creating it if it doesn't exist.
+func Open(dir string) (*Store, error) {
+	store := &Store{
+		Author: "Tessernote <tessernote@localhost>",
+		dir:    dir,
+		notes:  make(map[string]convert.Note),
+		blobs:  make(map[string]string),
+	}
+	_, err := os.Stat(filepath.Join(dir, "HEAD"))
+	if os.IsNotExist(err) {
+		err = store.init()
+	}
+	if err != nil {
+		return nil, err
+	}
+	packed, err := store.packed()
+	if err != nil {
+		return nil, err
+	} else if packed {
+		return nil, ErrPacked
+	}
+	ref, err := ioutil.ReadFile(filepath.Join(dir, headRef))
+	if os.IsNotExist(err) {
+		return store, nil // no commits yet
+	} else if err != nil {
+		return nil, err
+	}
+	store.head = strings.TrimSpace(string(ref))
+	store.notes, store.blobs, err = store.readNotes(store.head)
+	if err != nil {
+		return nil, err
+	}
+	return store, nil
+}
+
+// packed returns true if the repository has pack files or packed refs.
+func (store *Store) packed() (bool, error) {
+	packs, err := filepath.Glob(filepath.Join(store.dir, "objects", "pack", "*.pack"))
+	if err != nil || len(packs) > 0 {
+		return len(packs) > 0, err
+	}
+	_, err = os.Stat(filepath.Join(store.dir, "packed-refs"))
+	if os.IsNotExist(err) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// init creates an empty bare repository.
+func (store *Store) init() error {
+	for _, dir := range []string{"objects", "refs/heads", "refs/tags"} {
+		err := os.MkdirAll(filepath.Join(store.dir, dir), 0755)
+		if err != nil {
+			return err
+		}
+	}
+	err := ioutil.WriteFile(filepath.Join(store.dir, "config"), []byte(repoConfig), 0644)
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(filepath.Join(store.dir, "HEAD"), []byte("ref: "+headRef+"\n"), 0644)
+}
+
+// readNotes reads the Notes of a commit and the hashes of their files.
+func (store *Store) readNotes(hash string) (map[string]convert.Note, map[string]string, error) {
+	notes := make(map[string]convert.Note)
+	blobs, err := store.readBlobs(hash)
+	if err != nil {
+		return nil, nil, err
+	}
+	for id, blob := range blobs {
+		notes[id], err = store.readNote(blob)
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+	return notes, blobs, nil
+}
+
+// readBlobs returns the hashes of the files of the Notes of a commit by ID.
+func (store *Store) readBlobs(hash string) (map[string]string, error) {
+	blobs := make(map[string]string)
+	c, err := store.readCommit(hash)
+	if err != nil {
+		return nil, err
+	}
+	root, err := store.readTree(c.Tree)
+	if err != nil {
+		return nil, err
+	}
+	for _, entry := range root {
+		if entry.Name != notesDir || entry.Mode != treeMode {
+			continue
+		}
+		entries, err := store.readTree(entry.Hash)
+		if err != nil {
+			return nil, err
+		}
+		for _, entry := range entries {
+			if strings.HasSuffix(entry.Name, noteExt) && entry.Mode == blobMode {
+				blobs[strings.TrimSuffix(entry.Name, noteExt)] = entry.Hash
+			}
+		}
+	}
+	return blobs, nil
+}
+
+// readNote reads a Note's file.
+func (store *Store) readNote(blob string) (convert.Note, error) {
+	content, err := store.readKind(blob, "blob")
+	if err != nil {
+		return convert.Note{}, err
+	}
+	return convert.UnmarshalMarkdown(content)
+}
+
+// Tx is a transaction, see Update.
+type Tx struct {
+	store   *Store
+	changes map[string]*convert.Note // nil for deleted Notes
+}
+
+// Update runs f in a transaction. If f returns nil and changed any Notes, the changes are committed with message.
+// f must only use tx, other methods of store block until the transaction ends.
+func (store *Store) Update(message string, f func(tx *Tx) error) error {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+	tx := &Tx{store, make(map[string]*convert.Note)}
+	err := f(tx)
+	if err != nil || len(tx.changes) == 0 {
+		return err
+	}
+	return store.commit(message, tx.changes)
+}
+
+// commit writes the files of changed Notes and a commit with them.
+func (store *Store) commit(message string, changes map[string]*convert.Note) error {
+	blobs := make(map[string]string, len(store.blobs))
+	for id, blob := range store.blobs {
+		blobs[id] = blob
+	}
+	for id, note := range changes {
+		if note == nil {
+			delete(blobs, id)
+			continue
+		}
+		blob, err := store.writeObject("blob", convert.MarshalMarkdown(*note))
+		if err != nil {
+			return err
+		}
+		blobs[id] = blob
+	}
+	entries := make([]treeEntry, 0, len(blobs))
+	for id, blob := range blobs {
+		entries = append(entries, treeEntry{blobMode, id + noteExt, blob})
+	}
+	notesTree, err := store.writeTree(entries)
+	if err != nil {
+		return err
+	}
+	root, err := store.writeTree([]treeEntry{{treeMode, notesDir, notesTree}})
+	if err != nil {
+		return err
+	}
+	hash, err := store.writeCommit(commit{
+		Tree:    root,
+		Parent:  store.head,
+		Author:  store.Author,
+		Time:    store.now(),
+		Message: message,
+	})
+	if err != nil {
+		return err
+	}
+	err = writeFile(filepath.Join(store.dir, headRef), []byte(hash+"\n"), 0644)
+	if err != nil {
+		return err
+	}
+	store.head, store.blobs = hash, blobs
+	for id, note := range changes {
+		if note == nil {
+			delete(store.notes, id)
+		} else {
+			store.notes[id] = *note
+		}
+	}
+	return nil
+}
+
+// now returns the current time in UTC, like the times read from files.
+func (store *Store) now() time.Time {
+	if store.Now != nil {
+		return store.Now().UTC()
+	}
+	return time.Now().UTC()
+}
+
+// Get returns a Note by ID, seeing the changes made so far in this transaction.
+func (tx *Tx) Get(id string) (convert.Note, bool) {
+	if note, ok := tx.changes[id]; ok {
+		if note == nil {
+			return convert.Note{}, false
+		}
+		return *note, true
+	}
+	note, ok := tx.store.notes[id]
+	return note, ok
+}
+
+// Put updates a Note or creates it if it doesn't already exist. Updated Notes keep their Created time, new Notes
+// keep their ID if they have one. Both get the current time as LastModified.
+func (tx *Tx) Put(note convert.Note) (convert.Note, error) {
+	now := tx.store.now()
+	if old, ok := tx.Get(note.ID); ok && note.ID != "" {
+		note.Created = old.Created
+	} else {
+		if note.ID == "" {
+			note.ID = newID()
+		}
+		note.Created = now
+	}
+	note.LastModified = now
+	return note, tx.Write(note)
+}
+
+// Write stores note as it is, including its times. It's used for restoring Notes, e.g. from a backup.
+func (tx *Tx) Write(note convert.Note) error {
+	if !validID.MatchString(note.ID) {
+		return ErrInvalidID
+	}
+	tx.changes[note.ID] = &note
+	return nil
+}
+
+// Delete deletes a Note. It returns false if there was no Note with id.
+func (tx *Tx) Delete(id string) bool {
+	if _, ok := tx.Get(id); !ok {
+		return false
+	}
+	tx.changes[id] = nil
+	return true
+}
+
+// newID returns a random ID for a new Note.
+func newID() string {
+	b := make([]byte, 12)
+	rand.Read(b)
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+// Put updates a Note or creates it if it doesn't already exist in its own commit, see Tx.Put.
+func (store *Store) Put(note convert.Note) (convert.Note, error) {
+	if note.ID == "" {
+		note.ID = newID()
+	}
+	err := store.Update("Put note "+note.ID, func(tx *Tx) error {
+		var err error
+		note, err = tx.Put(note)
+		return err
+	})
+	return note, err
+}
+
+// Delete deletes a Note in its own commit. It returns false if there was no Note with id.
+func (store *Store) Delete(id string) (bool, error) {
+	deleted := false
+	err := store.Update("Delete note "+id, func(tx *Tx) error {
+		deleted = tx.Delete(id)
+		return nil
+	})
+	return deleted, err
+}
+
+// Get returns a Note by ID.
+func (store *Store) Get(id string) (convert.Note, bool) {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+	note, ok := store.notes[id]
+	return note, ok
+}
+
+// Notes returns all Notes, most recently modified first.
+func (store *Store) Notes() []convert.Note {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+	notes := make([]convert.Note, 0, len(store.notes))
+	for _, note := range store.notes {
+		notes = append(notes, note)
+	}
+	sort.Slice(notes, func(i, j int) bool {
+		if notes[i].LastModified.Equal(notes[j].LastModified) {
+			return notes[i].ID < notes[j].ID
+		}
+		return notes[i].LastModified.After(notes[j].LastModified)
+	})
+	return notes
+}
+
+// Tags returns the Tags of all Notes sorted by folded name. Different spellings of a hashtag (e.g. #Go and #go)
+// are the same Tag.
+func (store *Store) Tags() []Tag {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+	tags := make(map[string]*Tag)
+	for id, note := range store.notes {
+		seen := make(map[string]bool)
+		for _, name := range hashtag.Names(note.Body) {
+			folded := hashtag.Fold(name)
+			if seen[folded] {
+				continue
+			}
+			seen[folded] = true
+			tag, ok := tags[folded]
+			if !ok {
+				tag = &Tag{Name: name}
+				tags[folded] = tag
+			}
+			tag.IDs = append(tag.IDs, id)
+		}
+	}
+	folded := make([]string, 0, len(tags))
+	for name := range tags {
+		folded = append(folded, name)
+	}
+	sort.Strings(folded)
+	sorted := make([]Tag, len(folded))
+	for i, name := range folded {
+		sort.Strings(tags[name].IDs)
+		sorted[i] = *tags[name]
+	}
+	return sorted
+}
+
+// Untagged returns the IDs of the Notes without hashtags, sorted.
+func (store *Store) Untagged() []string {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+	var ids []string
+	for id, note := range store.notes {
+		if len(hashtag.Find(note.Body)) == 0 {
+			ids = append(ids, id)
+		}
+	}
+	sort.Strings(ids)
+	return ids
+}
+
+// History returns the commits that changed a Note, most recent first. Only first parents are followed.
+func (store *Store) History(id string) ([]Revision, error) {
+	store.mutex.Lock()
+	head := store.head
+	store.mutex.Unlock()
+	var revisions []Revision
+	blob := ""
+	if head != "" {
+		blobs, err := store.readBlobs(head)
+		if err != nil {
+			return nil, err
+		}
+		blob = blobs[id]
+	}
+	for hash := head; hash != ""; {
+		c, err := store.readCommit(hash)
+		if err != nil {
+			return nil, err
+		}
+		parentBlob := ""
+		if c.Parent != "" {
+			blobs, err := store.readBlobs(c.Parent)
+			if err != nil {
+				return nil, err
+			}
+			parentBlob = blobs[id]
+		}
+		if blob != parentBlob {
+			revision := Revision{Commit: hash, Time: c.Time, Message: c.Message}
+			if blob == "" {
+				revision.Deleted = true
+				revision.Note, err = store.readNote(parentBlob)
+			} else {
+				revision.Note, err = store.readNote(blob)
+			}
+			if err != nil {
+				return nil, err
+			}
+			revisions = append(revisions, revision)
+		}
+		hash, blob = c.Parent, parentBlob
+	}
+	return revisions, nil
+}
+
+// Revert restores a Note to how it was after commit in a new commit, deleting it if it didn't exist then. The
+// restored Note gets the current time as LastModified. It returns false if the Note didn't change.
+func (store *Store) Revert(id, commit string) (bool, error) {
+	blobs, err := store.readBlobs(commit)
+	if err != nil {
+		return false, err
+	}
+	var note convert.Note // zero if the Note didn't exist
+	if blob, ok := blobs[id]; ok {
+		note, err = store.readNote(blob)
+		if err != nil {
+			return false, err
+		}
+	}
+	reverted := false
+	err = store.Update("Revert note "+id+" to "+commit[:7], func(tx *Tx) error {
+		current, ok := tx.Get(id)
+		if note.ID == "" {
+			reverted = tx.Delete(id)
+			return nil
+		}
+		if ok && current.Body == note.Body && current.Pinned == note.Pinned && current.Archived == note.Archived {
+			return nil
+		}
+		if ok {
+			note.Created = current.Created
+		}
+		note.LastModified = tx.store.now()
+		reverted = true
+		return tx.Write(note)
+	})
+	return reverted, err
+}
@@ -0,0 +1,161 @@
+/*
+This file is part of Tessernote.
+
+Tessernote is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+Tessernote is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with Tessernote.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package gitstore
+
+import (
+	"github.com/oschmid/tessernote/convert"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// newTestStore returns a Store in a temporary directory with a clock that advances a second per call.
+func newTestStore(t *testing.T) (*Store, string) {
+	dir, err := ioutil.TempDir("", "gitstore")
+	if err != nil {
+		t.Fatal(err)
+	}
+	store, err := Open(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	store.Now = clock()
+	return store, dir
+}
+
+func clock() func() time.Time {
+	now := time.Date(2013, 5, 1, 12, 0, 0, 0, time.UTC)
+	return func() time.Time {
+		now = now.Add(time.Second)
+		return now
+	}
+}
+
+func TestStore(t *testing.T) {
+	store, dir := newTestStore(t)
+	defer os.RemoveAll(dir)
+
+	a, err := store.Put(convert.Note{Body: "a #Go #work"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := store.Put(convert.Note{ID: "b", Body: "b #go"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if b.ID != "b" || !b.Created.Equal(b.LastModified) {
+		t.Fatalf("unexpected note %#v", b)
+	}
+	b.Body = "b"
+	updated, err := store.Put(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !updated.Created.Equal(b.Created) || !updated.LastModified.After(b.Created) {
+		t.Fatalf("expected created time to be kept %#v", updated)
+	}
+	err = store.Update("two changes", func(tx *Tx) error {
+		tx.Delete(a.ID)
+		return tx.Write(convert.Note{ID: "c", Body: "c #work", Created: a.Created, LastModified: a.Created})
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = store.Put(convert.Note{ID: "../x", Body: "x"})
+	if err != ErrInvalidID {
+		t.Fatalf("expected=%s actual=%v", ErrInvalidID, err)
+	}
+
+	store, err = Open(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	store.Now = clock()
+	notes := store.Notes()
+	if len(notes) != 2 || notes[0].ID != "b" || notes[1].ID != "c" || notes[1].Body != "c #work" {
+		t.Fatalf("unexpected notes %#v", notes)
+	}
+	tags := store.Tags()
+	if len(tags) != 1 || tags[0].Name != "work" || strings.Join(tags[0].IDs, ",") != "c" {
+		t.Fatalf("unexpected tags %#v", tags)
+	}
+	if untagged := store.Untagged(); len(untagged) != 1 || untagged[0] != "b" {
+		t.Fatalf("unexpected untagged notes %#v", untagged)
+	}
+
+	history, err := store.History(a.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(history) != 2 || !history[0].Deleted || history[0].Message != "two changes" || history[1].Note.Body != "a #Go #work" {
+		t.Fatalf("unexpected history %#v", history)
+	}
+	reverted, err := store.Revert(a.ID, history[1].Commit)
+	if err != nil || !reverted {
+		t.Fatalf("expected revert, actual=%t %v", reverted, err)
+	}
+	if note, ok := store.Get(a.ID); !ok || note.Body != "a #Go #work" {
+		t.Fatalf("unexpected reverted note %#v", note)
+	}
+	if tags := store.Tags(); len(tags) != 2 || tags[0].Name != "Go" || len(tags[1].IDs) != 2 {
+		t.Fatalf("unexpected tags %#v", tags)
+	}
+
+	if _, err := exec.LookPath("git"); err != nil {
+		return
+	}
+	out, err := exec.Command("git", "--git-dir", dir, "fsck", "--strict").CombinedOutput()
+	if err != nil {
+		t.Fatalf("git fsck: %s %s", err, out)
+	}
+	out, err = exec.Command("git", "--git-dir", dir, "log", "--format=%s", "--", "notes/b.md").CombinedOutput()
+	if err != nil || string(out) != "Put note b\nPut note b\n" {
+		t.Fatalf("git log: %v %q", err, out)
+	}
+}
+
+func TestOpenPacked(t *testing.T) {
+	store, dir := newTestStore(t)
+	defer os.RemoveAll(dir)
+	_, err := store.Put(convert.Note{ID: "a", Body: "a"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := exec.LookPath("git"); err == nil {
+		out, err := exec.Command("git", "--git-dir", dir, "gc", "--quiet").CombinedOutput()
+		if err != nil {
+			t.Fatalf("git gc: %s %s", err, out)
+		}
+	} else {
+		err = os.MkdirAll(filepath.Join(dir, "objects", "pack"), 0755)
+		if err == nil {
+			err = ioutil.WriteFile(filepath.Join(dir, "objects", "pack", "pack-0.pack"), nil, 0644)
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	_, err = Open(dir)
+	if err != ErrPacked {
+		t.Fatalf("expected=%s actual=%v", ErrPacked, err)
+	}
+}
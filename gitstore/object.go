@@ -0,0 +1,265 @@
+/*
+This file is part of Tessernote.
+
+Tessernote is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+Tessernote is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with Tessernote.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package gitstore
+
+import (
+	"bytes"
+	"compress/zlib"
+	"crypto/sha1"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	blobMode = "100644"
+	treeMode = "40000"
+)
+
+var (
+	ErrCorrupt = errors.New("gitstore: corrupt object")
+	validHash  = regexp.MustCompile("^[0-9a-f]{40}$")
+)
+
+// treeEntry is a file or directory in a git tree.
+type treeEntry struct {
+	Mode string
+	Name string
+	Hash string // hex
+}
+
+// commit is a git commit object.
+type commit struct {
+	Tree    string
+	Parent  string // "" for the first commit
+	Author  string // "Name <email>"
+	Time    time.Time
+	Message string
+}
+
+// writeObject stores a loose object and returns its hash. Objects that already exist aren't written again.
+func (store *Store) writeObject(kind string, content []byte) (string, error) {
+	data := append([]byte(kind+" "+strconv.Itoa(len(content))+"\x00"), content...)
+	sum := sha1.Sum(data)
+	hash := hex.EncodeToString(sum[:])
+	path := store.objectPath(hash)
+	if _, err := os.Stat(path); err == nil {
+		return hash, nil
+	}
+	var b bytes.Buffer
+	w := zlib.NewWriter(&b)
+	w.Write(data)
+	err := w.Close()
+	if err != nil {
+		return "", err
+	}
+	err = os.MkdirAll(filepath.Dir(path), 0755)
+	if err != nil {
+		return "", err
+	}
+	return hash, writeFile(path, b.Bytes(), 0444)
+}
+
+// readObject reads a loose object and returns its kind and content. Packed objects (e.g. after git gc) can't be
+// read, see ErrPacked.
+func (store *Store) readObject(hash string) (kind string, content []byte, err error) {
+	if !validHash.MatchString(hash) {
+		return "", nil, fmt.Errorf("gitstore: invalid object hash %q", hash)
+	}
+	f, err := os.Open(store.objectPath(hash))
+	if err != nil {
+		return "", nil, err
+	}
+	defer f.Close()
+	r, err := zlib.NewReader(f)
+	if err != nil {
+		return "", nil, err
+	}
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return "", nil, err
+	}
+	header := bytes.IndexByte(data, 0)
+	if header < 0 {
+		return "", nil, ErrCorrupt
+	}
+	fields := strings.SplitN(string(data[:header]), " ", 2)
+	if len(fields) != 2 || fields[1] != strconv.Itoa(len(data)-header-1) {
+		return "", nil, ErrCorrupt
+	}
+	return fields[0], data[header+1:], nil
+}
+
+// readKind reads an object that has to be of kind.
+func (store *Store) readKind(hash, kind string) ([]byte, error) {
+	actual, content, err := store.readObject(hash)
+	if err != nil {
+		return nil, err
+	}
+	if actual != kind {
+		return nil, fmt.Errorf("gitstore: %s is a %s, not a %s", hash, actual, kind)
+	}
+	return content, nil
+}
+
+// objectPath returns the path of a loose object.
+func (store *Store) objectPath(hash string) string {
+	return filepath.Join(store.dir, "objects", hash[:2], hash[2:])
+}
+
+// writeTree stores a tree object with entries.
+func (store *Store) writeTree(entries []treeEntry) (string, error) {
+	sort.Sort(byGitName(entries))
+	var b bytes.Buffer
+	for _, entry := range entries {
+		hash, err := hex.DecodeString(entry.Hash)
+		if err != nil {
+			return "", err
+		}
+		b.WriteString(entry.Mode + " " + entry.Name + "\x00")
+		b.Write(hash)
+	}
+	return store.writeObject("tree", b.Bytes())
+}
+
+// readTree reads the entries of a tree object.
+func (store *Store) readTree(hash string) ([]treeEntry, error) {
+	content, err := store.readKind(hash, "tree")
+	if err != nil {
+		return nil, err
+	}
+	var entries []treeEntry
+	for len(content) > 0 {
+		end := bytes.IndexByte(content, 0)
+		if end < 0 || len(content) < end+21 {
+			return nil, ErrCorrupt
+		}
+		fields := strings.SplitN(string(content[:end]), " ", 2)
+		if len(fields) != 2 {
+			return nil, ErrCorrupt
+		}
+		entries = append(entries, treeEntry{fields[0], fields[1], hex.EncodeToString(content[end+1 : end+21])})
+		content = content[end+21:]
+	}
+	return entries, nil
+}
+
+// writeCommit stores a commit object.
+func (store *Store) writeCommit(c commit) (string, error) {
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "tree %s\n", c.Tree)
+	if c.Parent != "" {
+		fmt.Fprintf(&b, "parent %s\n", c.Parent)
+	}
+	signature := fmt.Sprintf("%s %d %s", c.Author, c.Time.Unix(), c.Time.Format("-0700"))
+	fmt.Fprintf(&b, "author %s\ncommitter %s\n\n%s\n", signature, signature, strings.TrimRight(c.Message, "\n"))
+	return store.writeObject("commit", b.Bytes())
+}
+
+// readCommit reads a commit object. Only the first parent is kept.
+func (store *Store) readCommit(hash string) (commit, error) {
+	var c commit
+	content, err := store.readKind(hash, "commit")
+	if err != nil {
+		return c, err
+	}
+	text := string(content)
+	end := strings.Index(text, "\n\n")
+	if end < 0 {
+		return c, ErrCorrupt
+	}
+	c.Message = strings.TrimRight(text[end+2:], "\n")
+	for _, line := range strings.Split(text[:end], "\n") {
+		fields := strings.SplitN(line, " ", 2)
+		if len(fields) != 2 {
+			continue
+		}
+		switch fields[0] {
+		case "tree":
+			c.Tree = fields[1]
+		case "parent":
+			if c.Parent == "" {
+				c.Parent = fields[1]
+			}
+		case "author":
+			c.Author, c.Time, err = parseSignature(fields[1])
+			if err != nil {
+				return c, err
+			}
+		}
+	}
+	return c, nil
+}
+
+// parseSignature splits "Name <email> 1367409600 +0200" into its identity and time.
+func parseSignature(signature string) (string, time.Time, error) {
+	fields := strings.Fields(signature)
+	if len(fields) < 2 {
+		return "", time.Time{}, ErrCorrupt
+	}
+	seconds, err := strconv.ParseInt(fields[len(fields)-2], 10, 64)
+	if err != nil {
+		return "", time.Time{}, ErrCorrupt
+	}
+	t := time.Unix(seconds, 0)
+	if zone, err := time.Parse("-0700", fields[len(fields)-1]); err == nil {
+		_, offset := zone.Zone()
+		t = t.In(time.FixedZone("", offset))
+	}
+	return strings.Join(fields[:len(fields)-2], " "), t, nil
+}
+
+// writeFile writes data to a temporary file and renames it to path so readers never see a partial file.
+func writeFile(path string, data []byte, perm os.FileMode) error {
+	tmp := path + ".tmp"
+	err := ioutil.WriteFile(tmp, data, perm)
+	if err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// byGitName sorts tree entries the way git does: directories as if their name ended with a slash.
+type byGitName []treeEntry
+
+func (s byGitName) Len() int {
+	return len(s)
+}
+
+func (s byGitName) Less(i, j int) bool {
+	return s.key(i) < s.key(j)
+}
+
+func (s byGitName) Swap(i, j int) {
+	s[i], s[j] = s[j], s[i]
+}
+
+func (s byGitName) key(i int) string {
+	if s[i].Mode == treeMode {
+		return s[i].Name + "/"
+	}
+	return s[i].Name
+}
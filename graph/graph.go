@@ -0,0 +1,246 @@
+/*
+This file is part of Tessernote.
+
+Tessernote is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+Tessernote is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with Tessernote.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+// Package graph is a weighted co-occurrence graph of labels (e.g. tags) that appear together in documents.
+package graph
+
+import (
+	"encoding/xml"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+)
+
+type Graph struct {
+	Nodes map[string]int            // label -> number of documents
+	Edges map[string]map[string]int // label -> label -> number of documents with both, symmetric
+}
+
+// Edge is the co-occurrence of two labels.
+type Edge struct {
+	From    string
+	To      string
+	Count   int     // number of documents with both labels
+	Jaccard float64 // Count divided by the number of documents with either label
+}
+
+// New creates an empty Graph.
+func New() *Graph {
+	return &Graph{
+		Nodes: make(map[string]int),
+		Edges: make(map[string]map[string]int),
+	}
+}
+
+// Add adds a document with labels.
+func (g *Graph) Add(labels []string) {
+	g.update(labels, 1)
+}
+
+// Remove removes a document with labels that was added before.
+func (g *Graph) Remove(labels []string) {
+	g.update(labels, -1)
+}
+
+// update adds delta to the counts of a document.
+func (g *Graph) update(labels []string, delta int) {
+	for i, a := range labels {
+		g.Nodes[a] += delta
+		if g.Nodes[a] <= 0 {
+			delete(g.Nodes, a)
+		}
+		for _, b := range labels[i+1:] {
+			if a != b {
+				g.updateEdge(a, b, delta)
+				g.updateEdge(b, a, delta)
+			}
+		}
+	}
+}
+
+// updateEdge adds delta to the count of the edge from a to b.
+func (g *Graph) updateEdge(a, b string, delta int) {
+	edges, ok := g.Edges[a]
+	if !ok {
+		edges = make(map[string]int)
+		g.Edges[a] = edges
+	}
+	edges[b] += delta
+	if edges[b] <= 0 {
+		delete(edges, b)
+		if len(edges) == 0 {
+			delete(g.Edges, a)
+		}
+	}
+}
+
+// Edge returns the edge between labels a and b.
+func (g *Graph) Edge(a, b string) Edge {
+	count := g.Edges[a][b]
+	edge := Edge{From: a, To: b, Count: count}
+	if union := g.Nodes[a] + g.Nodes[b] - count; union > 0 {
+		edge.Jaccard = float64(count) / float64(union)
+	}
+	return edge
+}
+
+// Neighbours returns the edges from label a, strongest first.
+func (g *Graph) Neighbours(a string) []Edge {
+	edges := make([]Edge, 0, len(g.Edges[a]))
+	for b := range g.Edges[a] {
+		edges = append(edges, g.Edge(a, b))
+	}
+	sort.Sort(byStrength(edges))
+	return edges
+}
+
+// AllEdges returns every edge once, From sorting before To, strongest first.
+func (g *Graph) AllEdges() []Edge {
+	var edges []Edge
+	for a, bs := range g.Edges {
+		for b := range bs {
+			if a < b {
+				edges = append(edges, g.Edge(a, b))
+			}
+		}
+	}
+	sort.Sort(byStrength(edges))
+	return edges
+}
+
+// labels returns the labels of all nodes in sorted order.
+func (g *Graph) labels() []string {
+	labels := make([]string, 0, len(g.Nodes))
+	for label := range g.Nodes {
+		labels = append(labels, label)
+	}
+	sort.Strings(labels)
+	return labels
+}
+
+type byStrength []Edge
+
+func (s byStrength) Len() int {
+	return len(s)
+}
+
+func (s byStrength) Less(i, j int) bool {
+	if s[i].Jaccard != s[j].Jaccard {
+		return s[i].Jaccard > s[j].Jaccard
+	}
+	if s[i].Count != s[j].Count {
+		return s[i].Count > s[j].Count
+	}
+	if s[i].From != s[j].From {
+		return s[i].From < s[j].From
+	}
+	return s[i].To < s[j].To
+}
+
+func (s byStrength) Swap(i, j int) {
+	s[i], s[j] = s[j], s[i]
+}
+
+// WriteDOT writes g in Graphviz DOT format to w. name returns the display name of a label.
+func (g *Graph) WriteDOT(w io.Writer, name func(label string) string) error {
+	_, err := fmt.Fprintln(w, "graph tags {")
+	if err != nil {
+		return err
+	}
+	for _, label := range g.labels() {
+		_, err = fmt.Fprintf(w, "\t%s [label=%s, count=%d];\n",
+			strconv.Quote(label), strconv.Quote(name(label)), g.Nodes[label])
+		if err != nil {
+			return err
+		}
+	}
+	for _, edge := range g.AllEdges() {
+		_, err = fmt.Fprintf(w, "\t%s -- %s [weight=%d, jaccard=%.4f];\n",
+			strconv.Quote(edge.From), strconv.Quote(edge.To), edge.Count, edge.Jaccard)
+		if err != nil {
+			return err
+		}
+	}
+	_, err = fmt.Fprintln(w, "}")
+	return err
+}
+
+type graphML struct {
+	XMLName xml.Name     `xml:"graphml"`
+	XMLNS   string       `xml:"xmlns,attr"`
+	Keys    []graphMLKey `xml:"key"`
+	Graph   struct {
+		EdgeDefault string        `xml:"edgedefault,attr"`
+		Nodes       []graphMLNode `xml:"node"`
+		Edges       []graphMLEdge `xml:"edge"`
+	} `xml:"graph"`
+}
+
+type graphMLKey struct {
+	ID   string `xml:"id,attr"`
+	For  string `xml:"for,attr"`
+	Name string `xml:"attr.name,attr"`
+	Type string `xml:"attr.type,attr"`
+}
+
+type graphMLData struct {
+	Key   string `xml:"key,attr"`
+	Value string `xml:",chardata"`
+}
+
+type graphMLNode struct {
+	ID   string        `xml:"id,attr"`
+	Data []graphMLData `xml:"data"`
+}
+
+type graphMLEdge struct {
+	Source string        `xml:"source,attr"`
+	Target string        `xml:"target,attr"`
+	Data   []graphMLData `xml:"data"`
+}
+
+// WriteGraphML writes g in GraphML format to w. name returns the display name of a label.
+func (g *Graph) WriteGraphML(w io.Writer, name func(label string) string) error {
+	doc := graphML{XMLNS: "http://graphml.graphdrawing.org/xmlns"}
+	doc.Keys = []graphMLKey{
+		{"name", "node", "name", "string"},
+		{"count", "node", "count", "int"},
+		{"weight", "edge", "weight", "int"},
+		{"jaccard", "edge", "jaccard", "double"},
+	}
+	doc.Graph.EdgeDefault = "undirected"
+	for _, label := range g.labels() {
+		doc.Graph.Nodes = append(doc.Graph.Nodes, graphMLNode{label, []graphMLData{
+			{"name", name(label)},
+			{"count", strconv.Itoa(g.Nodes[label])},
+		}})
+	}
+	for _, edge := range g.AllEdges() {
+		doc.Graph.Edges = append(doc.Graph.Edges, graphMLEdge{edge.From, edge.To, []graphMLData{
+			{"weight", strconv.Itoa(edge.Count)},
+			{"jaccard", strconv.FormatFloat(edge.Jaccard, 'f', 4, 64)},
+		}})
+	}
+	_, err := io.WriteString(w, xml.Header)
+	if err != nil {
+		return err
+	}
+	encoder := xml.NewEncoder(w)
+	encoder.Indent("", "  ")
+	return encoder.Encode(doc)
+}
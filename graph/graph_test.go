@@ -0,0 +1,100 @@
+/*
+This file is part of Tessernote.
+
+Tessernote is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+Tessernote is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with Tessernote.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package graph
+
+import (
+	"bytes"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestNeighbours(t *testing.T) {
+	g := New()
+	g.Add([]string{"go", "web"})
+	g.Add([]string{"go", "web"})
+	g.Add([]string{"go", "cli"})
+	g.Add([]string{"web", "css"})
+
+	expected := []Edge{
+		{"go", "web", 2, 2.0 / 4},
+		{"go", "cli", 1, 1.0 / 3},
+	}
+	if actual := g.Neighbours("go"); !reflect.DeepEqual(actual, expected) {
+		t.Fatalf("expected=%v actual=%v", expected, actual)
+	}
+	if edge := g.Edge("cli", "css"); edge.Count != 0 || edge.Jaccard != 0 {
+		t.Fatalf("expected no edge, actual=%v", edge)
+	}
+	if edges := g.AllEdges(); len(edges) != 3 || edges[0].From != "go" || edges[0].To != "web" {
+		t.Fatalf("unexpected edges %v", edges)
+	}
+}
+
+func TestRemove(t *testing.T) {
+	g := New()
+	g.Add([]string{"go", "web"})
+	g.Add([]string{"go", "web", "css"})
+	g.Remove([]string{"go", "web", "css"})
+
+	expected := New()
+	expected.Add([]string{"go", "web"})
+	if !reflect.DeepEqual(g, expected) {
+		t.Fatalf("expected=%v actual=%v", expected, g)
+	}
+	g.Remove([]string{"go", "web"})
+	if len(g.Nodes) != 0 || len(g.Edges) != 0 {
+		t.Fatalf("expected empty graph, actual=%v", g)
+	}
+}
+
+func TestWriteDOT(t *testing.T) {
+	g := New()
+	g.Add([]string{"a", "b"})
+	buffer := new(bytes.Buffer)
+	err := g.WriteDOT(buffer, strings.ToUpper)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := "graph tags {\n" +
+		"\t\"a\" [label=\"A\", count=1];\n" +
+		"\t\"b\" [label=\"B\", count=1];\n" +
+		"\t\"a\" -- \"b\" [weight=1, jaccard=1.0000];\n" +
+		"}\n"
+	if buffer.String() != expected {
+		t.Fatalf("expected=%q actual=%q", expected, buffer.String())
+	}
+}
+
+func TestWriteGraphML(t *testing.T) {
+	g := New()
+	g.Add([]string{"a", "b"})
+	buffer := new(bytes.Buffer)
+	err := g.WriteGraphML(buffer, strings.ToUpper)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, expected := range []string{
+		`<node id="a">`, `<data key="name">A</data>`, `<edge source="a" target="b">`,
+		`<data key="jaccard">1.0000</data>`,
+	} {
+		if !strings.Contains(buffer.String(), expected) {
+			t.Errorf("expected %q in %s", expected, buffer.String())
+		}
+	}
+}
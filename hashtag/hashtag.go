@@ -18,7 +18,14 @@ along with Tessernote.  If not, see <http://www.gnu.org/licenses/>.
 // hashtag regexp pattern from https://github.com/twitter/twitter-text-java/blob/master/src/com/twitter/Regex.java
 package hashtag
 
-import "regexp"
+import (
+	"golang.org/x/text/cases"
+	"golang.org/x/text/unicode/norm"
+	"golang.org/x/text/width"
+	"regexp"
+	"strings"
+	"unicode/utf8"
+)
 
 const (
 	LatinAccentsChars = "\u00c0-\u00d6\u00d8-\u00f6\u00f8-\u00ff" + // Latin-1
@@ -27,7 +34,7 @@ const (
 		"\u02bb" + // Hawaiian
 		"\u0300-\u036f" + // Combining diacritics
 		"\u1e00-\u1eff" // Latin Extended Additional (mostly for Vietnamese)
-	AlphaChars = "a-z" + LatinAccentsChars +
+	AlphaChars = "a-zA-Z" + LatinAccentsChars + // any case, tags are identified by their case folded name
 		"\u0400-\u04ff\u0500-\u0527" + // Cyrillic
 		"\u2de0-\u2dff\ua640-\ua69f" + // Cyrillic Extended A/B
 		"\u0591-\u05bf\u05c1-\u05c2\u05c4-\u05c5\u05c7" +
@@ -43,7 +50,7 @@ const (
 		"\u0e01-\u0e3a\u0e40-\u0e4e" + // Thai
 		"\u1100-\u11ff\u3130-\u3185\uA960-\uA97F\uAC00-\uD7AF\uD7B0-\uD7FF" + // Hangul (Korean)
 		"\\p{Hiragana}\\p{Katakana}" + // Japanese Hiragana and Katakana
-		// TODO "\\p{Unified_Ideograph}" + // Japanese Kanji / Chinese Han (could also be CJK, CJK_Unified_Ideograph, Unified_Ideograph)
+		"\\p{Han}" + // Japanese Kanji / Chinese Han (Go's regexp has no Unified_Ideograph property)
 		"\u3003\u3005\u303b" + // Kanji/Han iteration marks
 		"\uff21-\uff3a\uff41-\uff5a" + // full width Alphabet
 		"\uff66-\uff9f" + // half width Katakana
@@ -51,7 +58,133 @@ const (
 	AlphaNumericChars = "0-9\uff10-\uff19_" + AlphaChars
 	AlphaNumeric      = "[" + AlphaNumericChars + "]"
 	Alpha             = "[" + AlphaChars + "]"
-	Pattern           = "(^|[^&" + AlphaNumericChars + "])(#|\uFF03)(" + AlphaNumeric + "*" + Alpha + AlphaNumeric + "*)"
+	NamePattern       = AlphaNumeric + "*" + Alpha + AlphaNumeric + "*"
+	ValuePattern      = AlphaNumeric + "+(?:[-.]" + AlphaNumeric + "+)*" // e.g. done, 2, 2.5, 2013-05-01
+	Pattern           = "(^|[^&" + AlphaNumericChars + "])(#|\uFF03)(" + NamePattern + "(?:" + ValueSeparator + ValuePattern + ")?)"
+	ValueSeparator    = ":"
+)
+
+var (
+	Regex    = regexp.MustCompile(Pattern)
+	URLRegex = regexp.MustCompile("(?i)\\b(?:(?:https?|ftp)://|www\\.)[^\\s<>\"]+")
 )
 
-var Regex = regexp.MustCompile(Pattern)
+// Span is a hashtag found in a text.
+type Span struct {
+	Name      string // hashtag without its hash mark
+	Mark      rune   // hash mark used, # or its full width form
+	Start     int    // byte offset of the hash mark
+	End       int    // byte offset after the name
+	RuneStart int    // rune offset of the hash mark
+	RuneEnd   int    // rune offset after the name
+}
+
+// Find returns the hashtags in text in the order they appear. Hashtags that are part of URLs or that are directly
+// followed by another hash mark or "://" are ignored.
+func Find(text string) []Span {
+	var spans []Span
+	urls := URLRegex.FindAllStringIndex(text, -1)
+	runes, last := 0, 0
+	for _, match := range Regex.FindAllStringSubmatchIndex(text, -1) {
+		start, nameStart, end := match[4], match[6], match[7]
+		if inURL(urls, start) || invalidEnd(text[end:]) {
+			continue
+		}
+		runes += utf8.RuneCountInString(text[last:start])
+		mark, _ := utf8.DecodeRuneInString(text[start:])
+		name := text[nameStart:end]
+		span := Span{
+			Name:      name,
+			Mark:      mark,
+			Start:     start,
+			End:       end,
+			RuneStart: runes,
+			RuneEnd:   runes + 1 + utf8.RuneCountInString(name),
+		}
+		spans = append(spans, span)
+		runes, last = span.RuneEnd, end
+	}
+	return spans
+}
+
+// inURL returns true if the byte offset i is within one of urls.
+func inURL(urls [][]int, i int) bool {
+	for _, url := range urls {
+		if url[0] <= i && i < url[1] {
+			return true
+		}
+	}
+	return false
+}
+
+// invalidEnd returns true if rest (the text after a hashtag) means it isn't a hashtag after all.
+func invalidEnd(rest string) bool {
+	return strings.HasPrefix(rest, "#") || strings.HasPrefix(rest, "\uFF03") || strings.HasPrefix(rest, "://")
+}
+
+// Names returns the names of the hashtags in text.
+func Names(text string) []string {
+	var names []string
+	for _, span := range Find(text) {
+		names = append(names, span.Name)
+	}
+	return names
+}
+
+// Replace returns text with every hashtag replaced by the result of replace. Text outside of hashtags (including
+// URLs and entities like &#35;) is left untouched.
+func Replace(text string, replace func(span Span) string) string {
+	var replaced []string
+	last := 0
+	for _, span := range Find(text) {
+		replaced = append(replaced, text[last:span.Start], replace(span))
+		last = span.End
+	}
+	return strings.Join(replaced, "") + text[last:]
+}
+
+// SplitValue splits the name of a key-value hashtag (e.g. status:done) into its key and value. The value of a
+// plain hashtag is empty.
+func SplitValue(name string) (key, value string) {
+	i := strings.Index(name, ValueSeparator)
+	if i < 0 {
+		return name, ""
+	}
+	return name[:i], name[i+len(ValueSeparator):]
+}
+
+// Normalize returns the canonical form of a hashtag name. Names with the same canonical form refer to the same
+// tag, e.g. full width and half width forms, or precomposed and decomposed accents.
+func Normalize(name string) string {
+	return norm.NFC.String(width.Fold.String(name))
+}
+
+// Fold returns the case folded normal form of a hashtag name. Names with the same folded form refer to the same
+// tag, e.g. #Go and #go.
+func Fold(name string) string {
+	return norm.NFC.String(cases.Fold().String(Normalize(name)))
+}
+
+// Rename returns text with hashtags called name (or any spelling with the same folded form) renamed to newName.
+// The hash mark used is kept. Longer hashtags that start with name are left untouched.
+func Rename(text, name, newName string) string {
+	name = Fold(name)
+	return Replace(text, func(span Span) string {
+		if Fold(span.Name) == name {
+			return string(span.Mark) + newName
+		}
+		return text[span.Start:span.End]
+	})
+}
+
+// Strip returns text with the hash mark removed from hashtags called name (or any spelling with the same folded
+// form), leaving the name as a plain word.
+func Strip(text, name string) string {
+	name = Fold(name)
+	return Replace(text, func(span Span) string {
+		if Fold(span.Name) == name {
+			return span.Name
+		}
+		return text[span.Start:span.End]
+	})
+}
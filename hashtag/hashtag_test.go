@@ -0,0 +1,176 @@
+/*
+This file is part of Tessernote.
+
+Tessernote is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+Tessernote is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with Tessernote.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package hashtag
+
+import (
+	"reflect"
+	"testing"
+)
+
+var findTests = []struct {
+	text  string
+	spans []Span
+}{
+	{"", nil},
+	{"no tags", nil},
+	{"#tag", []Span{{"tag", '#', 0, 4, 0, 4}}},
+	{"a #tag b", []Span{{"tag", '#', 2, 6, 2, 6}}},
+	{"#Go", []Span{{"Go", '#', 0, 3, 0, 3}}},
+	{"#one #two", []Span{{"one", '#', 0, 4, 0, 4}, {"two", '#', 5, 9, 5, 9}}},
+	{"(#paren)", []Span{{"paren", '#', 1, 7, 1, 7}}},
+	{"#tag_2013", []Span{{"tag_2013", '#', 0, 9, 0, 9}}},
+	{"#2013", nil},        // needs a letter
+	{"a#tag", nil},        // needs a separator before the hash mark
+	{"&#39; &#x27;", nil}, // HTML entities
+	{"#one#two", nil},     // followed by another hash mark
+	{"#scheme://x", nil},  // followed by ://
+	{"http://example.com/#anchor #tag", []Span{{"tag", '#', 27, 31, 27, 31}}},
+	{"see www.example.com/#anchor", nil},
+	{"＃wide", []Span{{"wide", '＃', 0, 7, 0, 5}}},
+	{"é #café", []Span{{"café", '#', 3, 9, 2, 7}}},
+	{"#привет мир", []Span{{"привет", '#', 0, 13, 0, 7}}},
+	{"#שלום", []Span{{"שלום", '#', 0, 9, 0, 5}}},
+	{"#مرحبا", []Span{{"مرحبا", '#', 0, 11, 0, 6}}},
+	{"#สวัสดี", []Span{{"สวัสดี", '#', 0, 19, 0, 7}}},
+	{"#안녕", []Span{{"안녕", '#', 0, 7, 0, 3}}},
+	{"#ひらがな #カタカナ", []Span{{"ひらがな", '#', 0, 13, 0, 5}, {"カタカナ", '#', 14, 27, 6, 11}}},
+	{"#ｔａｇ", []Span{{"ｔａｇ", '#', 0, 10, 0, 4}}},
+	{"#日本語 #中文", []Span{{"日本語", '#', 0, 10, 0, 4}, {"中文", '#', 11, 18, 5, 8}}},
+	{"#東京々", []Span{{"東京々", '#', 0, 10, 0, 4}}},
+	{"＃漢字", []Span{{"漢字", '＃', 0, 9, 0, 3}}},
+	{"#status:done", []Span{{"status:done", '#', 0, 12, 0, 12}}},
+	{"#priority:2.", []Span{{"priority:2", '#', 0, 11, 0, 11}}},
+	{"#size:2.5 #due:2013-05-01", []Span{{"size:2.5", '#', 0, 9, 0, 9}, {"due:2013-05-01", '#', 10, 25, 10, 25}}},
+	{"#status: done", []Span{{"status", '#', 0, 7, 0, 7}}},
+	{"#ftp://example.com", nil},
+}
+
+func TestFind(t *testing.T) {
+	for _, test := range findTests {
+		spans := Find(test.text)
+		if !reflect.DeepEqual(spans, test.spans) {
+			t.Errorf("Find(%q): expected=%v actual=%v", test.text, test.spans, spans)
+		}
+	}
+}
+
+var renameTests = []struct {
+	text, name, newName, expected string
+}{
+	{"#go", "go", "golang", "#golang"},
+	{"#go #golang", "go", "lang", "#lang #golang"},
+	{"#golang", "go", "lang", "#golang"},
+	{"#go and #go", "go", "x", "#x and #x"},
+	{"＃go", "go", "x", "＃x"},
+	{"http://example.com/#go #go", "go", "x", "http://example.com/#go #x"},
+	{"&#go #go", "go", "x", "&#go #x"},
+	{"#café au lait", "café", "coffee", "#coffee au lait"},
+	{"#日本 no change", "go", "x", "#日本 no change"},
+	{"#日本 #日本語", "日本", "にほん", "#にほん #日本語"},
+	{"#ｇｏ #go", "go", "x", "#x #x"},
+	{"#cafe\u0301", "caf\u00e9", "coffee", "#coffee"},
+	{"#Go #GO #go", "go", "Go", "#Go #Go #Go"},
+	{"#status #status:done", "status", "state", "#state #status:done"},
+	{"#status:done", "status:done", "status:closed", "#status:closed"},
+}
+
+var splitValueTests = []struct {
+	name, key, value string
+}{
+	{"tag", "tag", ""},
+	{"status:done", "status", "done"},
+	{"due:2013-05-01", "due", "2013-05-01"},
+}
+
+func TestSplitValue(t *testing.T) {
+	for _, test := range splitValueTests {
+		key, value := SplitValue(test.name)
+		if key != test.key || value != test.value {
+			t.Errorf("SplitValue(%q): expected=%q,%q actual=%q,%q", test.name, test.key, test.value, key, value)
+		}
+	}
+}
+
+var normalizeTests = []struct {
+	name, expected string
+}{
+	{"go", "go"},
+	{"ｇｏ", "go"},
+	{"ＧＯ２", "GO2"},
+	{"cafe\u0301", "caf\u00e9"},
+	{"ｶﾀｶﾅ", "カタカナ"},
+	{"日本", "日本"},
+}
+
+func TestNormalize(t *testing.T) {
+	for _, test := range normalizeTests {
+		actual := Normalize(test.name)
+		if actual != test.expected {
+			t.Errorf("Normalize(%q): expected=%q actual=%q", test.name, test.expected, actual)
+		}
+	}
+}
+
+var foldTests = []struct {
+	name, expected string
+}{
+	{"go", "go"},
+	{"Go", "go"},
+	{"GO", "go"},
+	{"ＧＯ", "go"},
+	{"Straße", "strasse"},
+	{"ПРИВЕТ", "привет"},
+	{"CAF\u0045\u0301", "caf\u00e9"},
+	{"日本", "日本"},
+}
+
+func TestFold(t *testing.T) {
+	for _, test := range foldTests {
+		actual := Fold(test.name)
+		if actual != test.expected {
+			t.Errorf("Fold(%q): expected=%q actual=%q", test.name, test.expected, actual)
+		}
+	}
+}
+
+func TestRename(t *testing.T) {
+	for _, test := range renameTests {
+		actual := Rename(test.text, test.name, test.newName)
+		if actual != test.expected {
+			t.Errorf("Rename(%q, %q, %q): expected=%q actual=%q", test.text, test.name, test.newName, test.expected, actual)
+		}
+	}
+}
+
+var stripTests = []struct {
+	text, name, expected string
+}{
+	{"#go", "go", "go"},
+	{"learn #go with #golang", "go", "learn go with #golang"},
+	{"＃go", "go", "go"},
+	{"#Go", "go", "Go"},
+}
+
+func TestStrip(t *testing.T) {
+	for _, test := range stripTests {
+		actual := Strip(test.text, test.name)
+		if actual != test.expected {
+			t.Errorf("Strip(%q, %q): expected=%q actual=%q", test.text, test.name, test.expected, actual)
+		}
+	}
+}
@@ -0,0 +1,177 @@
+/*
+This file is part of Tessernote.
+
+Tessernote is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+Tessernote is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with Tessernote.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package tessernote
+
+import (
+	"appengine"
+	"crypto/sha1"
+	"encoding/hex"
+	"strings"
+)
+
+// ImportBatchSize is the number of Notes added per transaction by Import.
+const ImportBatchSize = 20
+
+// ImportOptions change how Import adds Notes.
+type ImportOptions struct {
+	DryRun         bool // only report what would change
+	KeepDuplicates bool // import Notes even if the Notebook already has one with the same content
+}
+
+// ImportReport is what an import changed, or would change if it's a dry run.
+type ImportReport struct {
+	DryRun     bool
+	Imported   int
+	IDs        []string // of the imported Notes in input order, "" for the ones that weren't imported
+	Duplicates []int    // indexes of Notes that were skipped because their content was already in the Notebook
+	NewTags    []string // names of the Tags created for the imported Notes
+	Failures   []ImportFailure
+}
+
+// ImportFailure is a Note that couldn't be imported.
+type ImportFailure struct {
+	Index int // of the Note in the imported Notes
+	Error string
+}
+
+// Import adds notes to this Notebook in batched transactions, keeping their Created and LastModified times. If a
+// batch fails its Notes are added one at a time so a single bad Note doesn't stop the others. Notes with the
+// same content as a Note already in this Notebook (or an earlier one in notes) are skipped unless
+// options.KeepDuplicates is set.
+func (notebook *Notebook) Import(notes []Note, options ImportOptions, c appengine.Context) (ImportReport, error) {
+	report := ImportReport{DryRun: options.DryRun, IDs: make([]string, len(notes))}
+	var indexes []int // of the Notes to import
+	if options.KeepDuplicates {
+		for i := range notes {
+			indexes = append(indexes, i)
+		}
+	} else {
+		existing, err := notebook.Notes(c)
+		if err != nil {
+			return report, err
+		}
+		hashes := make(map[string]bool)
+		for _, note := range existing {
+			hashes[contentHash(note.Body)] = true
+		}
+		for i, note := range notes {
+			hash := contentHash(note.Body)
+			if hashes[hash] {
+				report.Duplicates = append(report.Duplicates, i)
+				continue
+			}
+			hashes[hash] = true
+			indexes = append(indexes, i)
+		}
+	}
+	var err error
+	report.NewTags, err = notebook.newTagNames(notes, indexes, c)
+	if err != nil {
+		return report, err
+	}
+	if options.DryRun {
+		report.Imported = len(indexes)
+		return report, nil
+	}
+
+	for start := 0; start < len(indexes); start += ImportBatchSize {
+		end := minInt(start+ImportBatchSize, len(indexes))
+		batch := make([]Note, end-start)
+		for i, j := range indexes[start:end] {
+			batch[i] = notes[j]
+		}
+		err := notebook.runInTransaction(c, func(tc appengine.Context) error {
+			for i := range batch {
+				err := notebook.insertNote(&batch[i], true, tc)
+				if err != nil {
+					return err
+				}
+			}
+			return notebook.save(tc)
+		})
+		if err == nil {
+			for i, j := range indexes[start:end] {
+				report.IDs[j] = batch[i].ID
+			}
+			report.Imported += len(batch)
+			continue
+		}
+		if Debug {
+			c.Debugf("importing notes %d-%d one at a time: %s", start, end, err)
+		}
+		err = notebook.reload(c)
+		if err != nil {
+			return report, err
+		}
+		for _, j := range indexes[start:end] {
+			note := notes[j]
+			err = notebook.runInTransaction(c, func(tc appengine.Context) error {
+				err := notebook.insertNote(&note, true, tc)
+				if err != nil {
+					return err
+				}
+				return notebook.save(tc)
+			})
+			if err != nil {
+				c.Warningf("importing note %d: %s", j, err)
+				report.Failures = append(report.Failures, ImportFailure{j, err.Error()})
+				err = notebook.reload(c)
+				if err != nil {
+					return report, err
+				}
+				continue
+			}
+			report.IDs[j] = note.ID
+			report.Imported++
+		}
+	}
+	return report, nil
+}
+
+// newTagNames returns the names of the Tags that importing the notes at indexes would create.
+func (notebook *Notebook) newTagNames(notes []Note, indexes []int, c appengine.Context) ([]string, error) {
+	allTags, err := notebook.Tags(c)
+	if err != nil {
+		return nil, err
+	}
+	var names []string
+	for _, i := range indexes {
+		for _, name := range notebook.parseTagNames(notes[i].Body) {
+			if indexOfTag(allTags, name) < 0 && !containsTagName(names, name) {
+				names = append(names, name)
+			}
+		}
+	}
+	return names, nil
+}
+
+// contentHash returns a hash of a Note's body for finding duplicates. Leading and trailing whitespace is ignored.
+func contentHash(body string) string {
+	hash := sha1.Sum([]byte(strings.TrimSpace(body)))
+	return hex.EncodeToString(hash[:])
+}
+
+// reload rereads this Notebook from the datastore, dropping changes made in memory by a failed transaction.
+func (notebook *Notebook) reload(c appengine.Context) error {
+	fresh, err := NotebookOf(notebook.ID, c)
+	if err != nil {
+		return err
+	}
+	*notebook = *fresh
+	return nil
+}
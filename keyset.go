@@ -0,0 +1,143 @@
+/*
+This file is part of Tessernote.
+
+Tessernote is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+Tessernote is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with Tessernote.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package tessernote
+
+import (
+	"appengine/datastore"
+)
+
+// keySet is an ordered set of datastore Keys. Keys are hashed by their encoded form so looking one up doesn't
+// compare (and encode) every Key in the set.
+type keySet struct {
+	keys    []*datastore.Key // in the order they were added
+	encoded []string         // encoded form of keys
+	index   map[string]int   // encoded Key -> index in keys
+}
+
+// newKeySet creates a set of keys, leaving out duplicates.
+func newKeySet(keys []*datastore.Key) *keySet {
+	s := &keySet{
+		keys:    make([]*datastore.Key, 0, len(keys)),
+		encoded: make([]string, 0, len(keys)),
+		index:   make(map[string]int, len(keys)),
+	}
+	for _, key := range keys {
+		s.add(key, key.Encode())
+	}
+	return s
+}
+
+// Len returns the number of Keys in this set.
+func (s *keySet) Len() int {
+	return len(s.keys)
+}
+
+// Keys returns the Keys in this set in the order they were added. The slice is shared with the set until the
+// set changes.
+func (s *keySet) Keys() []*datastore.Key {
+	return s.keys
+}
+
+// Contains returns true if key is in this set.
+func (s *keySet) Contains(key *datastore.Key) bool {
+	_, ok := s.index[key.Encode()]
+	return ok
+}
+
+// IndexOf returns the position of key in this set or -1 if it isn't in the set.
+func (s *keySet) IndexOf(key *datastore.Key) int {
+	if i, ok := s.index[key.Encode()]; ok {
+		return i
+	}
+	return -1
+}
+
+// Add adds key to the end of this set and returns true if it wasn't in the set already.
+func (s *keySet) Add(key *datastore.Key) bool {
+	return s.add(key, key.Encode())
+}
+
+func (s *keySet) add(key *datastore.Key, encoded string) bool {
+	if _, ok := s.index[encoded]; ok {
+		return false
+	}
+	s.index[encoded] = len(s.keys)
+	s.keys = append(s.keys, key)
+	s.encoded = append(s.encoded, encoded)
+	return true
+}
+
+// Remove removes key from this set and returns true if it was in the set. The other Keys keep their order.
+func (s *keySet) Remove(key *datastore.Key) bool {
+	encoded := key.Encode()
+	i, ok := s.index[encoded]
+	if !ok {
+		return false
+	}
+	delete(s.index, encoded)
+	// copy so slices returned by Keys before don't change
+	keys := make([]*datastore.Key, 0, len(s.keys)-1)
+	s.keys = append(append(keys, s.keys[:i]...), s.keys[i+1:]...)
+	s.encoded = append(s.encoded[:i:i], s.encoded[i+1:]...)
+	for j := i; j < len(s.encoded); j++ {
+		s.index[s.encoded[j]] = j
+	}
+	return true
+}
+
+// Union adds the Keys of other to this set.
+func (s *keySet) Union(other *keySet) {
+	for i, key := range other.keys {
+		s.add(key, other.encoded[i])
+	}
+}
+
+// Intersect returns a new set of the Keys in both this set and other, in the order of this set.
+func (s *keySet) Intersect(other *keySet) *keySet {
+	result := newKeySet(nil)
+	for i, encoded := range s.encoded {
+		if _, ok := other.index[encoded]; ok {
+			result.add(s.keys[i], encoded)
+		}
+	}
+	return result
+}
+
+// keySetOf returns *set if it's still the set of keys, otherwise it replaces *set with a new set of keys. Lists of
+// Keys that are only changed through their set (and assigned its Keys) keep using the same set.
+func keySetOf(set **keySet, keys []*datastore.Key) *keySet {
+	s := *set
+	if s == nil || s.Len() != len(keys) || (len(keys) > 0 && &s.keys[0] != &keys[0]) {
+		s = newKeySet(keys)
+		*set = s
+	}
+	return s
+}
+
+// Intersects returns true if this set and other have any Key in common.
+func (s *keySet) Intersects(other *keySet) bool {
+	if other.Len() < s.Len() {
+		s, other = other, s
+	}
+	for _, encoded := range s.encoded {
+		if _, ok := other.index[encoded]; ok {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,258 @@
+/*
+This file is part of Tessernote.
+
+Tessernote is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+Tessernote is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with Tessernote.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package tessernote
+
+import (
+	"appengine"
+	"appengine/datastore"
+	"github.com/oschmid/appenginetesting"
+	"math/rand"
+	"testing"
+)
+
+const (
+	benchmarkNotes       = 5000
+	benchmarkTags        = 200
+	benchmarkNotesPerTag = 500
+)
+
+// benchmarkNotebook returns the Keys of the Notes and the Tags of a large Notebook.
+func benchmarkNotebook(b *testing.B) (appenginetesting.Context, []*datastore.Key, []Tag) {
+	c, err := appenginetesting.NewContext(nil)
+	if err != nil {
+		b.Fatal(err)
+	}
+	notebookKey := datastore.NewKey(c, "Notebook", "benchmark", 0, nil)
+	noteKeys := make([]*datastore.Key, benchmarkNotes)
+	for i := range noteKeys {
+		noteKeys[i] = datastore.NewKey(c, "Note", "", int64(i+1), notebookKey)
+	}
+	r := rand.New(rand.NewSource(1))
+	tags := make([]Tag, benchmarkTags)
+	for i := range tags {
+		for _, j := range r.Perm(benchmarkNotes)[:benchmarkNotesPerTag] {
+			tags[i].NoteKeys = append(tags[i].NoteKeys, noteKeys[j])
+		}
+	}
+	return c, noteKeys, tags
+}
+
+// intersectKeys is how Note Keys were intersected before keySet.
+func intersectKeys(a, b []*datastore.Key) []*datastore.Key {
+	var c []*datastore.Key
+	for _, key := range a {
+		if containsKey(b, key) {
+			c = append(c, key)
+		}
+	}
+	return c
+}
+
+func TestKeySet(t *testing.T) {
+	c, err := appenginetesting.NewContext(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	keys := testKeys(c, 4)
+	s := newKeySet([]*datastore.Key{keys[0], keys[1], keys[0], keys[2]})
+	if s.Len() != 3 {
+		t.Fatalf("expected=%d actual=%d", 3, s.Len())
+	}
+	if !s.Contains(keys[1]) || s.Contains(keys[3]) {
+		t.Fatalf("contains: %v", s.Keys())
+	}
+	if !s.Remove(keys[1]) || s.Remove(keys[1]) || s.IndexOf(keys[2]) != 1 {
+		t.Fatalf("remove: %v", s.Keys())
+	}
+	other := newKeySet([]*datastore.Key{keys[3], keys[2]})
+	if both := s.Intersect(other); both.Len() != 1 || !both.Contains(keys[2]) {
+		t.Fatalf("intersect: %v", both.Keys())
+	}
+	s.Union(other)
+	if s.Len() != 3 || s.IndexOf(keys[3]) != 2 {
+		t.Fatalf("union: %v", s.Keys())
+	}
+}
+
+// testKeys returns n distinct Note Keys.
+func testKeys(c appengine.Context, n int) []*datastore.Key {
+	keys := make([]*datastore.Key, n)
+	for i := range keys {
+		keys[i] = datastore.NewKey(c, "Note", "", int64(i+1), nil)
+	}
+	return keys
+}
+
+func BenchmarkContainsKey(b *testing.B) {
+	c, noteKeys, _ := benchmarkNotebook(b)
+	defer c.Close()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		containsKey(noteKeys, noteKeys[i%len(noteKeys)])
+	}
+}
+
+func BenchmarkKeySetContains(b *testing.B) {
+	c, noteKeys, _ := benchmarkNotebook(b)
+	defer c.Close()
+	set := newKeySet(noteKeys)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		set.Contains(noteKeys[i%len(noteKeys)])
+	}
+}
+
+func BenchmarkIntersectKeys(b *testing.B) {
+	c, _, tags := benchmarkNotebook(b)
+	defer c.Close()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		intersectKeys(tags[i%len(tags)].NoteKeys, tags[(i+1)%len(tags)].NoteKeys)
+	}
+}
+
+func BenchmarkKeySetIntersect(b *testing.B) {
+	c, _, tags := benchmarkNotebook(b)
+	defer c.Close()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		tags[i%len(tags)].noteKeySet().Intersect(tags[(i+1)%len(tags)].noteKeySet())
+	}
+}
+
+// BenchmarkRelatedTagsSlices finds the Tags sharing Notes with one Tag by comparing lists of Keys.
+func BenchmarkRelatedTagsSlices(b *testing.B) {
+	c, _, tags := benchmarkNotebook(b)
+	defer c.Close()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		selected := tags[i%len(tags)]
+		for _, tag := range tags {
+			for _, key := range tag.NoteKeys {
+				if containsKey(selected.NoteKeys, key) {
+					break
+				}
+			}
+		}
+	}
+}
+
+// BenchmarkRelatedTagsKeySet finds the Tags sharing Notes with one Tag with their cached Key sets.
+func BenchmarkRelatedTagsKeySet(b *testing.B) {
+	c, _, tags := benchmarkNotebook(b)
+	defer c.Close()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		selected := groupNoteKeys(tags[i%len(tags) : i%len(tags)+1])
+		for j := range tags {
+			tags[j].noteKeySet().Intersects(selected)
+		}
+	}
+}
+
+// matchingKeys is how the Keys of Notes referred to by every one of a set of Tags were found before keySet.
+func matchingKeys(tags []Tag) []*datastore.Key {
+	noteKeys := tags[0].NoteKeys
+	for i := 1; i < len(tags); i++ {
+		noteKeys = intersectKeys(noteKeys, tags[i].NoteKeys)
+	}
+	return noteKeys
+}
+
+// BenchmarkRelatedNotesSlices finds the Notes shared by two Tags, like RelatedNotes did with lists of Keys.
+func BenchmarkRelatedNotesSlices(b *testing.B) {
+	c, _, tags := benchmarkNotebook(b)
+	defer c.Close()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		matchingKeys([]Tag{tags[i%len(tags)], tags[(i+1)%len(tags)]})
+	}
+}
+
+// BenchmarkRelatedNotesKeySet finds the Notes shared by two Tags like RelatedNotes does.
+func BenchmarkRelatedNotesKeySet(b *testing.B) {
+	c, _, tags := benchmarkNotebook(b)
+	defer c.Close()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		matchingNoteKeys([][]Tag{tags[i%len(tags) : i%len(tags)+1], tags[(i+1)%len(tags) : (i+1)%len(tags)+1]})
+	}
+}
+
+// benchmarkTagKeys returns the Keys of the Tags of a large Notebook and the Tag Keys of a Note with a few Tags.
+func benchmarkTagKeys(c appengine.Context) (tagKeys, noteTagKeys []*datastore.Key) {
+	notebookKey := datastore.NewKey(c, "Notebook", "benchmark", 0, nil)
+	tagKeys = make([]*datastore.Key, benchmarkTags)
+	for i := range tagKeys {
+		tagKeys[i] = datastore.NewKey(c, "Tag", "", int64(i+1), notebookKey)
+	}
+	return tagKeys, []*datastore.Key{tagKeys[benchmarkTags/3], tagKeys[benchmarkTags/2], tagKeys[benchmarkTags-1]}
+}
+
+// BenchmarkTagsOfSlices finds the Tags of a Note like TagsOf did by searching the list of Tag Keys.
+func BenchmarkTagsOfSlices(b *testing.B) {
+	c, _, _ := benchmarkNotebook(b)
+	defer c.Close()
+	tagKeys, noteTagKeys := benchmarkTagKeys(c)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		for _, key := range noteTagKeys {
+			indexOfKey(tagKeys, key)
+		}
+	}
+}
+
+// BenchmarkTagsOfKeySet finds the Tags of a Note like TagsOf does.
+func BenchmarkTagsOfKeySet(b *testing.B) {
+	c, _, _ := benchmarkNotebook(b)
+	defer c.Close()
+	tagKeys, noteTagKeys := benchmarkTagKeys(c)
+	notebook := &Notebook{TagKeys: tagKeys}
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		for _, key := range noteTagKeys {
+			notebook.indexOfTagKey(key)
+		}
+	}
+}
+
+// BenchmarkUpdateTagsSlices moves a Note between Tags like updateTags did with lists of Keys.
+func BenchmarkUpdateTagsSlices(b *testing.B) {
+	c, noteKeys, tags := benchmarkNotebook(b)
+	defer c.Close()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		key := noteKeys[i%len(noteKeys)]
+		from, to := &tags[i%len(tags)], &tags[(i+1)%len(tags)]
+		from.NoteKeys = removeKey(from.NoteKeys, key)
+		to.NoteKeys = addKey(to.NoteKeys, key)
+	}
+}
+
+// BenchmarkUpdateTagsKeySet moves a Note between Tags like updateTags does.
+func BenchmarkUpdateTagsKeySet(b *testing.B) {
+	c, noteKeys, tags := benchmarkNotebook(b)
+	defer c.Close()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		key := noteKeys[i%len(noteKeys)]
+		tags[i%len(tags)].removeNoteKey(key)
+		tags[(i+1)%len(tags)].addNoteKey(key)
+	}
+}
@@ -0,0 +1,162 @@
+/*
+This file is part of Tessernote.
+
+Tessernote is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+Tessernote is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with Tessernote.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package tessernote
+
+import (
+	"appengine"
+	"appengine/datastore"
+	"github.com/oschmid/cachestore"
+	"github.com/oschmid/tessernote/hashtag"
+)
+
+// schemaVersion is the Notebook.SchemaVersion of notebooks that don't need migrating.
+//
+// Versions:
+//  1. Tags are identified by their case folded name (Tag.FoldedName) and track their spellings.
+const schemaVersion = 1
+
+// migrate updates this Notebook and its Tags and Notes from older schema versions.
+func (notebook *Notebook) migrate(c appengine.Context) error {
+	if notebook.SchemaVersion >= schemaVersion {
+		return nil
+	}
+	err := notebook.runInTransaction(c, func(tc appengine.Context) error {
+		if notebook.SchemaVersion < 1 {
+			err := notebook.mergeCaseVariants(tc)
+			if err != nil {
+				return err
+			}
+		}
+		notebook.SchemaVersion = schemaVersion
+		return notebook.save(tc)
+	})
+	return err
+}
+
+// mergeCaseVariants sets the folded names and spellings of this Notebook's Tags and merges Tags whose names only
+// differ in case. Notes of merged Tags are updated to refer to the Tag they were merged into.
+func (notebook *Notebook) mergeCaseVariants(c appengine.Context) error {
+	allTags, err := notebook.Tags(c)
+	if err != nil {
+		return err
+	}
+	for i := range allTags {
+		tag := &allTags[i]
+		tag.FoldedName = hashtag.Fold(tag.Name)
+		if len(tag.Spellings) == 0 {
+			tag.Spellings = []string{tag.Name}
+			tag.SpellingCounts = []int{len(tag.NoteKeys)}
+		}
+	}
+
+	// merge each Tag into the first Tag with the same folded name that has the most Notes
+	into := make(map[string]int)
+	for i, tag := range allTags {
+		j, ok := into[tag.FoldedName]
+		if !ok || len(tag.NoteKeys) > len(allTags[j].NoteKeys) {
+			into[tag.FoldedName] = i
+		}
+	}
+	var keys, deleted []*datastore.Key
+	var tags, merged []Tag
+	noteKeySet := newKeySet(nil)
+	mergedInto := make(map[string]*datastore.Key) // encoded Key of merged Tag -> Key of Tag merged into
+	for i, tag := range allTags {
+		j := into[tag.FoldedName]
+		if i == j {
+			continue
+		}
+		// a Note using both spellings is only counted once, for the spelling of the Tag it's merged into
+		counts := append([]int(nil), tag.SpellingCounts...)
+		spelling := indexOfString(tag.Spellings, tag.Name)
+		for _, key := range tag.NoteKeys {
+			if allTags[j].noteKeySet().Contains(key) && spelling >= 0 && counts[spelling] > 0 {
+				counts[spelling]--
+			}
+			allTags[j].addNoteKey(key)
+			noteKeySet.Add(key)
+		}
+		tag.SpellingCounts = counts
+		allTags[j].mergeSpellings(tag)
+		deleted = append(deleted, notebook.TagKeys[i])
+		merged = append(merged, tag)
+		mergedInto[notebook.TagKeys[i].Encode()] = notebook.TagKeys[j]
+	}
+	for i, tag := range allTags {
+		if into[tag.FoldedName] == i {
+			keys = append(keys, notebook.TagKeys[i])
+			tags = append(tags, tag)
+		}
+	}
+
+	// update notes of merged tags
+	noteKeys := noteKeySet.Keys()
+	if len(noteKeys) > 0 {
+		notes := make([]Note, len(noteKeys))
+		err = cachestore.GetMulti(c, noteKeys, notes)
+		if err != nil {
+			c.Errorf("getting notes of merged tags: %s", err)
+			return err
+		}
+		for i := range notes {
+			var tagKeys []*datastore.Key
+			for _, key := range notes[i].TagKeys {
+				if intoKey, ok := mergedInto[key.Encode()]; ok {
+					key = intoKey
+				}
+				tagKeys = addKey(tagKeys, key)
+			}
+			notes[i].TagKeys = tagKeys
+		}
+		if Debug {
+			c.Debugf("updating notes of merged tags: %#v", notes)
+		}
+		_, err = putEntities(c, noteKeys, notes)
+		if err != nil {
+			c.Errorf("updating notes of merged tags: %s", err)
+			return err
+		}
+	}
+
+	// update tags
+	if len(keys) > 0 {
+		if Debug {
+			c.Debugf("updating tags: %#v", tags)
+		}
+		_, err = putTags(c, keys, tags)
+		if err != nil {
+			c.Errorf("updating tags: %s", err)
+			return err
+		}
+	}
+	if len(deleted) > 0 {
+		if Debug {
+			c.Debugf("deleting merged tags: %#v", deleted)
+		}
+		err = deleteTags(c, deleted)
+		if err != nil {
+			c.Errorf("deleting merged tags: %s", err)
+			return err
+		}
+		notebook.Order.Cleanup(merged)
+	}
+	notebook.TagKeys = keys
+	notebook.tags = tags
+	notebook.sortTags()
+	return nil
+}
@@ -0,0 +1,355 @@
+/*
+This file is part of Tessernote.
+
+Tessernote is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+Tessernote is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with Tessernote.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package tessernote
+
+import (
+	"appengine"
+	"appengine/datastore"
+	"appengine/taskqueue"
+	"bytes"
+	"encoding/gob"
+	"errors"
+	"github.com/oschmid/cachestore"
+	"github.com/oschmid/tessernote/bayes"
+	"github.com/oschmid/tessernote/graph"
+	"github.com/oschmid/tessernote/tfidf"
+	"net/url"
+	"sort"
+)
+
+const (
+	// modelChunkSize is the number of bytes of an encoded model stored per entity, well below the datastore's
+	// entity size limit.
+	modelChunkSize = 512 << 10
+	// UpdateModelsURL is where the task queue sends the tasks that apply changes to a Notebook's models, see
+	// UpdateModels.
+	UpdateModelsURL = "/admin/models"
+)
+
+var errStaleModel = errors.New("tessernote: notes changed while building model")
+
+// modelEntity stores a model learned from a Notebook's Notes (e.g. its tag classifier) in the datastore. Models
+// are gob encoded and split into chunks stored as children of the modelEntity so they can grow past the datastore's
+// entity size limit.
+type modelEntity struct {
+	Chunks  int
+	Model   []byte // models stored before they were chunked
+	Version int64  // of the Notebook, the model includes the modelChanges up to it
+}
+
+// modelChunk is part of an encoded model.
+type modelChunk struct {
+	Bytes []byte
+}
+
+// modelChange records how a Note changed for the models learned from Notes. Writes only store modelChanges (as
+// children of the Notebook, in the same transaction) instead of rewriting every model, UpdateModels applies them
+// to the stored models later in a task and readModel applies the ones it hasn't got to yet.
+type modelChange struct {
+	Version    int64  // of the Notebook the change was saved in
+	Seq        int    // order of the change within the save
+	Note       string // encoded Key
+	Deleted    bool
+	OldWords   []string       `datastore:",noindex"`
+	Words      []string       `datastore:",noindex"`
+	OldClasses []string       `datastore:",noindex"`
+	Classes    []string       `datastore:",noindex"`
+	key        *datastore.Key `datastore:"-"`
+}
+
+// learnedModel is one of the models learned from a Notebook's Notes, see learnedModels.
+type learnedModel struct {
+	key   *datastore.Key
+	model interface{}       // read by getModel
+	apply func(modelChange) // updates model for a change
+}
+
+// learnedModels returns all of this Notebook's models, empty.
+func (notebook *Notebook) learnedModels(c appengine.Context) []learnedModel {
+	return []learnedModel{
+		notebook.classifierModel(bayes.New(), c),
+		notebook.tagGraphModel(graph.New(), c),
+		notebook.similarityModel(tfidf.New(), c),
+	}
+}
+
+// modelChunkKeys returns the Keys of the first n chunks of the model stored with key.
+func modelChunkKeys(key *datastore.Key, n int, c appengine.Context) []*datastore.Key {
+	keys := make([]*datastore.Key, n)
+	for i := range keys {
+		keys[i] = datastore.NewKey(c, "ModelChunk", "", int64(i+1), key)
+	}
+	return keys
+}
+
+// getModel reads the model stored with key into model and returns the Notebook version it was stored at.
+func getModel(key *datastore.Key, model interface{}, c appengine.Context) (int64, error) {
+	var entity modelEntity
+	err := cachestore.Get(c, key, &entity)
+	if err != nil {
+		return 0, err
+	}
+	if entity.Chunks == 0 {
+		return entity.Version, gob.NewDecoder(bytes.NewReader(entity.Model)).Decode(model)
+	}
+	chunks := make([]modelChunk, entity.Chunks)
+	err = cachestore.GetMulti(c, modelChunkKeys(key, entity.Chunks, c), chunks)
+	if err != nil {
+		return 0, err
+	}
+	encoded := new(bytes.Buffer)
+	for _, chunk := range chunks {
+		encoded.Write(chunk.Bytes)
+	}
+	return entity.Version, gob.NewDecoder(encoded).Decode(model)
+}
+
+// putModel stores model with key at a Notebook version, replacing the chunks of the model stored before.
+func putModel(key *datastore.Key, model interface{}, version int64, c appengine.Context) error {
+	buffer := new(bytes.Buffer)
+	err := gob.NewEncoder(buffer).Encode(model)
+	if err != nil {
+		return err
+	}
+	var old modelEntity
+	err = cachestore.Get(c, key, &old)
+	if err != nil && err != datastore.ErrNoSuchEntity {
+		return err
+	}
+	encoded := buffer.Bytes()
+	var chunks []modelChunk
+	for len(encoded) > modelChunkSize {
+		chunks = append(chunks, modelChunk{encoded[:modelChunkSize]})
+		encoded = encoded[modelChunkSize:]
+	}
+	chunks = append(chunks, modelChunk{encoded})
+	_, err = putEntities(c, modelChunkKeys(key, len(chunks), c), chunks)
+	if err != nil {
+		return err
+	}
+	if old.Chunks > len(chunks) {
+		err = deleteEntities(c, modelChunkKeys(key, old.Chunks, c)[len(chunks):])
+		if err != nil {
+			return err
+		}
+	}
+	_, err = putEntity(c, key, &modelEntity{Chunks: len(chunks), Version: version})
+	return err
+}
+
+// deleteModel deletes the model stored with key.
+func deleteModel(key *datastore.Key, c appengine.Context) error {
+	var entity modelEntity
+	err := cachestore.Get(c, key, &entity)
+	if err == datastore.ErrNoSuchEntity {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	return deleteEntities(c, append(modelChunkKeys(key, entity.Chunks, c), key))
+}
+
+// readModel reads a stored model and applies the changes UpdateModels hasn't applied to it yet. Returns
+// datastore.ErrNoSuchEntity if it doesn't exist yet.
+func (notebook *Notebook) readModel(m learnedModel, c appengine.Context) error {
+	version, err := getModel(m.key, m.model, c)
+	if err != nil {
+		return err
+	}
+	changes, err := notebook.modelChanges(c)
+	if err != nil {
+		return err
+	}
+	for _, change := range changes {
+		if change.Version > version {
+			m.apply(change)
+		}
+	}
+	return nil
+}
+
+// buildModel learns a model that doesn't exist yet from all of this Notebook's Notes. It runs outside of
+// transactions so writes never have to read every Note. The model is only stored if no Note changed while it was
+// built, otherwise errStaleModel is returned and it is built again next time.
+func (notebook *Notebook) buildModel(key *datastore.Key, model interface{}, learn func(Note), c appengine.Context) error {
+	version := notebook.Version
+	notes, err := notebook.Notes(c)
+	if err != nil {
+		return err
+	}
+	for _, note := range notes {
+		learn(note)
+	}
+	return notebook.runInTransaction(c, func(tc appengine.Context) error {
+		var stored Notebook
+		err := datastore.Get(tc, notebook.Key(tc), &stored)
+		if err != nil {
+			return err
+		}
+		var entity modelEntity
+		err = datastore.Get(tc, key, &entity)
+		if err == nil || stored.Version != version {
+			return errStaleModel
+		} else if err != datastore.ErrNoSuchEntity {
+			return err
+		}
+		return putModel(key, model, version, tc)
+	})
+}
+
+// noteChanged records the change from oldNote to note for the models learned from this Notebook's Notes, see
+// saveModels. note is empty if it was deleted.
+func (notebook *Notebook) noteChanged(key *datastore.Key, oldNote, note *Note, c appengine.Context) error {
+	change := modelChange{
+		Note:       key.Encode(),
+		Deleted:    note.ID == "",
+		OldWords:   noteWords(*oldNote),
+		Words:      noteWords(*note),
+		OldClasses: noteClasses(*oldNote),
+		Classes:    noteClasses(*note),
+	}
+	if !change.Deleted && equalStrings(change.OldWords, change.Words) &&
+		equalStrings(change.OldClasses, change.Classes) {
+		return nil // e.g. only pinned
+	}
+	notebook.changes = append(notebook.changes, change)
+	return nil
+}
+
+// saveModels stores the changes to Notes recorded since the last save and adds a task to apply them to the
+// models once the transaction commits. Writes stay as small as the Notes they change, however large the models
+// are.
+func (notebook *Notebook) saveModels(c appengine.Context) error {
+	if len(notebook.changes) == 0 {
+		return nil
+	}
+	keys := make([]*datastore.Key, len(notebook.changes))
+	for i := range notebook.changes {
+		notebook.changes[i].Version = notebook.savedVersion
+		notebook.changes[i].Seq = i
+		keys[i] = datastore.NewIncompleteKey(c, "ModelChange", notebook.Key(c))
+	}
+	_, err := datastore.PutMulti(c, keys, notebook.changes)
+	if err != nil {
+		c.Errorf("recording model changes: %s", err)
+		return err
+	}
+	notebook.changes = nil
+	notebook.modelsChanged = true
+	_, err = taskqueue.Add(c, taskqueue.NewPOSTTask(UpdateModelsURL, url.Values{"notebook": {notebook.ID}}), "")
+	if err != nil {
+		c.Errorf("adding model update task: %s", err)
+	}
+	return err
+}
+
+// modelChanges returns the changes UpdateModels hasn't deleted yet in the order they were saved.
+func (notebook *Notebook) modelChanges(c appengine.Context) ([]modelChange, error) {
+	var changes []modelChange
+	keys, err := datastore.NewQuery("ModelChange").Ancestor(notebook.Key(c)).GetAll(c, &changes)
+	if err != nil {
+		c.Errorf("getting model changes: %s", err)
+		return nil, err
+	}
+	for i := range changes {
+		changes[i].key = keys[i]
+	}
+	sort.SliceStable(changes, func(i, j int) bool {
+		if changes[i].Version != changes[j].Version {
+			return changes[i].Version < changes[j].Version
+		}
+		return changes[i].Seq < changes[j].Seq
+	})
+	return changes, nil
+}
+
+// UpdateModels applies the changes to Notes saved since the models of the Notebook with id were stored to them and
+// deletes the changes. Notebook writes add a task for it, see saveModels.
+func UpdateModels(id string, c appengine.Context) error {
+	notebook := &Notebook{ID: id}
+	var stored Notebook
+	err := datastore.Get(c, notebook.Key(c), &stored)
+	if err != nil {
+		return err
+	}
+	for i := range notebook.learnedModels(c) {
+		err = runTracked(c, func(tc appengine.Context) error {
+			m := notebook.learnedModels(tc)[i]
+			version, err := getModel(m.key, m.model, tc)
+			if err == datastore.ErrNoSuchEntity {
+				return nil // buildModel learns it from all Notes
+			} else if err != nil {
+				return err
+			}
+			changes, err := notebook.modelChanges(tc)
+			if err != nil {
+				return err
+			}
+			applied := false
+			for _, change := range changes {
+				if change.Version > version {
+					m.apply(change)
+					version = change.Version
+					applied = true
+				}
+			}
+			if !applied {
+				return nil
+			}
+			return putModel(m.key, m.model, version, tc)
+		})
+		if err != nil {
+			c.Errorf("updating models: %s", err)
+			return err
+		}
+	}
+
+	// every model now includes the changes up to stored.Version, those that don't exist yet will be built later
+	changes, err := notebook.modelChanges(c)
+	if err != nil {
+		return err
+	}
+	var keys []*datastore.Key
+	for _, change := range changes {
+		if change.Version <= stored.Version {
+			keys = append(keys, change.key)
+		}
+	}
+	return datastore.DeleteMulti(c, keys)
+}
+
+// deleteModels deletes the models learned from this Notebook's Notes and the changes not applied to them yet.
+func (notebook *Notebook) deleteModels(c appengine.Context) error {
+	notebook.forgetModels()
+	for _, key := range []*datastore.Key{notebook.classifierKey(c), notebook.tagGraphKey(c), notebook.similarityKey(c)} {
+		err := deleteModel(key, c)
+		if err != nil {
+			return err
+		}
+	}
+	keys, err := datastore.NewQuery("ModelChange").Ancestor(notebook.Key(c)).KeysOnly().GetAll(c, nil)
+	if err != nil {
+		return err
+	}
+	return datastore.DeleteMulti(c, keys)
+}
+
+// forgetModels drops the models this Notebook has read so they're read again with the latest changes.
+func (notebook *Notebook) forgetModels() {
+	notebook.classifier, notebook.graph, notebook.similarity = nil, nil, nil
+}
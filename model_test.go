@@ -0,0 +1,92 @@
+/*
+This file is part of Tessernote.
+
+Tessernote is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+Tessernote is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with Tessernote.  If not, see <http://www.gnu.org/licenses/>.
+*/
+package tessernote
+
+import (
+	"appengine"
+	"github.com/oschmid/appenginetesting"
+	"testing"
+)
+
+// checkSimilar fails the test unless SimilarNotes finds count Notes like body.
+func checkSimilar(t *testing.T, c appengine.Context, notebook *Notebook, body string, count int) {
+	notes, err := notebook.SimilarNotes(Note{Body: body}, c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(notes) != count {
+		t.Errorf("%q: expected=%d similar notes actual=%d", body, count, len(notes))
+	}
+}
+
+// checkModelChanges fails the test unless count changes haven't been applied by UpdateModels yet.
+func checkModelChanges(t *testing.T, c appengine.Context, notebook *Notebook, count int) {
+	changes, err := notebook.modelChanges(c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(changes) != count {
+		t.Errorf("expected=%d model changes actual=%d", count, len(changes))
+	}
+}
+
+func TestUpdateModels(t *testing.T) {
+	c, err := appenginetesting.NewContext(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	notebook, _ := newTestNotebook(t, c, "models", "golang compiler toolchain #go", "python interpreter #py")
+	checkSimilar(t, c, notebook, "golang compiler toolchain", 1) // builds the index from all notes
+	err = UpdateModels(notebook.ID, c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkModelChanges(t, c, notebook, 0)
+
+	// saves only record the change, reads apply it until the task does
+	note, err := notebook.Put(Note{Body: "golang compiler toolchain gc #go"}, c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkModelChanges(t, c, notebook, 1)
+	checkSimilar(t, c, notebook, "golang compiler toolchain", 2)
+	_, err = notebook.Put(Note{ID: note.ID, Body: note.Body, Pinned: true}, c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkModelChanges(t, c, notebook, 1) // the words and tags didn't change
+	err = UpdateModels(notebook.ID, c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkModelChanges(t, c, notebook, 0)
+	checkSimilar(t, c, notebook, "golang compiler toolchain", 2)
+
+	_, err = notebook.Delete(note.ID, c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkModelChanges(t, c, notebook, 1)
+	checkSimilar(t, c, notebook, "golang compiler toolchain", 1)
+	err = UpdateModels(notebook.ID, c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkModelChanges(t, c, notebook, 0)
+	checkSimilar(t, c, notebook, "golang compiler toolchain", 1)
+}
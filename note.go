@@ -20,6 +20,9 @@ package tessernote
 import (
 	"appengine"
 	"appengine/datastore"
+	"encoding/json"
+	"sort"
+	"strings"
 	"time"
 )
 
@@ -30,6 +33,12 @@ type Note struct {
 	LastModified time.Time
 	TagKeys      []*datastore.Key
 	NotebookKeys []*datastore.Key
+	Pinned       bool     `json:",omitempty"`
+	Archived     bool     `json:",omitempty"`
+	Duplicates   []string `datastore:"-" json:",omitempty"` // IDs of likely duplicates, only set on creation
+	keepPinned   bool     // Pinned was left out of the JSON this Note was decoded from, see UnmarshalJSON
+	keepArchived bool
+	lastModified time.Time // the stored Note must still have, see PutIfUnmodified
 }
 
 // Key decodes this Note's unique datastore Key from note.ID.
@@ -43,3 +52,48 @@ func (note Note) Key(c appengine.Context) *datastore.Key {
 	}
 	return key
 }
+
+// UnmarshalJSON decodes a Note from JSON. Updates that leave out Pinned or Archived (e.g. from clients that only
+// send a Note's ID and Body) keep the values of the Note they replace.
+func (note *Note) UnmarshalJSON(data []byte) error {
+	type plainNote Note // without this method
+	err := json.Unmarshal(data, (*plainNote)(note))
+	if err != nil {
+		return err
+	}
+	var fields map[string]json.RawMessage
+	err = json.Unmarshal(data, &fields)
+	if err != nil {
+		return err
+	}
+	note.keepPinned, note.keepArchived = true, true
+	for name := range fields {
+		if strings.EqualFold(name, "Pinned") { // matched like encoding/json matches field names
+			note.keepPinned = false
+		} else if strings.EqualFold(name, "Archived") {
+			note.keepArchived = false
+		}
+	}
+	return nil
+}
+
+// SortNotes sorts notes in order, one of AlphaAscending, AlphaDescending, LastModified, FirstModified, LastCreated
+// or FirstCreated. Alphabetical orders ignore case. Unknown orders sort alphabetically.
+func SortNotes(notes []Note, order string) {
+	var less func(a, b Note) bool
+	switch order {
+	case AlphaDescending:
+		less = func(a, b Note) bool { return strings.ToLower(a.Body) > strings.ToLower(b.Body) }
+	case LastModified:
+		less = func(a, b Note) bool { return a.LastModified.After(b.LastModified) }
+	case FirstModified:
+		less = func(a, b Note) bool { return a.LastModified.Before(b.LastModified) }
+	case LastCreated:
+		less = func(a, b Note) bool { return a.Created.After(b.Created) }
+	case FirstCreated:
+		less = func(a, b Note) bool { return a.Created.Before(b.Created) }
+	default:
+		less = func(a, b Note) bool { return strings.ToLower(a.Body) < strings.ToLower(b.Body) }
+	}
+	sort.SliceStable(notes, func(i, j int) bool { return less(notes[i], notes[j]) })
+}
@@ -0,0 +1,135 @@
+/*
+This file is part of Tessernote.
+
+Tessernote is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+Tessernote is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with Tessernote.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package tessernote
+
+import (
+	"appengine/datastore"
+	"encoding/json"
+	"github.com/oschmid/appenginetesting"
+	"testing"
+)
+
+func TestNoteUnmarshalJSON(t *testing.T) {
+	tests := []struct {
+		json                     string
+		keepPinned, keepArchived bool
+	}{
+		{`{"ID":"a","Body":"b"}`, true, true},
+		{`{"ID":"a","Body":"b","Pinned":false}`, false, true},
+		{`{"ID":"a","Body":"b","archived":true}`, true, false},
+		{`{"Pinned":true,"Archived":false}`, false, false},
+	}
+	for _, test := range tests {
+		var note Note
+		err := json.Unmarshal([]byte(test.json), &note)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if note.Body != "" && (note.ID != "a" || note.Body != "b") {
+			t.Errorf("%s: unexpected note %#v", test.json, note)
+		}
+		if note.keepPinned != test.keepPinned || note.keepArchived != test.keepArchived {
+			t.Errorf("%s: expected keep=%t,%t actual=%t,%t", test.json, test.keepPinned, test.keepArchived,
+				note.keepPinned, note.keepArchived)
+		}
+	}
+}
+
+func TestUpdateKeepsFlags(t *testing.T) {
+	c, err := appenginetesting.NewContext(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	notebook := &Notebook{ID: "flags"}
+	_, err = datastore.Put(c, notebook.Key(c), notebook)
+	if err != nil {
+		t.Fatal(err)
+	}
+	note, err := notebook.Put(Note{Body: "pinned #a", Pinned: true, Archived: true}, c)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// like the web UI, which only sends a Note's ID and Body
+	var update Note
+	err = json.Unmarshal([]byte(`{"ID":"`+note.ID+`","Body":"still pinned #a"}`), &update)
+	if err != nil {
+		t.Fatal(err)
+	}
+	note, err = notebook.Put(update, c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !note.Pinned || !note.Archived {
+		t.Fatalf("expected pinned and archived note, actual=%#v", note)
+	}
+
+	err = json.Unmarshal([]byte(`{"ID":"`+note.ID+`","Body":"unpinned #a","Pinned":false}`), &update)
+	if err != nil {
+		t.Fatal(err)
+	}
+	note, err = notebook.Put(update, c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stored, err := notebook.Note(note.ID, c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stored.Pinned || !stored.Archived || stored.Body != "unpinned #a" {
+		t.Fatalf("expected unpinned archived note, actual=%#v", stored)
+	}
+}
+
+func TestPutIfUnmodified(t *testing.T) {
+	c, err := appenginetesting.NewContext(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	notebook, ids := newTestNotebook(t, c, "unmodified", "first #a")
+	note, err := notebook.Note(ids[0], c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	read := note.LastModified
+	note.ID, note.Body = ids[0], "second #a"
+	note, err = notebook.PutIfUnmodified(note, read, c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	note.Body = "lost update #a"
+	_, err = notebook.PutIfUnmodified(note, read, c)
+	if err != ErrNoteChanged {
+		t.Errorf("expected=%s actual=%v", ErrNoteChanged, err)
+	}
+	_, err = notebook.DeleteIfUnmodified(note.ID, read, c)
+	if err != ErrNoteChanged {
+		t.Errorf("expected=%s actual=%v", ErrNoteChanged, err)
+	}
+	checkBodies(t, c, notebook, ids, "second #a")
+	deleted, err := notebook.DeleteIfUnmodified(note.ID, note.LastModified, c)
+	if err != nil || !deleted {
+		t.Fatalf("expected=true actual=%t, %v", deleted, err)
+	}
+	_, err = notebook.PutIfUnmodified(note, note.LastModified, c)
+	if err != ErrNoteChanged {
+		t.Errorf("expected=%s actual=%v", ErrNoteChanged, err)
+	}
+}
@@ -20,18 +20,30 @@ package tessernote
 import (
 	"appengine"
 	"appengine/datastore"
-	"appengine/memcache"
 	"appengine/user"
 	"bytes"
 	"encoding/gob"
 	"errors"
 	"github.com/oschmid/cachestore"
+	"github.com/oschmid/tessernote/bayes"
+	"github.com/oschmid/tessernote/graph"
+	"github.com/oschmid/tessernote/hashtag"
 	"github.com/oschmid/tessernote/rl"
+	"github.com/oschmid/tessernote/tfidf"
+	"sort"
 	"time"
 )
 
 var Debug = false // If true, print debug info
 
+var (
+	ErrMissingTag  = errors.New("tessernote: missing tag")
+	ErrMissingNote = errors.New("tessernote: missing note")
+	ErrNoteChanged = errors.New("tessernote: note changed")
+	ErrTagExists   = errors.New("tessernote: tag already exists")
+	ErrInvalidTag  = errors.New("tessernote: invalid tag name")
+)
+
 func init() {
 	gob.Register(Notebook{})
 	gob.Register(Note{})
@@ -41,15 +53,34 @@ func init() {
 }
 
 type Notebook struct {
-	ID               string // user.User.ID
-	Name             string
-	TagKeys          []*datastore.Key // sorted by Tag.Name
-	NoteKeys         []*datastore.Key
-	UntaggedNoteKeys []*datastore.Key
-	Order            Order  `datastore:"-"`
-	tags             []Tag  // cache
-	notes            []Note // cache
-	untaggedNotes    []Note // cache
+	ID                string // user.User.ID
+	Name              string
+	TagKeys           []*datastore.Key // sorted by Tag.FoldedName once Tags are read
+	NoteKeys          []*datastore.Key
+	UntaggedNoteKeys  []*datastore.Key // lists of Keys are stored in shards, see syncKeyShards
+	NoteKeyShards     int              // number of shards of each list
+	TagKeyShards      int
+	UntaggedKeyShards int
+	Aliases           []Alias
+	SchemaVersion     int               // see migrate
+	Version           int64             // incremented on every committed save, see checkVersion
+	Tokens            []string          // kind:secret, see Token
+	Order             Order             `datastore:"-"`
+	tags              []Tag             // cache
+	notes             []Note            // cache
+	untaggedNotes     []Note            // cache
+	classifier        *bayes.Classifier // cache, see tagClassifier
+	graph             *graph.Graph      // cache, see TagGraph
+	similarity        *tfidf.Index      // cache, see similarityIndex
+	changes           []modelChange     // not saved yet, see noteChanged
+	modelsChanged     bool              // since the transaction began, see saveModels
+	noteSet           *keySet           // index of NoteKeys, see keySetOf
+	tagSet            *keySet           // index of TagKeys
+	untaggedSet       *keySet           // index of UntaggedNoteKeys
+	noteShards        *keyShards
+	tagShards         *keyShards
+	untaggedShards    *keyShards
+	savedVersion      int64 // Version saved by a transaction that didn't commit yet
 }
 
 func (notebook *Notebook) Load(c <-chan datastore.Property) error {
@@ -78,8 +109,10 @@ func (notebook *Notebook) Save(c chan<- datastore.Property) error {
 		Value:   buffer.Bytes(),
 		NoIndex: true,
 	}
-	// save the rest
-	return datastore.SaveStruct(notebook, c)
+	// save the rest, without the lists of Keys stored in shards
+	entity := *notebook
+	entity.NoteKeys, entity.TagKeys, entity.UntaggedNoteKeys = nil, nil, nil
+	return datastore.SaveStruct(&entity, c)
 }
 
 // Key returns a datastore.Key for Notebook
@@ -89,27 +122,60 @@ func (notebook Notebook) Key(c appengine.Context) *datastore.Key {
 
 // Tags returns all tags used to sort this Notebook's notes
 func (notebook *Notebook) Tags(c appengine.Context) ([]Tag, error) {
+	tags, err := notebook.lazyTags(c)
+	if err != nil {
+		return tags, err
+	}
+	return tags, loadTagKeyShards(notebook.TagKeys, tags, c)
+}
+
+// lazyTags returns all Tags of this Notebook without reading the Note Keys of those that weren't needed yet, see
+// loadNoteKeys.
+func (notebook *Notebook) lazyTags(c appengine.Context) ([]Tag, error) {
 	if len(notebook.tags) == 0 && len(notebook.NoteKeys) > 0 {
-		notebook.tags = make([]Tag, len(notebook.TagKeys))
-		err := cachestore.GetMulti(c, notebook.TagKeys, notebook.tags)
+		err := notebook.loadTags(c)
 		if err != nil {
-			c.Errorf("getting notebook tags: %s", err)
 			return notebook.tags, err
 		}
 	}
 	return notebook.tags, nil
 }
 
-// Note returns a note by its ID.
+// loadNoteKeys reads the Note Keys of this Notebook's Tags with indexes (into its Tags) if they weren't read yet.
+func (notebook *Notebook) loadNoteKeys(c appengine.Context, indexes ...int) error {
+	keys, tags := make([]*datastore.Key, len(indexes)), make([]Tag, len(indexes))
+	for j, i := range indexes {
+		keys[j], tags[j] = notebook.TagKeys[i], notebook.tags[i]
+	}
+	err := loadTagKeyShards(keys, tags, c)
+	if err != nil {
+		return err
+	}
+	for j, i := range indexes {
+		notebook.tags[i] = tags[j]
+	}
+	return nil
+}
+
+// loadTags reads this Notebook's Tags into its cache.
+func (notebook *Notebook) loadTags(c appengine.Context) error {
+	notebook.tags = make([]Tag, len(notebook.TagKeys))
+	err := cachestore.GetMulti(c, notebook.TagKeys, notebook.tags)
+	if err != nil {
+		c.Errorf("getting notebook tags: %s", err)
+		return err
+	}
+	notebook.sortTags()
+	return nil
+}
+
+// Note returns a note by its ID. Returns ErrMissingNote if this Notebook doesn't have it.
 func (notebook *Notebook) Note(id string, c appengine.Context) (note Note, err error) {
 	key, err := datastore.DecodeKey(id)
-	if err == nil {
-		if containsKey(notebook.NoteKeys, key) {
-			err = cachestore.Get(c, key, &note)
-		} else {
-			err = errors.New("notebook does not contain note with ID: " + id)
-		}
+	if err != nil || !notebook.hasNote(key) {
+		return note, ErrMissingNote
 	}
+	err = cachestore.Get(c, key, &note)
 	return note, err
 }
 
@@ -147,14 +213,14 @@ func (notebook *Notebook) UntaggedNotes(c appengine.Context) ([]Note, error) {
 	return notebook.untaggedNotes, nil
 }
 
-// TagsFrom returns tags in this Notebook by name. Returns an error if a tag is missing  
+// TagsFrom returns tags in this Notebook by name. Returns an error if a tag is missing
 func (notebook *Notebook) TagsFrom(names []string, c appengine.Context) (tags []Tag, err error) {
 	allTags, err := notebook.Tags(c)
 	if err != nil {
 		return tags, err
 	}
 	for _, name := range names {
-		i := indexOfTag(allTags, name)
+		i := indexOfTag(allTags, notebook.resolveAlias(name))
 		if i >= 0 {
 			tags = append(tags, allTags[i])
 		} else {
@@ -167,45 +233,74 @@ func (notebook *Notebook) TagsFrom(names []string, c appengine.Context) (tags []
 	return tags, nil
 }
 
+// TagsMatching returns the Tags in this Notebook matched by each selector (see Selector). Returns an error if a
+// selector doesn't match any Tag.
+func (notebook *Notebook) TagsMatching(selectors []string, c appengine.Context) (groups [][]Tag, err error) {
+	allTags, err := notebook.Tags(c)
+	if err != nil {
+		return groups, err
+	}
+	for _, s := range selectors {
+		selector := ParseSelector(s)
+		if selector.Exact() {
+			selector.Key, selector.Value = hashtag.SplitValue(notebook.resolveAlias(selector.Name()))
+		} else {
+			selector.Key = notebook.resolveAlias(selector.Key)
+		}
+		var group []Tag
+		for _, tag := range allTags {
+			if selector.Matches(tag) {
+				group = append(group, tag)
+			}
+		}
+		if len(group) == 0 {
+			if Debug {
+				c.Debugf("user missing tag: %s", s)
+			}
+			return groups, errors.New("tessernote: missing tag (" + s + ")")
+		}
+		groups = append(groups, group)
+	}
+	return groups, nil
+}
+
 // TagsOf returns the Tags of a Note in this Notebook
 func (notebook *Notebook) TagsOf(note Note, c appengine.Context) (tags []Tag, err error) {
-	allTags, err := notebook.Tags(c)
+	allTags, err := notebook.lazyTags(c)
 	if err != nil {
 		return tags, err
 	}
-	for _, key := range note.TagKeys {
-		i := indexOfKey(notebook.TagKeys, key)
-		if i >= 0 {
-			tags = append(tags, allTags[i])
-		} else {
+	indexes := make([]int, len(note.TagKeys))
+	for j, key := range note.TagKeys {
+		indexes[j] = notebook.indexOfTagKey(key)
+		if indexes[j] < 0 {
 			c.Errorf("notebook missing tag: %s", key)
 			return tags, errors.New("notebook missing tag: " + key.Encode())
 		}
 	}
+	err = notebook.loadNoteKeys(c, indexes...)
+	if err != nil {
+		return tags, err
+	}
+	for _, i := range indexes {
+		tags = append(tags, allTags[i])
+	}
 	return tags, nil
 }
 
 // RelatedTags returns all Tags in this Notebook that refer to the Notes referred to by a subset of Tags.
-// 
+//
 // For example: if Tags A and B refer to Note C and only Tag A is given as input, the output will be A and B.
 func (notebook *Notebook) RelatedTags(tags []Tag, c appengine.Context) ([]Tag, error) {
-	relatedNoteKeys := make(map[string]datastore.Key)
-	for _, tag := range tags {
-		for _, key := range tag.NoteKeys {
-			relatedNoteKeys[key.Encode()] = *key
-		}
-	}
+	relatedNoteKeys := groupNoteKeys(tags)
 	tags = *new([]Tag)
 	allTags, err := notebook.Tags(c)
 	if err != nil {
 		return tags, err
 	}
-	for _, tag := range allTags {
-		for _, key := range tag.NoteKeys {
-			if _, contained := relatedNoteKeys[key.Encode()]; contained {
-				tags = append(tags, tag)
-				break
-			}
+	for i := range allTags {
+		if allTags[i].noteKeySet().Intersects(relatedNoteKeys) {
+			tags = append(tags, allTags[i])
 		}
 	}
 	return tags, nil
@@ -213,64 +308,85 @@ func (notebook *Notebook) RelatedTags(tags []Tag, c appengine.Context) ([]Tag, e
 
 // Put updates a Note or creates it if it doesn't already exist and sorts out all Tag relationships.
 func (notebook *Notebook) Put(note Note, c appengine.Context) (Note, error) {
-	if note.ID != "" && containsKey(notebook.NoteKeys, note.Key(c)) {
+	if note.ID != "" && notebook.hasNote(note.Key(c)) {
 		return notebook.updateNote(note, c)
 	}
 	return notebook.addNote(note, c)
 }
 
+// PutIfUnmodified updates a Note unless it changed since it was last modified at lastModified. The check is made in
+// the transaction that replaces it, so no other write can come in between. Returns ErrNoteChanged if the Note
+// changed or was deleted. A zero lastModified updates it regardless.
+func (notebook *Notebook) PutIfUnmodified(note Note, lastModified time.Time, c appengine.Context) (Note, error) {
+	if note.ID == "" || !notebook.hasNote(note.Key(c)) {
+		return note, ErrNoteChanged
+	}
+	note.lastModified = lastModified
+	return notebook.updateNote(note, c)
+}
+
 // addNote adds a Note to this Notebook, updating existing Tags to point to it if they're mentioned
 // and adding any new Tags
 func (notebook *Notebook) addNote(note Note, c appengine.Context) (Note, error) {
-	err := datastore.RunInTransaction(c, func(tc appengine.Context) error {
-		// add note (without tags) TODO add existing tags
-		key, err := notebook.addNoteWithoutTags(&note, tc)
+	err := notebook.runInTransaction(c, func(tc appengine.Context) error {
+		err := notebook.insertNote(&note, false, tc)
 		if err != nil {
 			return err
 		}
+		return notebook.save(tc)
+	})
+	return note, err
+}
 
-		// add/update tags
-		err = notebook.updateTags(key, new(Note), &note, tc)
-		if err != nil {
-			return err
-		}
+// insertNote adds a Note and its Tags to the datastore. If keepTimes is true the Note's Created and LastModified
+// times are kept (unless they're missing), otherwise they're set to the current time. It doesn't save this
+// Notebook so several Notes can be added in one transaction.
+func (notebook *Notebook) insertNote(note *Note, keepTimes bool, c appengine.Context) error {
+	// add note (without tags) TODO add existing tags
+	key, err := notebook.addNoteWithoutTags(note, keepTimes, c)
+	if err != nil {
+		return err
+	}
 
-		// update note (with tags) TODO skip if no new tags
-		if Debug {
-			tc.Debugf("updating note (with tags): %#v", note)
-		}
-		key, err = cachestore.Put(tc, key, &note)
-		if err != nil {
-			tc.Errorf("updating note (with tags): %s", err)
-			return err
-		}
+	// add/update tags
+	err = notebook.updateTags(key, new(Note), note, c)
+	if err != nil {
+		return err
+	}
 
-		// update notebook
-		notebook.NoteKeys = append(notebook.NoteKeys, key)
-		if len(note.TagKeys) > 0 {
-			notebook.addTagKeys(note.TagKeys)
-		} else {
-			notebook.UntaggedNoteKeys = append(notebook.UntaggedNoteKeys, key)
-		}
-		return notebook.save(tc)
-	}, &datastore.TransactionOptions{XG: true})
+	// update note (with tags) TODO skip if no new tags
+	if Debug {
+		c.Debugf("updating note (with tags): %#v", note)
+	}
+	key, err = putEntity(c, key, note)
 	if err != nil {
-		memcache.Flush(c)
+		c.Errorf("updating note (with tags): %s", err)
+		return err
 	}
-	return note, err
+
+	// update notebook
+	notebook.addNoteKey(key)
+	if len(note.TagKeys) == 0 {
+		notebook.addUntaggedNoteKey(key)
+	}
+	return nil
 }
 
 // addNoteWithoutTags adds a Note to the datastore so that a unique Key is created for it. That Key is
 // then used for adding/updating Tags.
-func (notebook Notebook) addNoteWithoutTags(note *Note, c appengine.Context) (*datastore.Key, error) {
-	note.Created = time.Now()
-	note.LastModified = note.Created
+func (notebook Notebook) addNoteWithoutTags(note *Note, keepTimes bool, c appengine.Context) (*datastore.Key, error) {
+	if !keepTimes || note.Created.IsZero() {
+		note.Created = time.Now()
+	}
+	if !keepTimes || note.LastModified.Before(note.Created) {
+		note.LastModified = note.Created
+	}
 	note.NotebookKeys = []*datastore.Key{notebook.Key(c)}
 	key := notebook.newNoteKey(note, c)
 	if Debug {
 		c.Debugf("adding note (without tags): %#v", note)
 	}
-	key, err := cachestore.Put(c, key, note)
+	key, err := putEntity(c, key, note)
 	if err != nil {
 		c.Errorf("adding note (without tags): %s", err)
 		return nil, err
@@ -279,13 +395,13 @@ func (notebook Notebook) addNoteWithoutTags(note *Note, c appengine.Context) (*d
 	return key, nil
 }
 
-// newNoteKey returns a unique Key for a new Note. New Notes can be created with Keys generated outside of 
-// Tessernote. However if this Key is not unique (e.g. if it refers to a Note in another Notebook) then a new
-// Key is generated.
+// newNoteKey returns a unique Key for a new Note. New Notes can be created with Keys generated outside of
+// Tessernote, e.g. when a backup is restored. However if this Key is not unique (e.g. if it refers to a Note in
+// another Notebook) or it doesn't belong to this Notebook then a new Key is generated.
 func (notebook Notebook) newNoteKey(note *Note, c appengine.Context) *datastore.Key {
 	if note.ID != "" {
 		key, err := datastore.DecodeKey(note.ID)
-		if err == nil {
+		if err == nil && key.Kind() == "Note" && key.Parent().Equal(notebook.Key(c)) {
 			err = cachestore.Get(c, key, new(interface{}))
 			if err == datastore.ErrNoSuchEntity {
 				return key
@@ -308,46 +424,49 @@ func (notebook *Notebook) updateTags(key *datastore.Key, oldNote, note *Note, c
 		if Debug {
 			c.Debugf("adding/updating tags: %#v", tags)
 		}
-		tagKeys, err := cachestore.PutMulti(c, tagKeys, tags)
+		tagKeys, err := putTags(c, tagKeys, tags)
 		if err != nil {
 			c.Errorf("adding/updating tags: %s", err)
 			return err
 		}
 		// update note tags
 		note.TagKeys = tagKeys[:count]
+		err = notebook.cacheTags(tagKeys, tags, c)
+		if err != nil {
+			return err
+		}
 	}
 	if len(deleted) > 0 {
 		if Debug {
 			c.Debugf("deleting empty tags: %#v", deleted)
 		}
-		err = cachestore.DeleteMulti(c, deleted)
+		err = deleteTags(c, deleted)
 		if err != nil {
 			c.Errorf("deleting empty tags: %s", err)
 		}
+		notebook.uncacheTags(deleted)
 	}
-	// update notebook tags
-	if len(oldNote.TagKeys) > 0 && len(note.TagKeys) > 0 {
-		notebook.removeTagKeys(deleted)
-		notebook.addTagKeys(note.TagKeys)
-	} else if len(oldNote.TagKeys) == 0 && len(note.TagKeys) > 0 {
-		notebook.UntaggedNoteKeys = removeKey(notebook.UntaggedNoteKeys, key)
-		notebook.addTagKeys(note.TagKeys)
+	// update notebook untagged notes
+	if len(oldNote.TagKeys) == 0 && len(note.TagKeys) > 0 {
+		notebook.removeUntaggedNoteKey(key)
 	} else if len(oldNote.TagKeys) > 0 && len(note.TagKeys) == 0 {
-		notebook.removeTagKeys(deleted)
 		if note.ID != "" {
-			notebook.UntaggedNoteKeys = append(notebook.UntaggedNoteKeys, key)
+			notebook.addUntaggedNoteKey(key)
 		}
-	} else if note.ID == "" {
-		notebook.UntaggedNoteKeys = removeKey(notebook.UntaggedNoteKeys, key)
+	} else if len(oldNote.TagKeys) == 0 && note.ID == "" {
+		notebook.removeUntaggedNoteKey(key)
 	}
-	return err
+	if err != nil {
+		return err
+	}
+	return notebook.noteChanged(key, oldNote, note, c)
 }
 
 // updateTagKeys updates tags in memory to reflect the changes of turning oldNote into note and returns
 // the objects to commit to the datastore to make these changes permanent.
 func (notebook *Notebook) updateTagKeys(oldNote, note *Note, c appengine.Context) (keys []*datastore.Key, tags []Tag, count int, deleted []*datastore.Key, err error) {
 	// get note tags
-	keys, tags, names, err := notebook.parseTagsOf(*note, c)
+	keys, tags, names, err := notebook.parseTagsOf(*oldNote, *note, c)
 	count = len(keys)
 
 	// get remove tags
@@ -358,18 +477,36 @@ func (notebook *Notebook) updateTagKeys(oldNote, note *Note, c appengine.Context
 }
 
 // parseTagsOf parses the hashtags of note.Body, and returns the associated Tags. Missing Tags are also created with
-// incomplete Keys.
-func (notebook *Notebook) parseTagsOf(note Note, c appengine.Context) (keys []*datastore.Key, tags []Tag, names []string, err error) {
-	names = ParseTagNames(note.Body)
-	allTags, err := notebook.Tags(c)
+// incomplete Keys. Spellings that differ from the ones in oldNote are counted towards the Tags' display names.
+// Spellings are compared before resolving aliases, so Notes that start referring to a Tag through an alias move
+// their count to it.
+func (notebook *Notebook) parseTagsOf(oldNote, note Note, c appengine.Context) (keys []*datastore.Key, tags []Tag, names []string, err error) {
+	names = notebook.parseTagNames(note.Body)
+	spellings := ParseTagNames(note.Body)
+	oldSpellings := ParseTagNames(oldNote.Body)
+	allTags, err := notebook.lazyTags(c)
 	if err != nil {
 		return keys, tags, names, err
 	}
 	notebookKey := notebook.Key(c)
-	for _, name := range names {
+	for j, name := range names {
+		if containsTagName(names[:j], name) {
+			continue // hashtag used more than once
+		}
 		i := indexOfTag(allTags, name)
 		if i >= 0 {
-			allTags[i].NoteKeys = addKey(allTags[i].NoteKeys, note.Key(c))
+			err = notebook.loadNoteKeys(c, i)
+			if err != nil {
+				return keys, tags, names, err
+			}
+			allTags[i].addNoteKey(note.Key(c))
+			spelling := spellingOf(spellings, allTags[i])
+			if !containsKey(oldNote.TagKeys, notebook.TagKeys[i]) {
+				allTags[i].addSpelling(spelling)
+			} else if old := spellingOf(oldSpellings, allTags[i]); old != spelling {
+				allTags[i].removeSpelling(old)
+				allTags[i].addSpelling(spelling)
+			}
 			keys = append(keys, notebook.TagKeys[i])
 			tags = append(tags, allTags[i])
 		} else {
@@ -388,12 +525,14 @@ func (notebook *Notebook) removedTags(oldNote *Note, names []string, c appengine
 		return removedFromKeys, removedFromTags, deleteKeys, err
 	}
 	// remove from old tags
+	oldSpellings := ParseTagNames(oldNote.Body)
 	for i := range oldTags {
-		if !containsString(names, oldTags[i].Name) {
+		if !containsTagName(names, oldTags[i].Name) {
 			if len(oldTags[i].NoteKeys) == 1 {
 				deleteKeys = append(deleteKeys, oldNote.TagKeys[i])
 			} else {
-				oldTags[i].NoteKeys = removeKey(oldTags[i].NoteKeys, oldNote.Key(c))
+				oldTags[i].removeNoteKey(oldNote.Key(c))
+				oldTags[i].removeSpelling(spellingOf(oldSpellings, oldTags[i]))
 				removedFromKeys = append(removedFromKeys, oldNote.TagKeys[i])
 				removedFromTags = append(removedFromTags, oldTags[i])
 			}
@@ -402,20 +541,108 @@ func (notebook *Notebook) removedTags(oldNote *Note, names []string, c appengine
 	return removedFromKeys, removedFromTags, deleteKeys, nil
 }
 
-// removeTagKeys removes tag Keys from this Notebook. Tags not in this Notebook are ignored.
-func (notebook *Notebook) removeTagKeys(tagKeys []*datastore.Key) {
-	notebook.tags = *new([]Tag)
+// uncacheTags removes tag Keys from this Notebook and their Tags from its cache. Tags not in this Notebook
+// are ignored.
+func (notebook *Notebook) uncacheTags(tagKeys []*datastore.Key) {
+	cached := notebook.tagsCached()
 	for _, key := range tagKeys {
-		notebook.TagKeys = removeKey(notebook.TagKeys, key)
+		set := keySetOf(&notebook.tagSet, notebook.TagKeys)
+		i := set.IndexOf(key)
+		if i < 0 {
+			continue
+		}
+		set.Remove(key)
+		notebook.TagKeys = set.Keys()
+		if cached {
+			notebook.tags = append(notebook.tags[:i], notebook.tags[i+1:]...)
+		}
 	}
 }
 
-// addTagKeys adds missing tag Keys to this Notebook. Keys of tags that already exist are ignored.
-func (notebook *Notebook) addTagKeys(tagKeys []*datastore.Key) {
-	notebook.tags = *new([]Tag)
-	for _, key := range tagKeys {
-		notebook.TagKeys = addKey(notebook.TagKeys, key)
+// cacheTags adds missing tag Keys to this Notebook and updates its cache with the latest version of tags. Keeping
+// the cache up to date means Tags don't have to be read again (without the transaction's changes) when a
+// transaction touches several Notes. TagKeys are sorted by the Tags' names, so the cache is loaded first if new
+// Keys are added without it.
+func (notebook *Notebook) cacheTags(tagKeys []*datastore.Key, tags []Tag, c appengine.Context) error {
+	if !notebook.tagsCached() {
+		set := keySetOf(&notebook.tagSet, notebook.TagKeys)
+		for _, key := range tagKeys {
+			if !set.Contains(key) {
+				err := notebook.loadTags(c)
+				if err != nil {
+					return err
+				}
+				break
+			}
+		}
+	}
+	cached := notebook.tagsCached()
+	for j, key := range tagKeys {
+		set := keySetOf(&notebook.tagSet, notebook.TagKeys)
+		i := set.IndexOf(key)
+		if i < 0 {
+			set.Add(key)
+			notebook.TagKeys = set.Keys()
+			notebook.tags = append(notebook.tags, tags[j])
+		} else if cached {
+			notebook.tags[i] = tags[j]
+		}
 	}
+	if cached {
+		notebook.sortTags()
+	} else {
+		notebook.tags = *new([]Tag)
+	}
+	return nil
+}
+
+// sortTags sorts this Notebook's cached Tags and their Keys by folded name.
+func (notebook *Notebook) sortTags() {
+	sort.Sort(tagsByName{notebook.TagKeys, notebook.tags})
+	notebook.tagSet = nil // sorted in place
+}
+
+// hasNote returns true if this Notebook contains the Note with key.
+func (notebook *Notebook) hasNote(key *datastore.Key) bool {
+	return keySetOf(&notebook.noteSet, notebook.NoteKeys).Contains(key)
+}
+
+// indexOfTagKey returns the index of a Tag's key in TagKeys or -1 if it's not in this Notebook.
+func (notebook *Notebook) indexOfTagKey(key *datastore.Key) int {
+	return keySetOf(&notebook.tagSet, notebook.TagKeys).IndexOf(key)
+}
+
+// addNoteKey adds a Note's key to this Notebook.
+func (notebook *Notebook) addNoteKey(key *datastore.Key) {
+	set := keySetOf(&notebook.noteSet, notebook.NoteKeys)
+	set.Add(key)
+	notebook.NoteKeys = set.Keys()
+}
+
+// removeNoteKey removes a Note's key from this Notebook.
+func (notebook *Notebook) removeNoteKey(key *datastore.Key) {
+	set := keySetOf(&notebook.noteSet, notebook.NoteKeys)
+	set.Remove(key)
+	notebook.NoteKeys = set.Keys()
+}
+
+// addUntaggedNoteKey adds a Note's key to this Notebook's untagged Notes.
+func (notebook *Notebook) addUntaggedNoteKey(key *datastore.Key) {
+	set := keySetOf(&notebook.untaggedSet, notebook.UntaggedNoteKeys)
+	set.Add(key)
+	notebook.UntaggedNoteKeys = set.Keys()
+}
+
+// removeUntaggedNoteKey removes a Note's key from this Notebook's untagged Notes.
+func (notebook *Notebook) removeUntaggedNoteKey(key *datastore.Key) {
+	set := keySetOf(&notebook.untaggedSet, notebook.UntaggedNoteKeys)
+	set.Remove(key)
+	notebook.UntaggedNoteKeys = set.Keys()
+}
+
+// tagsCached returns true if this Notebook's cache holds a Tag for every tag Key.
+func (notebook *Notebook) tagsCached() bool {
+	return len(notebook.tags) == len(notebook.TagKeys)
 }
 
 // PutAll adds or updates notes and sorts out all Tag relationships.
@@ -429,54 +656,82 @@ func (notebook *Notebook) PutAll(notes []Note, c appengine.Context) ([]Note, err
 
 // save updates this Notebook in the datastore
 func (notebook *Notebook) save(c appengine.Context) error {
+	batch := notebook.syncKeyShards(c)
+	entity := *notebook
+	entity.Version = notebook.Version + 1 // only committed in memory by runInTransaction
+	notebook.savedVersion = entity.Version
 	if Debug {
-		c.Debugf("updating notebook: %#v", *notebook)
+		c.Debugf("updating notebook: %#v", entity)
 	}
-	_, err := cachestore.Put(c, notebook.Key(c), notebook)
+	_, err := putEntity(c, notebook.Key(c), &entity)
 	if err != nil {
 		c.Errorf("updating notebook: %s", err)
+		return err
 	}
-	return err
+	err = batch.commit(c)
+	if err != nil {
+		return err
+	}
+	return notebook.saveModels(c)
 }
 
 // updateNote updates a Note in this Notebook, updating existing Tags to either start or stop pointing to it,
 // cleaning up Tags that no longer point to any Note, and adding any new Tags.
 func (notebook *Notebook) updateNote(note Note, c appengine.Context) (Note, error) {
-	err := datastore.RunInTransaction(c, func(tc appengine.Context) error {
-		// get old note
-		var oldNote Note
-		key := note.Key(tc)
-		err := cachestore.Get(tc, key, &oldNote)
-		if err != nil {
-			tc.Errorf("getting old note: %s", err)
-			return err
-		}
-		oldNote.ID = note.ID
-
-		// add/update/delete tags
-		err = notebook.updateTags(key, &oldNote, &note, tc)
-		if err != nil {
-			return err
-		}
-
-		// update note
-		note.Created = oldNote.Created
-		note.LastModified = time.Now()
-		note.NotebookKeys = oldNote.NotebookKeys
-		if Debug {
-			tc.Debugf("updating note: %#v", note)
-		}
-		key, err = cachestore.Put(tc, key, &note)
+	err := notebook.runInTransaction(c, func(tc appengine.Context) error {
+		var err error
+		note, err = notebook.replaceNote(note, tc)
 		if err != nil {
-			tc.Errorf("updating note: %s", err)
 			return err
 		}
 
 		// update notebook
 		return notebook.save(tc)
-	}, &datastore.TransactionOptions{XG: true})
+	})
+	return note, err
+}
+
+// replaceNote replaces the stored version of note with note and adds/updates/deletes Tags to match its new
+// hashtags. It doesn't save this Notebook so several Notes can be replaced in one transaction.
+func (notebook *Notebook) replaceNote(note Note, c appengine.Context) (Note, error) {
+	// get old note
+	var oldNote Note
+	key := note.Key(c)
+	err := cachestore.Get(c, key, &oldNote)
+	if err == datastore.ErrNoSuchEntity && !note.lastModified.IsZero() {
+		return note, ErrNoteChanged
+	} else if err != nil {
+		c.Errorf("getting old note: %s", err)
+		return note, err
+	}
+	if !note.lastModified.IsZero() && !sameTime(oldNote.LastModified, note.lastModified) {
+		return note, ErrNoteChanged
+	}
+	oldNote.ID = note.ID
+
+	// add/update/delete tags
+	note.TagKeys = nil // recalculated from note.Body
+	err = notebook.updateTags(key, &oldNote, &note, c)
 	if err != nil {
-		memcache.Flush(c)
+		return note, err
+	}
+
+	// update note
+	note.Created = oldNote.Created
+	note.LastModified = time.Now()
+	note.NotebookKeys = oldNote.NotebookKeys
+	if note.keepPinned {
+		note.Pinned = oldNote.Pinned
+	}
+	if note.keepArchived {
+		note.Archived = oldNote.Archived
+	}
+	if Debug {
+		c.Debugf("updating note: %#v", note)
+	}
+	_, err = putEntity(c, key, &note)
+	if err != nil {
+		c.Errorf("updating note: %s", err)
 	}
 	return note, err
 }
@@ -484,20 +739,31 @@ func (notebook *Notebook) updateNote(note Note, c appengine.Context) (Note, erro
 // Delete deletes a Note from this Notebook, removes it from any Tags that refer to it and deletes any Tags
 // that no longer refer to any Notes
 func (notebook *Notebook) Delete(id string, c appengine.Context) (bool, error) {
-	err := datastore.RunInTransaction(c, func(tc appengine.Context) error {
+	return notebook.DeleteIfUnmodified(id, time.Time{}, c)
+}
+
+// DeleteIfUnmodified is Delete for a Note that must not have changed since it was last modified at lastModified,
+// like PutIfUnmodified. A zero lastModified deletes it regardless.
+func (notebook *Notebook) DeleteIfUnmodified(id string, lastModified time.Time, c appengine.Context) (bool, error) {
+	err := notebook.runInTransaction(c, func(tc appengine.Context) error {
 		note := Note{ID: id}
 		noteKey := note.Key(c)
 		err := cachestore.Get(tc, noteKey, &note)
-		if err != nil {
+		if err == datastore.ErrNoSuchEntity && !lastModified.IsZero() {
+			return ErrNoteChanged
+		} else if err != nil {
 			c.Errorf("getting note: %s", err)
 			return err
 		}
+		if !lastModified.IsZero() && !sameTime(note.LastModified, lastModified) {
+			return ErrNoteChanged
+		}
 
 		// remove note
 		if Debug {
 			tc.Debugf("deleting note: %#v", note)
 		}
-		err = cachestore.Delete(tc, noteKey)
+		err = deleteEntity(tc, noteKey)
 		if err != nil {
 			c.Errorf("deleting note: %s", err)
 			return err
@@ -510,26 +776,193 @@ func (notebook *Notebook) Delete(id string, c appengine.Context) (bool, error) {
 		}
 
 		// remove note from notebook
-		notebook.NoteKeys = removeKey(notebook.NoteKeys, noteKey)
+		notebook.removeNoteKey(noteKey)
 		return notebook.save(tc)
-	}, &datastore.TransactionOptions{XG: true})
-	if err != nil {
-		memcache.Flush(c)
-	}
+	})
 	return err == nil, err
 }
 
 // DeleteAll deletes all Notes and Tags from this Notebook.
 func (notebook *Notebook) DeleteAll(c appengine.Context) error {
-	err := datastore.RunInTransaction(c, func(tc appengine.Context) error {
-		err := cachestore.DeleteMulti(tc, notebook.NoteKeys)
+	return notebook.runInTransaction(c, func(tc appengine.Context) error {
+		err := deleteEntities(tc, notebook.NoteKeys)
+		if err != nil {
+			return err
+		}
+		err = deleteEntities(tc, notebook.TagKeys)
+		if err != nil {
+			return err
+		}
+		err = deleteKeyShards(tagShardKind, notebook.Key(tc), tc)
+		if err != nil {
+			return err
+		}
+		err = deleteKeyShards(notebookShardKind, notebook.Key(tc), tc)
+		if err != nil {
+			return err
+		}
+		err = notebook.deleteModels(tc)
+		if err != nil {
+			return err
+		}
+		notebook.NoteKeys, notebook.TagKeys, notebook.UntaggedNoteKeys = nil, nil, nil
+		notebook.noteSet, notebook.tagSet, notebook.untaggedSet = nil, nil, nil
+		notebook.noteShards, notebook.tagShards, notebook.untaggedShards = newKeyShards(), newKeyShards(), newKeyShards()
+		notebook.notes, notebook.tags, notebook.untaggedNotes = nil, nil, nil
+		return notebook.save(tc)
+	})
+}
+
+// RenameTag renames a Tag and rewrites its hashtag in every Note that refers to it. Renaming a Tag to the name of
+// another Tag fails with ErrTagExists, use MergeTags instead.
+func (notebook *Notebook) RenameTag(name, newName string, c appengine.Context) (tag Tag, err error) {
+	if !IsTagName(newName) {
+		return tag, ErrInvalidTag
+	}
+	err = notebook.runInTransaction(c, func(tc appengine.Context) error {
+		allTags, err := notebook.Tags(tc)
+		if err != nil {
+			return err
+		}
+		name = notebook.resolveAlias(name)
+		i := indexOfTag(allTags, name)
+		if i < 0 {
+			return ErrMissingTag
+		}
+		if j := indexOfTag(allTags, newName); j >= 0 && j != i {
+			return ErrTagExists
+		}
+		if resolved := notebook.resolveAlias(newName); resolved != newName {
+			if indexOfTag(allTags, resolved) != i {
+				return ErrTagExists // alias of another tag
+			}
+			notebook.removeAlias(newName)
+		}
+		notebook.Order.Cleanup(allTags[i : i+1])
+		notebook.renameAliases(allTags[i].Name, newName)
+
+		// rename tag, every note will use the new spelling
+		tag = allTags[i]
+		tag.Name = newName
+		tag.FoldedName = hashtag.Fold(newName)
+		tag.Spellings = []string{newName}
+		tag.SpellingCounts = []int{len(tag.NoteKeys)}
+		if Debug {
+			tc.Debugf("renaming tag: %#v", tag)
+		}
+		_, err = putTags(tc, notebook.TagKeys[i:i+1], []Tag{tag})
+		if err != nil {
+			tc.Errorf("renaming tag: %s", err)
+			return err
+		}
+		err = notebook.cacheTags(notebook.TagKeys[i:i+1], []Tag{tag}, tc)
+		if err != nil {
+			return err
+		}
+
+		// rewrite notes, their tags stay the same
+		notes, err := tag.Notes(tc)
+		if err != nil {
+			return err
+		}
+		for _, note := range notes {
+			note.Body = hashtag.Rename(note.Body, name, newName)
+			note.LastModified = time.Now()
+			if Debug {
+				tc.Debugf("updating note: %#v", note)
+			}
+			_, err = putEntity(tc, note.Key(tc), &note)
+			if err != nil {
+				tc.Errorf("updating note: %s", err)
+				return err
+			}
+		}
+		return notebook.save(tc)
+	})
+	return tag, err
+}
+
+// MergeTags moves every Note of the Tag called name into the Tag called into by rewriting their hashtags. The
+// emptied Tag is deleted.
+func (notebook *Notebook) MergeTags(name, into string, c appengine.Context) (tag Tag, err error) {
+	err = notebook.runInTransaction(c, func(tc appengine.Context) error {
+		allTags, err := notebook.Tags(tc)
+		if err != nil {
+			return err
+		}
+		name = notebook.resolveAlias(name)
+		i, j := indexOfTag(allTags, name), indexOfTag(allTags, notebook.resolveAlias(into))
+		if i < 0 || j < 0 {
+			return ErrMissingTag
+		}
+		if i == j {
+			return ErrInvalidTag
+		}
+		into = allTags[j].Name // keep the display spelling of the Tag merged into
+		notebook.Order.Cleanup(allTags[i : i+1])
+		notebook.renameAliases(allTags[i].Name, into)
+		err = notebook.rewriteNotesOf(allTags[i], func(body string) string {
+			return hashtag.Rename(body, name, into)
+		}, tc)
+		if err != nil {
+			return err
+		}
+		allTags, err = notebook.Tags(tc)
+		if err != nil {
+			return err
+		}
+		tag = allTags[indexOfTag(allTags, into)]
+		return notebook.save(tc)
+	})
+	return tag, err
+}
+
+// DeleteTag removes the hash mark of a Tag's hashtags (and its aliases) from every Note that refers to it (leaving
+// the word) and deletes the Tag and its aliases.
+func (notebook *Notebook) DeleteTag(name string, c appengine.Context) error {
+	err := notebook.runInTransaction(c, func(tc appengine.Context) error {
+		allTags, err := notebook.Tags(tc)
+		if err != nil {
+			return err
+		}
+		name = notebook.resolveAlias(name)
+		i := indexOfTag(allTags, name)
+		if i < 0 {
+			return ErrMissingTag
+		}
+		notebook.Order.Cleanup(allTags[i : i+1])
+		aliases := notebook.aliasesOf(allTags[i].Name)
+		err = notebook.rewriteNotesOf(allTags[i], func(body string) string {
+			body = hashtag.Strip(body, name)
+			for _, alias := range aliases {
+				body = hashtag.Strip(body, alias)
+			}
+			return body
+		}, tc)
 		if err != nil {
 			return err
 		}
-		return cachestore.DeleteMulti(tc, notebook.TagKeys)
-	}, &datastore.TransactionOptions{XG: true})
+		for _, alias := range aliases {
+			notebook.removeAlias(alias)
+		}
+		return notebook.save(tc)
+	})
+	return err
+}
+
+// rewriteNotesOf rewrites the body of every Note that refers to tag and updates their Tags. It doesn't
+// save this Notebook.
+func (notebook *Notebook) rewriteNotesOf(tag Tag, rewrite func(body string) string, c appengine.Context) error {
+	notes, err := tag.Notes(c)
 	if err != nil {
-		memcache.Flush(c)
+		return err
+	}
+	for _, note := range notes {
+		note.Body = rewrite(note.Body)
+		_, err = notebook.replaceNote(note, c)
+		if err != nil {
+			return err
+		}
 	}
 	return nil
 }
@@ -554,7 +987,33 @@ func CurrentNotebook(c appengine.Context) (*Notebook, error) {
 		}
 		notebook.Name = u.Email
 		notebook.Order = NewOrder()
-		key, err = cachestore.Put(c, key, notebook)
+		notebook.SchemaVersion = schemaVersion
+		key, err = putEntity(c, key, notebook)
+		return notebook, err
+	}
+	return notebook, notebook.load(c)
+}
+
+// NotebookOf returns the Notebook of the user with id, e.g. for admin tasks. Returns datastore.ErrNoSuchEntity if
+// the user doesn't have one.
+func NotebookOf(id string, c appengine.Context) (*Notebook, error) {
+	notebook := &Notebook{ID: id}
+	err := cachestore.Get(c, notebook.Key(c), notebook)
+	if err != nil {
+		return notebook, err
+	}
+	return notebook, notebook.load(c)
+}
+
+// load finishes reading this Notebook after its entity was read.
+func (notebook *Notebook) load(c appengine.Context) error {
+	err := notebook.checkVersion(c)
+	if err != nil {
+		return err
+	}
+	err = notebook.loadKeyShards(c)
+	if err != nil {
+		return err
 	}
-	return notebook, err
+	return notebook.migrate(c)
 }
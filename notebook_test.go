@@ -0,0 +1,167 @@
+/*
+This file is part of Tessernote.
+
+Tessernote is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+Tessernote is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with Tessernote.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package tessernote
+
+import (
+	"appengine"
+	"appengine/datastore"
+	"github.com/oschmid/appenginetesting"
+	"testing"
+)
+
+// newTestNotebook stores an empty Notebook with id and the Notes with bodies in it and returns the Notes' IDs.
+func newTestNotebook(t *testing.T, c appengine.Context, id string, bodies ...string) (*Notebook, []string) {
+	notebook := &Notebook{ID: id}
+	_, err := datastore.Put(c, notebook.Key(c), notebook)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ids := make([]string, len(bodies))
+	for i, body := range bodies {
+		note, err := notebook.Put(Note{Body: body}, c)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ids[i] = note.ID
+	}
+	return notebook, ids
+}
+
+// checkBodies fails the test unless the Notes with ids have bodies.
+func checkBodies(t *testing.T, c appengine.Context, notebook *Notebook, ids []string, bodies ...string) {
+	for i, id := range ids {
+		note, err := notebook.Note(id, c)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if note.Body != bodies[i] {
+			t.Errorf("expected=%q actual=%q", bodies[i], note.Body)
+		}
+	}
+}
+
+// checkTagNotes fails the test unless the Tag called name has count Notes, or doesn't exist if count is 0.
+func checkTagNotes(t *testing.T, c appengine.Context, notebook *Notebook, name string, count int) {
+	tags, err := notebook.TagsFrom([]string{name}, c)
+	if count == 0 {
+		if err == nil {
+			t.Errorf("expected tag %s to be deleted, actual=%#v", name, tags[0])
+		}
+		return
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(tags[0].NoteKeys) != count {
+		t.Errorf("%s: expected=%d notes actual=%d", name, count, len(tags[0].NoteKeys))
+	}
+}
+
+func TestRenameTag(t *testing.T) {
+	c, err := appenginetesting.NewContext(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	notebook, ids := newTestNotebook(t, c, "rename", "one #go", "two #Go #web", "three #gopher")
+
+	tag, err := notebook.RenameTag("GO", "golang", c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tag.Name != "golang" || len(tag.NoteKeys) != 2 {
+		t.Fatalf("unexpected tag %#v", tag)
+	}
+	checkBodies(t, c, notebook, ids, "one #golang", "two #golang #web", "three #gopher")
+	checkTagNotes(t, c, notebook, "go", 0)
+	checkTagNotes(t, c, notebook, "golang", 2)
+	checkTagNotes(t, c, notebook, "gopher", 1)
+
+	for _, test := range []struct {
+		name, newName string
+		err           error
+	}{
+		{"golang", "web", ErrTagExists},
+		{"missing", "other", ErrMissingTag},
+		{"golang", "not a tag", ErrInvalidTag},
+	} {
+		_, err = notebook.RenameTag(test.name, test.newName, c)
+		if err != test.err {
+			t.Errorf("RenameTag(%s, %s): expected=%v actual=%v", test.name, test.newName, test.err, err)
+		}
+	}
+	checkBodies(t, c, notebook, ids, "one #golang", "two #golang #web", "three #gopher")
+}
+
+func TestMergeTags(t *testing.T) {
+	c, err := appenginetesting.NewContext(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	notebook, ids := newTestNotebook(t, c, "merge", "a #go", "b #golang", "c #go #golang", "d #web")
+
+	tag, err := notebook.MergeTags("go", "golang", c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tag.Name != "golang" || len(tag.NoteKeys) != 3 {
+		t.Fatalf("unexpected tag %#v", tag)
+	}
+	checkBodies(t, c, notebook, ids, "a #golang", "b #golang", "c #golang #golang", "d #web")
+	checkTagNotes(t, c, notebook, "go", 0)
+	checkTagNotes(t, c, notebook, "golang", 3)
+
+	_, err = notebook.MergeTags("golang", "missing", c)
+	if err != ErrMissingTag {
+		t.Errorf("expected=%v actual=%v", ErrMissingTag, err)
+	}
+	_, err = notebook.MergeTags("golang", "GOLANG", c)
+	if err != ErrInvalidTag {
+		t.Errorf("expected=%v actual=%v", ErrInvalidTag, err)
+	}
+}
+
+func TestDeleteTag(t *testing.T) {
+	c, err := appenginetesting.NewContext(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	notebook, ids := newTestNotebook(t, c, "delete", "a #go #web", "b #go", "c #web")
+
+	err = notebook.DeleteTag("go", c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkBodies(t, c, notebook, ids, "a go #web", "b go", "c #web")
+	checkTagNotes(t, c, notebook, "go", 0)
+	checkTagNotes(t, c, notebook, "web", 2)
+	key, err := datastore.DecodeKey(ids[1])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !containsKey(notebook.UntaggedNoteKeys, key) {
+		t.Errorf("expected %s to be untagged, actual=%v", ids[1], notebook.UntaggedNoteKeys)
+	}
+
+	err = notebook.DeleteTag("go", c)
+	if err != ErrMissingTag {
+		t.Errorf("expected=%v actual=%v", ErrMissingTag, err)
+	}
+}
@@ -18,14 +18,21 @@ along with Tessernote.  If not, see <http://www.gnu.org/licenses/>.
 package tessernote
 
 import (
+	"github.com/oschmid/tessernote/hashtag"
 	"html/template"
+	"sort"
+	"strconv"
+	"strings"
 )
 
 type Page struct {
 	Tags          []Tag
 	Notes         []Note
 	UntaggedNotes bool
+	Suggestions   map[string][]Suggestion // by Note.ID
+	Facets        map[string]Facet        // by Tag.Name
 	relatedTag    map[string]bool
+	tagStrength   map[string]float64 // of related tags
 	selectedTag   map[string]bool
 }
 
@@ -37,6 +44,12 @@ func (p *Page) SetRelatedTags(tags []Tag) {
 	}
 }
 
+// SetRelatedTagStrengths sets how strongly related tags are related to the selected tags, see
+// Notebook.RelatedTagStrengths.
+func (p *Page) SetRelatedTagStrengths(strengths map[string]float64) {
+	p.tagStrength = strengths
+}
+
 // SetSelectedTags sets the selected tags of this Page.
 func (p *Page) SetSelectedTags(tags []Tag) {
 	p.selectedTag = make(map[string]bool)
@@ -45,30 +58,124 @@ func (p *Page) SetSelectedTags(tags []Tag) {
 	}
 }
 
-// HtmlTags returns the Tags on this Page as HTML.
+// HtmlTags returns the Tags on this Page as HTML. Key-value Tags (e.g. status:done) are grouped under their key.
+// The Tags most strongly related to the selected ones come first, see SetRelatedTagStrengths.
 func (p Page) HtmlTags() template.HTML {
 	spacer := "\n    "
-	html := p.createTagDiv("All Notes")
+	html := p.createPageDiv("All Notes", "/")
+	var keys []string
+	values := make(map[string][]string)
 	for _, tag := range p.Tags {
-		html += spacer + p.createTagDiv(tag.Name)
+		key, value := hashtag.SplitValue(tag.Name)
+		if value == "" {
+			keys = append(keys, tag.Name)
+			continue
+		}
+		if _, ok := values[key]; !ok {
+			keys = append(keys, key+hashtag.ValueSeparator)
+		}
+		values[key] = append(values[key], value)
+	}
+	strength := func(key string) float64 {
+		if !strings.HasSuffix(key, hashtag.ValueSeparator) {
+			return p.relatedStrength(key)
+		}
+		max := 0.0
+		for _, value := range values[strings.TrimSuffix(key, hashtag.ValueSeparator)] {
+			if s := p.relatedStrength(key + value); s > max {
+				max = s
+			}
+		}
+		return max
+	}
+	sort.SliceStable(keys, func(i, j int) bool { return strength(keys[i]) > strength(keys[j]) })
+	for _, key := range keys {
+		if !strings.HasSuffix(key, hashtag.ValueSeparator) {
+			html += spacer + p.createTagDiv(key)
+			continue
+		}
+		key = strings.TrimSuffix(key, hashtag.ValueSeparator)
+		sort.SliceStable(values[key], func(i, j int) bool {
+			return p.relatedStrength(key+hashtag.ValueSeparator+values[key][i]) >
+				p.relatedStrength(key+hashtag.ValueSeparator+values[key][j])
+		})
+		html += spacer + p.createKeyDiv(key, values[key])
+		for _, value := range values[key] {
+			html += spacer + p.createValueDiv(key, value)
+		}
 	}
 	if p.UntaggedNotes {
-		html += spacer + p.createTagDiv("Untagged Notes")
+		html += spacer + p.createPageDiv("Untagged Notes", "/untagged/")
+		html += spacer + p.createPageDiv("Inbox", "/inbox/")
 	}
 	return template.HTML(html)
 }
 
 // createTagDiv returns a HTML div element for this named Tag.
 func (p Page) createTagDiv(name string) string {
-	div := "<div class='tag"
+	return "<div class='tag" + p.tagClasses(name) + "' tag='" + name + "'>" + name +
+		p.htmlFacet(name) + "</div>"
+}
+
+// createPageDiv returns a HTML div element that links to a page of Notes that isn't a Tag, e.g. the inbox. It's
+// marked by its path rather than its label so Tags with the same name still select themselves.
+func (p Page) createPageDiv(label, path string) string {
+	return "<div class='tag page' path='" + path + "'>" + label + "</div>"
+}
+
+// createKeyDiv returns a HTML div element that selects any value of a key.
+func (p Page) createKeyDiv(key string, values []string) string {
+	classes := ""
+	for _, value := range values {
+		if p.relatedTag[key+hashtag.ValueSeparator+value] {
+			classes = " related"
+			break
+		}
+	}
+	name := key + hashtag.ValueSeparator + AnyValue
+	return "<div class='tag key" + classes + "' tag='" + name + "'>" + key + hashtag.ValueSeparator +
+		p.htmlFacet(name) + "</div>"
+}
+
+// createValueDiv returns a HTML div element for a key-value Tag that only shows its value.
+func (p Page) createValueDiv(key, value string) string {
+	name := key + hashtag.ValueSeparator + value
+	return "<div class='tag value" + p.tagClasses(name) + "' tag='" + name + "'>" +
+		value + p.htmlFacet(name) + "</div>"
+}
+
+// htmlFacet returns the Note counts of a named Tag as HTML. If the Notes are refined by the selection both the
+// number of Notes that would remain and the total are shown.
+func (p Page) htmlFacet(name string) string {
+	facet, ok := p.Facets[name]
+	if !ok {
+		return ""
+	}
+	count := strconv.Itoa(facet.Count)
+	if facet.Refined != facet.Count {
+		count = strconv.Itoa(facet.Refined) + "/" + count
+	}
+	return " <span class='count'>" + count + "</span>"
+}
+
+// relatedStrength returns how strongly a named Tag is related to the selected Tags, or 0 if it's selected itself.
+func (p Page) relatedStrength(name string) float64 {
+	if p.selectedTag[name] {
+		return 0
+	}
+	return p.tagStrength[name]
+}
+
+// tagClasses returns the HTML classes of a named Tag that depend on the Notes displayed.
+func (p Page) tagClasses(name string) string {
+	classes := ""
 	if p.relatedTag[name] {
-		div += " related"
+		classes += " related"
 	}
 	if p.selectedTag[name] {
-		div += " selected"
+		classes += " selected"
 	}
-	div += "'>" + name + "</div>"
-	return div
+	return classes
 }
 
 // HtmlNotes returns the Notes on this Page as HTML.
@@ -80,7 +187,21 @@ func (p Page) HtmlNotes() template.HTML {
 			"        <div class='delete'>x</div>" +
 			"        <textarea noteid=\"" + note.ID + "\" class='resize'>" + note.Body + "</textarea>" +
 			"        <input type='button' class='save' value='Save'>" +
+			p.htmlSuggestions(note) +
 			"    </div>"
 	}
 	return template.HTML(html)
 }
+
+// htmlSuggestions returns the suggested tags of note as HTML.
+func (p Page) htmlSuggestions(note Note) string {
+	suggestions := p.Suggestions[note.ID]
+	if len(suggestions) == 0 {
+		return ""
+	}
+	html := "        <div class='suggestions'>"
+	for _, suggestion := range suggestions {
+		html += "<span class='suggestion'>#" + suggestion.Name + "</span> "
+	}
+	return html + "</div>"
+}
@@ -0,0 +1,39 @@
+/*
+This file is part of Tessernote.
+
+Tessernote is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+Tessernote is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with Tessernote.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package tessernote
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestHtmlTagsInboxIsNotATag(t *testing.T) {
+	p := Page{Tags: []Tag{{Name: "Inbox"}, {Name: "go"}}, UntaggedNotes: true}
+	html := string(p.HtmlTags())
+	for _, div := range []string{
+		"<div class='tag page' path='/'>All Notes</div>",
+		"<div class='tag page' path='/untagged/'>Untagged Notes</div>",
+		"<div class='tag page' path='/inbox/'>Inbox</div>",
+		"<div class='tag' tag='Inbox'>Inbox</div>",
+		"<div class='tag' tag='go'>go</div>",
+	} {
+		if !strings.Contains(html, div) {
+			t.Errorf("expected %s in %s", div, html)
+		}
+	}
+}
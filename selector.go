@@ -0,0 +1,110 @@
+/*
+This file is part of Tessernote.
+
+Tessernote is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+Tessernote is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with Tessernote.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package tessernote
+
+import (
+	"github.com/oschmid/tessernote/hashtag"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	AnyValue       = "*"
+	RangeSeparator = ".."
+	DateFormat     = "2006-01-02"
+)
+
+// Selector selects Tags by name. Key-value tags can also be selected by key (e.g. status:*) or by a range of
+// values (e.g. priority:1..3, due:2013-01-01..2013-02-01, or open ended size:2..).
+type Selector struct {
+	Key       string
+	Value     string // exact value, empty if selecting any value or a range
+	AnyValue  bool
+	Low, High string // range bounds, empty if unbounded
+	Range     bool
+}
+
+// ParseSelector parses a tag selector.
+func ParseSelector(s string) Selector {
+	key, value := hashtag.SplitValue(s)
+	selector := Selector{Key: key}
+	if value == AnyValue {
+		selector.AnyValue = true
+	} else if i := strings.Index(value, RangeSeparator); i >= 0 {
+		selector.Range = true
+		selector.Low, selector.High = value[:i], value[i+len(RangeSeparator):]
+	} else {
+		selector.Value = value
+	}
+	return selector
+}
+
+// Exact returns true if this Selector selects a single Tag by name.
+func (selector Selector) Exact() bool {
+	return !selector.AnyValue && !selector.Range
+}
+
+// Name returns the name of the Tag selected by an exact Selector.
+func (selector Selector) Name() string {
+	if selector.Value == "" {
+		return selector.Key
+	}
+	return selector.Key + hashtag.ValueSeparator + selector.Value
+}
+
+// Matches returns true if tag is selected by this Selector.
+func (selector Selector) Matches(tag Tag) bool {
+	if selector.Exact() {
+		return tag.foldedName() == hashtag.Fold(selector.Name())
+	}
+	key, value := hashtag.SplitValue(tag.Name)
+	if value == "" || hashtag.Fold(key) != hashtag.Fold(selector.Key) {
+		return false
+	}
+	if selector.AnyValue {
+		return true
+	}
+	return (selector.Low == "" || compareValues(selector.Low, value) <= 0) &&
+		(selector.High == "" || compareValues(value, selector.High) <= 0)
+}
+
+// compareValues compares two tag values as numbers if they both are numbers, as dates if they both are dates, or
+// otherwise as strings. It returns -1 if a < b, 0 if a == b, and 1 if a > b.
+func compareValues(a, b string) int {
+	if x, err := strconv.ParseFloat(a, 64); err == nil {
+		if y, err := strconv.ParseFloat(b, 64); err == nil {
+			return compareFloats(x, y)
+		}
+	}
+	if x, err := time.Parse(DateFormat, a); err == nil {
+		if y, err := time.Parse(DateFormat, b); err == nil {
+			return compareFloats(float64(x.Unix()), float64(y.Unix()))
+		}
+	}
+	return strings.Compare(hashtag.Fold(a), hashtag.Fold(b))
+}
+
+func compareFloats(x, y float64) int {
+	if x < y {
+		return -1
+	} else if x > y {
+		return 1
+	}
+	return 0
+}
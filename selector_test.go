@@ -0,0 +1,89 @@
+/*
+This file is part of Tessernote.
+
+Tessernote is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+Tessernote is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with Tessernote.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package tessernote
+
+import (
+	"testing"
+)
+
+func TestParseSelector(t *testing.T) {
+	tests := []struct {
+		s        string
+		selector Selector
+		exact    bool
+		name     string
+	}{
+		{"go", Selector{Key: "go"}, true, "go"},
+		{"status:done", Selector{Key: "status", Value: "done"}, true, "status:done"},
+		{"status:*", Selector{Key: "status", AnyValue: true}, false, ""},
+		{"priority:1..3", Selector{Key: "priority", Low: "1", High: "3", Range: true}, false, ""},
+		{"size:2..", Selector{Key: "size", Low: "2", Range: true}, false, ""},
+		{"due:..2013-02-01", Selector{Key: "due", High: "2013-02-01", Range: true}, false, ""},
+		{"-go", Selector{Key: "-go"}, true, "-go"}, // there are no negated selectors, this is just a name
+		{"!status:done", Selector{Key: "!status", Value: "done"}, true, "!status:done"},
+	}
+	for _, test := range tests {
+		selector := ParseSelector(test.s)
+		if selector != test.selector {
+			t.Errorf("%s: expected=%#v actual=%#v", test.s, test.selector, selector)
+		}
+		if selector.Exact() != test.exact {
+			t.Errorf("%s: expected exact=%t", test.s, test.exact)
+		}
+		if test.exact && selector.Name() != test.name {
+			t.Errorf("%s: expected=%s actual=%s", test.s, test.name, selector.Name())
+		}
+	}
+}
+
+func TestSelectorMatches(t *testing.T) {
+	tests := []struct {
+		selector string
+		tag      string
+		matches  bool
+	}{
+		{"go", "go", true},
+		{"go", "Go", true},
+		{"go", "golang", false},
+		{"go", "go:1", false},
+		{"status:done", "Status:DONE", true},
+		{"status:done", "status:open", false},
+		{"status:*", "status:done", true},
+		{"status:*", "status", false},
+		{"status:*", "statuses:done", false},
+		{"priority:1..3", "priority:2", true},
+		{"priority:1..3", "priority:2.5", true},
+		{"priority:1..3", "priority:3", true},
+		{"priority:1..3", "priority:10", false}, // compared as numbers, not strings
+		{"size:2..", "size:100", true},
+		{"size:2..", "size:1", false},
+		{"due:2013-01-01..2013-02-01", "due:2013-01-15", true},
+		{"due:2013-01-01..2013-02-01", "due:2013-02-02", false},
+		{"due:..2013-02-01", "due:2012-12-31", true},
+		{"client:a..m", "client:Acme", true},
+		{"client:a..m", "client:zeta", false},
+		{"-go", "go", false},
+		{"!status:done", "status:done", false},
+	}
+	for _, test := range tests {
+		matches := ParseSelector(test.selector).Matches(Tag{Name: test.tag})
+		if matches != test.matches {
+			t.Errorf("%s matches %s: expected=%t actual=%t", test.selector, test.tag, test.matches, matches)
+		}
+	}
+}
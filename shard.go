@@ -0,0 +1,360 @@
+/*
+This file is part of Tessernote.
+
+Tessernote is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+Tessernote is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with Tessernote.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package tessernote
+
+import (
+	"appengine"
+	"appengine/datastore"
+	"github.com/oschmid/cachestore"
+	"sort"
+	"strconv"
+)
+
+const (
+	shardSize = 500 // Keys per shard, well below the datastore's entity size limit
+
+	// kinds of shards, Notebook shards and Tag shards can be queried separately by ancestor
+	notebookShardKind = "NotebookKeyShard"
+	tagShardKind      = "TagKeyShard"
+)
+
+// keyShard is a datastore entity holding part of a list of Keys that would be too long for one entity.
+type keyShard struct {
+	List  string // name of the list, e.g. NoteKeys
+	Index int
+	Keys  []*datastore.Key `datastore:",noindex"`
+}
+
+// keyShards remembers which shard each Key of a list is stored in so that only the shards that changed have to be
+// written. New Keys are appended to the last shard so a list keeps its order.
+type keyShards struct {
+	shards  [][]*datastore.Key // as stored
+	shardOf map[string]int     // encoded Key -> index in shards
+}
+
+// newKeyShards creates the shards of an empty list.
+func newKeyShards() *keyShards {
+	return &keyShards{shardOf: make(map[string]int)}
+}
+
+// load adds a stored shard.
+func (s *keyShards) load(shard keyShard) {
+	for len(s.shards) <= shard.Index {
+		s.shards = append(s.shards, nil)
+	}
+	s.shards[shard.Index] = shard.Keys
+	for _, key := range shard.Keys {
+		s.shardOf[key.Encode()] = shard.Index
+	}
+}
+
+// count returns the number of shards, including ones that were emptied.
+func (s *keyShards) count() int {
+	return len(s.shards)
+}
+
+// stored returns true if any shards were loaded or synced.
+func (s *keyShards) stored() bool {
+	return len(s.shards) > 0
+}
+
+// keys returns the Keys of all shards in order.
+func (s *keyShards) keys() []*datastore.Key {
+	keys := make([]*datastore.Key, 0, len(s.shardOf))
+	for _, shard := range s.shards {
+		keys = append(keys, shard...)
+	}
+	return keys
+}
+
+// sync updates the shards to hold exactly keys and returns the indexes of the shards that changed.
+func (s *keyShards) sync(keys []*datastore.Key) []int {
+	changed := make(map[int]bool)
+	current := make(map[string]bool, len(keys))
+	for _, key := range keys {
+		current[key.Encode()] = true
+	}
+	for encoded, i := range s.shardOf {
+		if !current[encoded] {
+			delete(s.shardOf, encoded)
+			changed[i] = true
+		}
+	}
+	for i := range changed {
+		var kept []*datastore.Key
+		for _, key := range s.shards[i] {
+			if j, ok := s.shardOf[key.Encode()]; ok && j == i {
+				kept = append(kept, key)
+			}
+		}
+		s.shards[i] = kept
+	}
+	for _, key := range keys {
+		encoded := key.Encode()
+		if _, ok := s.shardOf[encoded]; ok {
+			continue
+		}
+		last := len(s.shards) - 1
+		if last < 0 || len(s.shards[last]) >= shardSize {
+			s.shards = append(s.shards, nil)
+			last++
+		}
+		s.shards[last] = append(s.shards[last], key)
+		s.shardOf[encoded] = last
+		changed[last] = true
+	}
+	indexes := make([]int, 0, len(changed))
+	for i := range changed {
+		indexes = append(indexes, i)
+	}
+	sort.Ints(indexes)
+	return indexes
+}
+
+// shardBatch collects the changes to shards of several lists so they can be written together.
+type shardBatch struct {
+	putKeys []*datastore.Key
+	puts    []keyShard
+	deletes []*datastore.Key
+}
+
+// sync updates shards to hold exactly keys and adds the shards that changed to this batch. The shards are stored as
+// children of owner.
+func (b *shardBatch) sync(shards *keyShards, keys []*datastore.Key, kind, list string, owner *datastore.Key, c appengine.Context) {
+	b.add(shards, shards.sync(keys), kind, list, owner, c)
+}
+
+// add adds the shards with indexes changed to this batch, see sync.
+func (b *shardBatch) add(shards *keyShards, changed []int, kind, list string, owner *datastore.Key, c appengine.Context) {
+	for _, i := range changed {
+		key := shardKey(kind, list, i, owner, c)
+		if len(shards.shards[i]) == 0 {
+			b.deletes = append(b.deletes, key)
+		} else {
+			b.putKeys = append(b.putKeys, key)
+			b.puts = append(b.puts, keyShard{list, i, shards.shards[i]})
+		}
+	}
+}
+
+// commit writes the changed shards to the datastore.
+func (b *shardBatch) commit(c appengine.Context) error {
+	if len(b.putKeys) > 0 {
+		if Debug {
+			c.Debugf("updating key shards: %d", len(b.putKeys))
+		}
+		_, err := putEntities(c, b.putKeys, b.puts)
+		if err != nil {
+			c.Errorf("updating key shards: %s", err)
+			return err
+		}
+	}
+	if len(b.deletes) > 0 {
+		if Debug {
+			c.Debugf("deleting key shards: %d", len(b.deletes))
+		}
+		err := deleteEntities(c, b.deletes)
+		if err != nil {
+			c.Errorf("deleting key shards: %s", err)
+			return err
+		}
+	}
+	return nil
+}
+
+// shardKey returns the Key of the shard with index i of a list stored below owner.
+func shardKey(kind, list string, i int, owner *datastore.Key, c appengine.Context) *datastore.Key {
+	return datastore.NewKey(c, kind, list+"."+strconv.Itoa(i), 0, owner)
+}
+
+// shardKeys returns the Keys of the first n shards of a list stored below owner.
+func shardKeys(kind, list string, n int, owner *datastore.Key, c appengine.Context) []*datastore.Key {
+	keys := make([]*datastore.Key, n)
+	for i := range keys {
+		keys[i] = shardKey(kind, list, i, owner, c)
+	}
+	return keys
+}
+
+// getKeyShards reads the shards with keys and returns the ones that exist with their Keys. Shards that were emptied
+// are deleted, see shardBatch.
+func getKeyShards(keys []*datastore.Key, c appengine.Context) ([]*datastore.Key, []keyShard, error) {
+	if len(keys) == 0 {
+		return nil, nil, nil
+	}
+	shards := make([]keyShard, len(keys))
+	err := cachestore.GetMulti(c, keys, shards)
+	if multi, ok := err.(appengine.MultiError); ok {
+		for _, err := range multi {
+			if err != nil && err != datastore.ErrNoSuchEntity {
+				c.Errorf("getting key shards: %s", err)
+				return nil, nil, err
+			}
+		}
+	} else if err != nil {
+		c.Errorf("getting key shards: %s", err)
+		return nil, nil, err
+	}
+	var found []*datastore.Key
+	var foundShards []keyShard
+	for i := range shards {
+		if shards[i].List != "" {
+			found = append(found, keys[i])
+			foundShards = append(foundShards, shards[i])
+		}
+	}
+	return found, foundShards, nil
+}
+
+// deleteKeyShards deletes all shards of a kind below ancestor.
+func deleteKeyShards(kind string, ancestor *datastore.Key, c appengine.Context) error {
+	keys, err := datastore.NewQuery(kind).Ancestor(ancestor).KeysOnly().GetAll(c, nil)
+	if err != nil {
+		c.Errorf("getting key shards: %s", err)
+		return err
+	}
+	err = deleteEntities(c, keys)
+	if err != nil {
+		c.Errorf("deleting key shards: %s", err)
+	}
+	return err
+}
+
+// loadKeyShards replaces this Notebook's lists of Keys with the ones stored in shards. Notebooks saved before lists
+// were sharded keep the lists stored in the Notebook entity until they're saved again.
+func (notebook *Notebook) loadKeyShards(c appengine.Context) error {
+	notebook.noteShards, notebook.tagShards, notebook.untaggedShards = newKeyShards(), newKeyShards(), newKeyShards()
+	key := notebook.Key(c)
+	keys := shardKeys(notebookShardKind, "NoteKeys", notebook.NoteKeyShards, key, c)
+	keys = append(keys, shardKeys(notebookShardKind, "TagKeys", notebook.TagKeyShards, key, c)...)
+	keys = append(keys, shardKeys(notebookShardKind, "UntaggedNoteKeys", notebook.UntaggedKeyShards, key, c)...)
+	_, shards, err := getKeyShards(keys, c)
+	if err != nil {
+		return err
+	}
+	for _, shard := range shards {
+		switch shard.List {
+		case "NoteKeys":
+			notebook.noteShards.load(shard)
+		case "TagKeys":
+			notebook.tagShards.load(shard)
+		case "UntaggedNoteKeys":
+			notebook.untaggedShards.load(shard)
+		}
+	}
+	if notebook.noteShards.stored() || notebook.tagShards.stored() || notebook.untaggedShards.stored() {
+		notebook.NoteKeys = notebook.noteShards.keys()
+		notebook.TagKeys = notebook.tagShards.keys()
+		notebook.UntaggedNoteKeys = notebook.untaggedShards.keys()
+	}
+	return nil
+}
+
+// syncKeyShards updates the shards of this Notebook's lists of Keys and their counts, and returns the shards that
+// changed to be written after the Notebook.
+func (notebook *Notebook) syncKeyShards(c appengine.Context) *shardBatch {
+	if notebook.noteShards == nil {
+		notebook.noteShards, notebook.tagShards, notebook.untaggedShards = newKeyShards(), newKeyShards(), newKeyShards()
+	}
+	key := notebook.Key(c)
+	batch := new(shardBatch)
+	batch.sync(notebook.noteShards, notebook.NoteKeys, notebookShardKind, "NoteKeys", key, c)
+	batch.sync(notebook.tagShards, notebook.TagKeys, notebookShardKind, "TagKeys", key, c)
+	batch.sync(notebook.untaggedShards, notebook.UntaggedNoteKeys, notebookShardKind, "UntaggedNoteKeys", key, c)
+	notebook.NoteKeyShards = notebook.noteShards.count()
+	notebook.TagKeyShards = notebook.tagShards.count()
+	notebook.UntaggedKeyShards = notebook.untaggedShards.count()
+	return batch
+}
+
+// loadTagKeyShards replaces the Note Keys of tags (with tagKeys) with the ones stored in shards. Tags whose Note Keys
+// were already read are skipped, so Tags can be read without them and their shards only when they're needed. Tags
+// saved before their Note Keys were sharded keep them stored in the Tag entity until they're saved again.
+func loadTagKeyShards(tagKeys []*datastore.Key, tags []Tag, c appengine.Context) error {
+	var keys []*datastore.Key
+	for i := range tags {
+		if tags[i].noteShards == nil {
+			keys = append(keys, shardKeys(tagShardKind, "NoteKeys", tags[i].NoteKeyShards, tagKeys[i], c)...)
+		}
+	}
+	keys, shards, err := getKeyShards(keys, c)
+	if err != nil {
+		return err
+	}
+	byTag := make(map[string]*keyShards) // encoded Tag Key -> its shards
+	for i, shard := range shards {
+		tagKey := keys[i].Parent().Encode()
+		if byTag[tagKey] == nil {
+			byTag[tagKey] = newKeyShards()
+		}
+		byTag[tagKey].load(shard)
+	}
+	for i := range tags {
+		if tags[i].noteShards != nil {
+			continue
+		}
+		if s, ok := byTag[tagKeys[i].Encode()]; ok {
+			tags[i].noteShards = s
+			tags[i].NoteKeys = s.keys()
+		} else {
+			tags[i].noteShards = newKeyShards()
+		}
+	}
+	return nil
+}
+
+// putTags writes tags to the datastore with their Note Keys in shards. Only the shards that changed are written.
+// Tags whose Note Keys weren't read keep their shards, see loadTagKeyShards.
+func putTags(c appengine.Context, keys []*datastore.Key, tags []Tag) ([]*datastore.Key, error) {
+	entities := make([]Tag, len(tags))
+	changed := make([][]int, len(tags))
+	for i := range tags {
+		if tags[i].noteShards != nil {
+			changed[i] = tags[i].noteShards.sync(tags[i].NoteKeys)
+			tags[i].NoteKeyShards = tags[i].noteShards.count()
+		}
+		entities[i] = tags[i]
+		entities[i].NoteKeys = nil // stored in shards
+	}
+	keys, err := putEntities(c, keys, entities)
+	if err != nil {
+		return keys, err
+	}
+	batch := new(shardBatch)
+	for i := range tags {
+		if tags[i].noteShards != nil {
+			batch.add(tags[i].noteShards, changed[i], tagShardKind, "NoteKeys", keys[i], c)
+		}
+	}
+	return keys, batch.commit(c)
+}
+
+// deleteTags deletes the Tags with keys from the datastore together with their shards.
+func deleteTags(c appengine.Context, keys []*datastore.Key) error {
+	err := deleteEntities(c, keys)
+	if err != nil {
+		return err
+	}
+	for _, key := range keys {
+		err = deleteKeyShards(tagShardKind, key, c)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
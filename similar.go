@@ -0,0 +1,115 @@
+/*
+This file is part of Tessernote.
+
+Tessernote is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+Tessernote is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with Tessernote.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package tessernote
+
+import (
+	"appengine"
+	"appengine/datastore"
+	"github.com/oschmid/cachestore"
+	"github.com/oschmid/tessernote/tfidf"
+)
+
+const (
+	MaxSimilarNotes     = 5
+	MinSimilarity       = 0.2
+	DuplicateSimilarity = 0.9
+)
+
+// similarityKey returns the datastore.Key of this Notebook's similarity index.
+func (notebook Notebook) similarityKey(c appengine.Context) *datastore.Key {
+	return datastore.NewKey(c, "SimilarityIndex", "", 1, notebook.Key(c))
+}
+
+// SimilarNotes returns the Notes in this Notebook with the most similar content to note, most similar first.
+func (notebook *Notebook) SimilarNotes(note Note, c appengine.Context) ([]Note, error) {
+	return notebook.similarNotes(note, MaxSimilarNotes, MinSimilarity, c)
+}
+
+// Duplicates returns the Notes in this Notebook that are so similar to note that they are likely duplicates.
+func (notebook *Notebook) Duplicates(note Note, c appengine.Context) ([]Note, error) {
+	return notebook.similarNotes(note, MaxSimilarNotes, DuplicateSimilarity, c)
+}
+
+// similarNotes returns up to n Notes that are at least min similar to note.
+func (notebook *Notebook) similarNotes(note Note, n int, min float64, c appengine.Context) ([]Note, error) {
+	index, err := notebook.similarityIndex(c)
+	if err != nil {
+		return nil, err
+	}
+	matches := index.Similar(noteWords(note), note.ID, n, min)
+	keys := make([]*datastore.Key, 0, len(matches))
+	for _, match := range matches {
+		key, err := datastore.DecodeKey(match.ID)
+		if err == nil && notebook.hasNote(key) {
+			keys = append(keys, key)
+		}
+	}
+	notes := make([]Note, len(keys))
+	if len(keys) > 0 {
+		err = cachestore.GetMulti(c, keys, notes)
+		if err != nil {
+			c.Errorf("getting similar notes: %s", err)
+			return nil, err
+		}
+		for i := range notes {
+			notes[i].ID = keys[i].Encode()
+		}
+	}
+	return notes, nil
+}
+
+// similarityIndex returns this Notebook's similarity index. If it doesn't exist yet all Notes are indexed, see
+// buildModel.
+func (notebook *Notebook) similarityIndex(c appengine.Context) (*tfidf.Index, error) {
+	if notebook.similarity != nil {
+		return notebook.similarity, nil
+	}
+	index := tfidf.New()
+	err := notebook.readModel(notebook.similarityModel(index, c), c)
+	if err == nil {
+		notebook.similarity = index
+		return index, nil
+	}
+	if err != datastore.ErrNoSuchEntity {
+		c.Errorf("getting similarity index: %s", err)
+		return nil, err
+	}
+	index = tfidf.New()
+	err = notebook.buildModel(notebook.similarityKey(c), index, func(note Note) {
+		index.Add(note.ID, noteWords(note))
+	}, c)
+	if err == errStaleModel {
+		return index, nil // good enough for now, but not to be updated
+	} else if err != nil {
+		c.Errorf("building similarity index: %s", err)
+		return nil, err
+	}
+	notebook.similarity = index
+	return index, nil
+}
+
+// similarityModel returns how index is stored and updated as this Notebook's similarity index.
+func (notebook *Notebook) similarityModel(index *tfidf.Index, c appengine.Context) learnedModel {
+	return learnedModel{notebook.similarityKey(c), index, func(change modelChange) {
+		if change.Deleted {
+			index.Remove(change.Note)
+		} else {
+			index.Add(change.Note, change.Words)
+		}
+	}}
+}
@@ -1,17 +1,28 @@
 var notesURL = '/notes/'
 
 function filterByTag(e) {
-    if ($(this).text() == 'All Notes') {
-        location.pathname = '/'
-    } else if ($(this).text() == 'Untagged Notes') {
-        location.pathname = '/untagged/'
+    var tag = $(this).attr('tag') || $(this).text()
+    if ($(this).attr('path')) {
+        location.pathname = $(this).attr('path')
     } else if (e.shiftKey && location.pathname != '/') {
-        location.pathname += ',' + $(this).text()
+        location.pathname += ',' + tag
     } else {
-        location.pathname = '/' + $(this).text()
+        location.pathname = '/' + tag
     }
 }
 
+function addSuggestedTag(e) {
+    e.stopPropagation();
+    div = $(this).closest('div.note')
+    textarea = div.children('textarea:first')
+    note = new Object();
+    note.ID = textarea.attr('noteid')
+    note.Body = textarea.attr('value') + ' ' + $(this).text()
+    $.ajax({url:notesURL+note.ID, type:"PUT", data:JSON.stringify(note), success: function(response) {
+        div.remove()
+    }});
+}
+
 function showDelete() {
     $(this).children("div.delete:first").show();
 }
@@ -61,4 +72,5 @@ $(document).ready(function() {
     $('div.note').not('#new').mouseenter(showDelete).mouseleave(hideDelete);
     $('div.delete').click(deleteNote);
     $('input.save').click(saveNote);
+    $('span.suggestion').click(addSuggestedTag);
 })
\ No newline at end of file
@@ -0,0 +1,127 @@
+/*
+This file is part of Tessernote.
+
+Tessernote is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+Tessernote is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with Tessernote.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package tessernote
+
+import (
+	"appengine"
+	"appengine/datastore"
+	"github.com/oschmid/tessernote/bayes"
+	"github.com/oschmid/tessernote/hashtag"
+)
+
+const (
+	MaxSuggestions          = 3
+	MinSuggestionLikelihood = 0.1
+)
+
+// Suggestion is a Tag that is likely to fit a Note.
+type Suggestion struct {
+	Name       string
+	Likelihood float64
+}
+
+// classifierKey returns the datastore.Key of this Notebook's tag classifier.
+func (notebook Notebook) classifierKey(c appengine.Context) *datastore.Key {
+	return datastore.NewKey(c, "Classifier", "", 1, notebook.Key(c))
+}
+
+// SuggestTags returns the Tags that are most likely to fit note based on the other Notes in this Notebook. Tags
+// the note already has are not suggested.
+func (notebook *Notebook) SuggestTags(note Note, c appengine.Context) ([]Suggestion, error) {
+	classifier, err := notebook.tagClassifier(c)
+	if err != nil {
+		return nil, err
+	}
+	allTags, err := notebook.lazyTags(c)
+	if err != nil {
+		return nil, err
+	}
+	var suggestions []Suggestion
+	scores := classifier.Classify(noteWords(note), MaxSuggestions+len(note.TagKeys))
+	for _, score := range scores {
+		if score.Probability < MinSuggestionLikelihood || len(suggestions) == MaxSuggestions {
+			break
+		}
+		key, err := datastore.DecodeKey(score.Class)
+		if err != nil || containsKey(note.TagKeys, key) {
+			continue
+		}
+		i := notebook.indexOfTagKey(key)
+		if i < 0 {
+			continue // tag was deleted or merged
+		}
+		suggestions = append(suggestions, Suggestion{allTags[i].Name, score.Probability})
+	}
+	return suggestions, nil
+}
+
+// noteWords returns the words of a Note's body without its hashtags.
+func noteWords(note Note) []string {
+	return bayes.Words(hashtag.Replace(note.Body, func(hashtag.Span) string {
+		return " "
+	}))
+}
+
+// noteClasses returns the classes of a Note for the tag classifier, i.e. its encoded tag Keys.
+func noteClasses(note Note) []string {
+	classes := make([]string, len(note.TagKeys))
+	for i, key := range note.TagKeys {
+		classes[i] = key.Encode()
+	}
+	return classes
+}
+
+// tagClassifier returns this Notebook's tag classifier. If it doesn't exist yet it is trained on all Notes, see
+// buildModel.
+func (notebook *Notebook) tagClassifier(c appengine.Context) (*bayes.Classifier, error) {
+	if notebook.classifier != nil {
+		return notebook.classifier, nil
+	}
+	classifier := bayes.New()
+	err := notebook.readModel(notebook.classifierModel(classifier, c), c)
+	if err == nil {
+		notebook.classifier = classifier
+		return classifier, nil
+	}
+	if err != datastore.ErrNoSuchEntity {
+		c.Errorf("getting tag classifier: %s", err)
+		return nil, err
+	}
+	classifier = bayes.New()
+	err = notebook.buildModel(notebook.classifierKey(c), classifier, func(note Note) {
+		classifier.Learn(noteWords(note), noteClasses(note))
+	}, c)
+	if err == errStaleModel {
+		return classifier, nil // good enough for now, but not to be updated
+	} else if err != nil {
+		c.Errorf("training tag classifier: %s", err)
+		return nil, err
+	}
+	notebook.classifier = classifier
+	return classifier, nil
+}
+
+// classifierModel returns how classifier is stored and updated as this Notebook's tag classifier.
+func (notebook *Notebook) classifierModel(classifier *bayes.Classifier, c appengine.Context) learnedModel {
+	return learnedModel{notebook.classifierKey(c), classifier, func(change modelChange) {
+		if len(change.OldClasses) > 0 || len(change.Classes) > 0 {
+			classifier.Forget(change.OldWords, change.OldClasses)
+			classifier.Learn(change.Words, change.Classes)
+		}
+	}}
+}
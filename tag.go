@@ -22,15 +22,102 @@ import (
 	"appengine/datastore"
 	"github.com/oschmid/cachestore"
 	"github.com/oschmid/tessernote/hashtag"
-	"strings"
-	"unicode"
 )
 
 type Tag struct {
-	Name         string // unique per Notebook
-	NotebookKeys []*datastore.Key
-	NoteKeys     []*datastore.Key
-	ChildKeys    []*datastore.Key
+	Name           string // display name, the most common spelling
+	FoldedName     string // hashtag.Fold(Name), unique per Notebook
+	Spellings      []string
+	SpellingCounts []int // number of Notes using each of Spellings
+	NotebookKeys   []*datastore.Key
+	NoteKeys       []*datastore.Key // stored in shards, see putTags
+	NoteKeyShards  int
+	ChildKeys      []*datastore.Key
+	noteSet        *keySet // index of NoteKeys, see keySetOf
+	noteShards     *keyShards
+}
+
+// foldedName returns the name that identifies this Tag in its Notebook.
+func (tag Tag) foldedName() string {
+	if tag.FoldedName == "" {
+		return hashtag.Fold(tag.Name)
+	}
+	return tag.FoldedName
+}
+
+// addSpelling counts another Note using spelling for this Tag and updates its display name.
+func (tag *Tag) addSpelling(spelling string) {
+	i := indexOfString(tag.Spellings, spelling)
+	if i < 0 {
+		tag.Spellings = append(tag.Spellings, spelling)
+		tag.SpellingCounts = append(tag.SpellingCounts, 1)
+	} else {
+		tag.SpellingCounts[i]++
+	}
+	tag.updateName()
+}
+
+// removeSpelling counts one less Note using spelling for this Tag and updates its display name.
+func (tag *Tag) removeSpelling(spelling string) {
+	i := indexOfString(tag.Spellings, spelling)
+	if i < 0 {
+		return
+	}
+	tag.SpellingCounts[i]--
+	if tag.SpellingCounts[i] <= 0 {
+		tag.Spellings = append(tag.Spellings[:i], tag.Spellings[i+1:]...)
+		tag.SpellingCounts = append(tag.SpellingCounts[:i], tag.SpellingCounts[i+1:]...)
+	}
+	tag.updateName()
+}
+
+// noteKeySet returns the set of this Tag's Note Keys.
+func (tag *Tag) noteKeySet() *keySet {
+	return keySetOf(&tag.noteSet, tag.NoteKeys)
+}
+
+// addNoteKey makes this Tag refer to the Note with key.
+func (tag *Tag) addNoteKey(key *datastore.Key) {
+	set := tag.noteKeySet()
+	set.Add(key)
+	tag.NoteKeys = set.Keys()
+}
+
+// removeNoteKey stops this Tag from referring to the Note with key.
+func (tag *Tag) removeNoteKey(key *datastore.Key) {
+	set := tag.noteKeySet()
+	set.Remove(key)
+	tag.NoteKeys = set.Keys()
+}
+
+// mergeSpellings adds the spellings used for other to this Tag and updates its display name.
+func (tag *Tag) mergeSpellings(other Tag) {
+	for j, spelling := range other.Spellings {
+		if other.SpellingCounts[j] <= 0 {
+			continue
+		}
+		i := indexOfString(tag.Spellings, spelling)
+		if i < 0 {
+			tag.Spellings = append(tag.Spellings, spelling)
+			tag.SpellingCounts = append(tag.SpellingCounts, other.SpellingCounts[j])
+		} else {
+			tag.SpellingCounts[i] += other.SpellingCounts[j]
+		}
+	}
+	tag.updateName()
+}
+
+// updateName sets this Tag's display name to its most common spelling. Ties keep the current name.
+func (tag *Tag) updateName() {
+	best := indexOfString(tag.Spellings, tag.Name)
+	for i, count := range tag.SpellingCounts {
+		if best < 0 || count > tag.SpellingCounts[best] {
+			best = i
+		}
+	}
+	if best >= 0 {
+		tag.Name = tag.Spellings[best]
+	}
 }
 
 // Notebooks returns all Notebooks that share this Tag.
@@ -51,8 +138,8 @@ func (tag Tag) Notes(c appengine.Context) ([]Note, error) {
 		c.Errorf("getting tag notes: %s", err)
 		return notes, err
 	}
-	for i, note := range notes {
-		note.ID = tag.NoteKeys[i].Encode()
+	for i := range notes {
+		notes[i].ID = tag.NoteKeys[i].Encode()
 	}
 	return notes, nil
 }
@@ -67,49 +154,81 @@ func (tag Tag) Children(c appengine.Context) ([]Tag, error) {
 	return children, err
 }
 
-// RelatedNotes returns the union of all Notes referred to by a set of Tags.
+// RelatedNotes returns the Notes referred to by every one of a set of Tags.
 func RelatedNotes(tags []Tag, c appengine.Context) ([]Note, error) {
-	if len(tags) == 0 {
-		return *new([]Note), nil
+	groups := make([][]Tag, len(tags))
+	for i := range tags {
+		groups[i] = tags[i : i+1]
 	}
+	return MatchingNotes(groups, c)
+}
 
-	noteKeys := tags[0].NoteKeys
-	for i := 1; i < len(tags); i++ {
-		noteKeys = unionKeys(noteKeys, tags[i].NoteKeys)
+// MatchingNotes returns the Notes referred to by at least one Tag of every group of Tags.
+func MatchingNotes(groups [][]Tag, c appengine.Context) ([]Note, error) {
+	if len(groups) == 0 {
+		return *new([]Note), nil
 	}
 
+	noteKeys := matchingNoteKeys(groups).Keys()
 	notes, err := make([]Note, len(noteKeys)), *new(error)
 	if len(noteKeys) > 0 {
 		err = cachestore.GetMulti(c, noteKeys, notes)
 		if err != nil {
 			c.Errorf("getting related notes: %s", err)
 		}
+		for i := range notes {
+			notes[i].ID = noteKeys[i].Encode()
+		}
 	}
 	return notes, err
 }
 
+// matchingNoteKeys returns the Keys of Notes referred to by at least one Tag of every group of Tags, or nil if there
+// are no groups.
+func matchingNoteKeys(groups [][]Tag) *keySet {
+	if len(groups) == 0 {
+		return nil
+	}
+	noteKeys := groupNoteKeys(groups[0])
+	for i := 1; i < len(groups); i++ {
+		noteKeys = noteKeys.Intersect(groupNoteKeys(groups[i]))
+	}
+	return noteKeys
+}
+
+// groupNoteKeys returns the Keys of Notes referred to by any of tags.
+func groupNoteKeys(tags []Tag) *keySet {
+	if len(tags) == 1 {
+		return tags[0].noteKeySet()
+	}
+	noteKeys := newKeySet(nil)
+	for i := range tags {
+		noteKeys.Union(tags[i].noteKeySet())
+	}
+	return noteKeys
+}
+
 // ParseTagNames parses a string for hashtags.
 func ParseTagNames(text string) []string {
-	var names []string
-	matches := hashtag.Regex.FindAllString(text, len(text))
-	for _, match := range matches {
-		name := strings.TrimLeftFunc(match, isHashtagDecoration)
-		names = append(names, name)
-	}
-	return names
+	return hashtag.Names(text)
 }
 
-// isHashtagDecoration returns true for hash characters (#) and whitespace
-func isHashtagDecoration(r rune) bool {
-	return r == '#' || r == '\uFF03' || unicode.IsSpace(r)
+// IsTagName returns true if name is a valid hashtag name (i.e. without its hash mark).
+func IsTagName(name string) bool {
+	names := ParseTagNames("#" + name)
+	return len(names) == 1 && names[0] == name
 }
 
 // NewTag creates a new Tag for a Note in a Notebook
 func NewTag(name string, note Note, notebook Notebook, c appengine.Context) *Tag {
 	tag := new(Tag)
 	tag.Name = name
+	tag.FoldedName = hashtag.Fold(name)
+	tag.Spellings = []string{name}
+	tag.SpellingCounts = []int{1}
 	tag.NotebookKeys = []*datastore.Key{notebook.Key(c)}
 	tag.NoteKeys = []*datastore.Key{note.Key(c)}
+	tag.noteShards = newKeyShards()
 	return tag
 }
 
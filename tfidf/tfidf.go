@@ -0,0 +1,139 @@
+/*
+This file is part of Tessernote.
+
+Tessernote is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+Tessernote is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with Tessernote.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+// Package tfidf finds similar documents by the cosine similarity of their TF-IDF weighted term vectors.
+package tfidf
+
+import (
+	"math"
+	"sort"
+)
+
+type Index struct {
+	Terms     map[string]map[string]int // document ID -> term -> count
+	Documents map[string]int            // term -> number of documents containing it
+}
+
+// Match is a document similar to another document.
+type Match struct {
+	ID         string
+	Similarity float64 // between 0 and 1
+}
+
+// New creates an empty Index.
+func New() *Index {
+	return &Index{
+		Terms:     make(map[string]map[string]int),
+		Documents: make(map[string]int),
+	}
+}
+
+// Add adds or replaces the document with id.
+func (index *Index) Add(id string, words []string) {
+	index.Remove(id)
+	terms := make(map[string]int)
+	for _, word := range words {
+		terms[word]++
+	}
+	if len(terms) == 0 {
+		return
+	}
+	for term := range terms {
+		index.Documents[term]++
+	}
+	index.Terms[id] = terms
+}
+
+// Remove removes the document with id.
+func (index *Index) Remove(id string) {
+	for term := range index.Terms[id] {
+		index.Documents[term]--
+		if index.Documents[term] <= 0 {
+			delete(index.Documents, term)
+		}
+	}
+	delete(index.Terms, id)
+}
+
+// Similar returns the n documents most similar to the document made of words, most similar first. Documents less
+// similar than min and the document with id exclude are left out.
+func (index *Index) Similar(words []string, exclude string, n int, min float64) []Match {
+	terms := make(map[string]int)
+	for _, word := range words {
+		terms[word]++
+	}
+	query := index.vector(terms)
+	var matches []Match
+	for id, terms := range index.Terms {
+		if id == exclude {
+			continue
+		}
+		similarity := cosine(query, index.vector(terms))
+		if similarity >= min && similarity > 0 {
+			matches = append(matches, Match{id, similarity})
+		}
+	}
+	sort.Sort(bySimilarity(matches))
+	if len(matches) > n {
+		matches = matches[:n]
+	}
+	return matches
+}
+
+// vector returns the TF-IDF weights of terms.
+func (index *Index) vector(terms map[string]int) map[string]float64 {
+	vector := make(map[string]float64, len(terms))
+	documents := float64(len(index.Terms) + 1)
+	for term, count := range terms {
+		idf := 1 + math.Log(documents/float64(index.Documents[term]+1)) // smoothed, always positive
+		vector[term] = float64(count) * idf
+	}
+	return vector
+}
+
+// cosine returns the cosine of the angle between vectors a and b.
+func cosine(a, b map[string]float64) float64 {
+	var dot, normA, normB float64
+	for term, x := range a {
+		dot += x * b[term]
+		normA += x * x
+	}
+	for _, y := range b {
+		normB += y * y
+	}
+	if normA == 0 || normB == 0 {
+		return 0
+	}
+	return dot / math.Sqrt(normA*normB)
+}
+
+type bySimilarity []Match
+
+func (s bySimilarity) Len() int {
+	return len(s)
+}
+
+func (s bySimilarity) Less(i, j int) bool {
+	if s[i].Similarity == s[j].Similarity {
+		return s[i].ID < s[j].ID
+	}
+	return s[i].Similarity > s[j].Similarity
+}
+
+func (s bySimilarity) Swap(i, j int) {
+	s[i], s[j] = s[j], s[i]
+}
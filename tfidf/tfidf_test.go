@@ -0,0 +1,84 @@
+/*
+This file is part of Tessernote.
+
+Tessernote is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+Tessernote is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with Tessernote.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package tfidf
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestSimilar(t *testing.T) {
+	index := New()
+	index.Add("milk", strings.Fields("buy milk eggs and bread"))
+	index.Add("bread", strings.Fields("buy bread and butter"))
+	index.Add("parser", strings.Fields("fix the parser bug"))
+	index.Add("empty", nil)
+
+	matches := index.Similar(strings.Fields("buy milk and eggs"), "", 5, 0.1)
+	if len(matches) != 2 || matches[0].ID != "milk" || matches[1].ID != "bread" {
+		t.Fatalf("unexpected matches %v", matches)
+	}
+	if matches[0].Similarity <= matches[1].Similarity || matches[0].Similarity > 1 {
+		t.Fatalf("unexpected similarities %v", matches)
+	}
+
+	matches = index.Similar(strings.Fields("buy milk eggs and bread"), "milk", 5, 0)
+	if len(matches) != 1 || matches[0].ID != "bread" {
+		t.Fatalf("expected excluded document to be left out, actual=%v", matches)
+	}
+	matches = index.Similar(strings.Fields("buy milk eggs and bread"), "", 1, 0.99)
+	if len(matches) != 1 || matches[0].ID != "milk" {
+		t.Fatalf("expected identical document, actual=%v", matches)
+	}
+	if matches := index.Similar(strings.Fields("unrelated words"), "", 5, 0); len(matches) != 0 {
+		t.Fatalf("expected no matches, actual=%v", matches)
+	}
+}
+
+func TestAddRemove(t *testing.T) {
+	index := New()
+	index.Add("a", strings.Fields("one two two"))
+	index.Add("b", strings.Fields("two three"))
+	index.Add("a", strings.Fields("three"))
+	index.Remove("b")
+	index.Remove("missing")
+
+	expected := New()
+	expected.Add("a", strings.Fields("three"))
+	if !reflect.DeepEqual(index, expected) {
+		t.Fatalf("expected=%v actual=%v", expected, index)
+	}
+}
+
+func TestCosine(t *testing.T) {
+	tests := []struct {
+		a, b     map[string]float64
+		expected float64
+	}{
+		{map[string]float64{"x": 1}, map[string]float64{"x": 2}, 1},
+		{map[string]float64{"x": 1}, map[string]float64{"y": 1}, 0},
+		{map[string]float64{"x": 1, "y": 1}, map[string]float64{"x": 1}, 1 / 1.4142135623730951},
+		{map[string]float64{}, map[string]float64{"x": 1}, 0},
+	}
+	for _, test := range tests {
+		if actual := cosine(test.a, test.b); actual-test.expected > 1e-9 || test.expected-actual > 1e-9 {
+			t.Errorf("cosine(%v, %v) expected=%v actual=%v", test.a, test.b, test.expected, actual)
+		}
+	}
+}
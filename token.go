@@ -0,0 +1,89 @@
+/*
+This file is part of Tessernote.
+
+Tessernote is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+Tessernote is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with Tessernote.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package tessernote
+
+import (
+	"appengine"
+	"appengine/datastore"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"strings"
+)
+
+// Kinds of access tokens. Each kind only gives access to what it's named after, so e.g. a feed token pasted into
+// a feed reader can't be used to change notes.
+const (
+	APIToken  = "api" // the notes, import, export and admin APIs, e.g. for tn and client.Client
+	DAVToken  = "dav"
+	FeedToken = "feed"
+)
+
+var (
+	TokenKinds      = []string{APIToken, DAVToken, FeedToken}
+	ErrInvalidToken = errors.New("tessernote: invalid token")
+)
+
+// Token returns this Notebook's secret token of kind, creating it if it doesn't have one. Tokens let programs that
+// can't log in (e.g. WebDAV clients) access a Notebook, see NotebookByToken.
+func (notebook *Notebook) Token(kind string, c appengine.Context) (string, error) {
+	prefix := kind + ":"
+	for _, token := range notebook.Tokens {
+		if strings.HasPrefix(token, prefix) {
+			return token[len(prefix):], nil
+		}
+	}
+	return notebook.ResetToken(kind, c)
+}
+
+// ResetToken replaces this Notebook's token of kind with a new one, so the old one stops working.
+func (notebook *Notebook) ResetToken(kind string, c appengine.Context) (string, error) {
+	b := make([]byte, 20)
+	_, err := rand.Read(b)
+	if err != nil {
+		return "", err
+	}
+	secret := hex.EncodeToString(b)
+	err = notebook.runInTransaction(c, func(tc appengine.Context) error {
+		var tokens []string
+		for _, token := range notebook.Tokens {
+			if !strings.HasPrefix(token, kind+":") {
+				tokens = append(tokens, token)
+			}
+		}
+		notebook.Tokens = append(tokens, kind+":"+secret)
+		return notebook.save(tc)
+	})
+	return secret, err
+}
+
+// NotebookByToken returns the Notebook with the secret token of kind. Returns ErrInvalidToken if there isn't one.
+func NotebookByToken(kind, token string, c appengine.Context) (*Notebook, error) {
+	if token == "" {
+		return nil, ErrInvalidToken
+	}
+	keys, err := datastore.NewQuery("Notebook").Filter("Tokens =", kind+":"+token).KeysOnly().Limit(1).GetAll(c, nil)
+	if err != nil {
+		c.Errorf("getting notebook by token: %s", err)
+		return nil, err
+	}
+	if len(keys) == 0 {
+		return nil, ErrInvalidToken
+	}
+	return NotebookOf(keys[0].StringID(), c)
+}